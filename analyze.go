@@ -0,0 +1,360 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// AnalysisReport is a summary of a fast-import stream, similar in
+// spirit to the output of "git filter-repo --analyze", but computed
+// directly from the commands in the stream.
+//
+// All sizes are in bytes.  Blobs that are referred to by SHA-1 rather
+// than by mark (or that are referred to by a mark that was not
+// defined in the stream) have an unknown size, and are counted as
+// zero.
+type AnalysisReport struct {
+	Commands map[string]int `json:"commands"` // count of each command, by stream keyword
+
+	BlobCount     int        `json:"blob_count"`
+	BlobTotalSize int64      `json:"blob_total_size"`
+	LargestBlobs  []BlobStat `json:"largest_blobs"`
+
+	LargestPaths []PathStat `json:"largest_paths"` // by total size of all versions
+	DeletedPaths []PathStat `json:"deleted_paths"` // paths not present on any ref at the end, by largest version
+	LongestPaths []PathStat `json:"longest_paths"` // by length of the path in bytes
+	DeepestPaths []PathStat `json:"deepest_paths"` // by number of directory components
+
+	Authors  map[string]int `json:"authors"`  // commits per "Name <email>"
+	Branches map[string]int `json:"branches"` // commits per ref
+	Commits  int            `json:"commits"`
+	Merges   int            `json:"merges"`
+}
+
+// BlobStat describes a single blob in an AnalysisReport.
+type BlobStat struct {
+	Mark        int    `json:"mark,omitempty"`
+	OriginalOID string `json:"original_oid,omitempty"`
+	Size        int64  `json:"size"`
+}
+
+// PathStat describes a single path in an AnalysisReport.
+type PathStat struct {
+	Path      Path  `json:"path"`
+	Versions  int   `json:"versions"`   // number of times content was set
+	MaxSize   int64 `json:"max_size"`   // size of the largest version
+	TotalSize int64 `json:"total_size"` // sum of the sizes of all versions
+	Length    int   `json:"length"`
+	Depth     int   `json:"depth"`
+	Deleted   bool  `json:"deleted"` // not present on any ref at the end
+}
+
+// WriteJSON writes the report to w as indented JSON.
+func (r *AnalysisReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(r)
+}
+
+// An Analyzer accumulates statistics about the commands passed to
+// it.  It does not require a git repository; everything is computed
+// from the stream itself.
+//
+// The tree of every ref is tracked, so that a path that is deleted on
+// one branch isn't taken to be deleted on the others.
+type Analyzer struct {
+	topN int
+
+	commands  map[string]int
+	blobs     []BlobStat
+	blobSizes map[string]int64 // by the ID that replay has for the blob
+	blobTotal int64
+	replay    *treeReplay
+	paths     map[Path]*pathInfo
+	authors   map[string]int
+	branches  map[string]int
+	commits   int
+	merges    int
+}
+
+type pathInfo struct {
+	versions int
+	maxSize  int64
+	total    int64
+}
+
+// NewAnalyzer creates a new Analyzer.  The "largest" and "outlier"
+// lists in the resulting report are limited to topN entries each; if
+// topN is < 1, a default of 10 is used.
+func NewAnalyzer(topN int) *Analyzer {
+	if topN < 1 {
+		topN = 10
+	}
+	return &Analyzer{
+		topN:      topN,
+		commands:  make(map[string]int),
+		blobSizes: make(map[string]int64),
+		replay:    newTreeReplay(),
+		paths:     make(map[Path]*pathInfo),
+		authors:   make(map[string]int),
+		branches:  make(map[string]int),
+	}
+}
+
+// Analyze reads every command from the Frontend, and returns a report
+// on them.
+func Analyze(f *Frontend, topN int) (*AnalysisReport, error) {
+	a := NewAnalyzer(topN)
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if err := a.Do(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return a.Report(), nil
+}
+
+// cmdName returns the keyword that introduces cmd in a fast-import
+// stream.  CmdCommitEnd, which has no keyword, is "commit-end".
+func cmdName(cmd Cmd) string {
+	switch cmd.(type) {
+	case CmdBlob:
+		return "blob"
+	case CmdCommit:
+		return "commit"
+	case CmdCommitEnd:
+		return "commit-end"
+	case CmdTag:
+		return "tag"
+	case CmdReset:
+		return "reset"
+	case CmdAlias:
+		return "alias"
+	case CmdCheckpoint:
+		return "checkpoint"
+	case CmdProgress:
+		return "progress"
+	case CmdFeature:
+		return "feature"
+	case CmdOption:
+		return "option"
+	case CmdDone:
+		return "done"
+	case CmdComment:
+		return "comment"
+	case CmdGetMark:
+		return "get-mark"
+	case CmdCatBlob:
+		return "cat-blob"
+	case CmdLs:
+		return "ls"
+	case FileModify, FileModifyInline:
+		return "M"
+	case FileDelete:
+		return "D"
+	case FileCopy:
+		return "C"
+	case FileRename:
+		return "R"
+	case FileDeleteAll:
+		return "deleteall"
+	case NoteModify, NoteModifyInline:
+		return "N"
+	default:
+		return "unknown"
+	}
+}
+
+// Do records the given command.  It never returns an error; it has an
+// error return so that an Analyzer may be used anywhere a Backend is.
+func (a *Analyzer) Do(cmd Cmd) error {
+	if _, isEnd := cmd.(CmdCommitEnd); !isEnd {
+		a.commands[cmdName(cmd)]++
+	}
+	// Copies and renames are of the files in the tree before the
+	// command.
+	switch cmd := cmd.(type) {
+	case FileCopy:
+		a.copyPaths(cmd.Src, cmd.Dst)
+	case FileRename:
+		a.copyPaths(cmd.Src, cmd.Dst)
+	}
+	a.replay.Do(cmd)
+
+	switch cmd := cmd.(type) {
+	case CmdBlob:
+		size := int64(len(cmd.Data))
+		if cmd.Mark > 0 {
+			a.blobSizes[a.replay.blobID(markRef(cmd.Mark))] = size
+		}
+		a.blobTotal += size
+		a.blobs = append(a.blobs, BlobStat{Mark: cmd.Mark, OriginalOID: cmd.OriginalOID, Size: size})
+		if len(a.blobs) > 2*a.topN {
+			a.blobs = topBlobs(a.blobs, a.topN)
+		}
+	case CmdCommit:
+		a.commits++
+		if len(cmd.Merge) > 0 {
+			a.merges++
+		}
+		a.branches[cmd.Ref]++
+		ident := cmd.Committer
+		if cmd.Author != nil {
+			ident = *cmd.Author
+		}
+		if ident.Name == "" {
+			a.authors["<"+ident.Email+">"]++
+		} else {
+			a.authors[ident.Name+" <"+ident.Email+">"]++
+		}
+	case FileModify:
+		if parseMarkRef(cmd.DataRef) > 0 {
+			a.setPath(cmd.Path, a.blobSizes[a.replay.blobID(cmd.DataRef)])
+		} else {
+			a.setPath(cmd.Path, 0)
+		}
+	case FileModifyInline:
+		a.setPath(cmd.Path, int64(len(cmd.Data)))
+	}
+	return nil
+}
+
+// copyPaths records a new version of every file that a copy (or
+// rename) from src to dst, in the commit in progress, creates.
+func (a *Analyzer) copyPaths(src, dst Path) {
+	e, ok := a.replay.cur.get(src)
+	if !ok {
+		return
+	}
+	if e.Dir == nil {
+		a.setPath(dst, a.blobSizes[e.ID])
+		return
+	}
+	e.Dir.walk(func(p Path, e treeEntry) error {
+		if dst == "" {
+			a.setPath(p, a.blobSizes[e.ID])
+		} else {
+			a.setPath(dst+"/"+p, a.blobSizes[e.ID])
+		}
+		return nil
+	})
+}
+
+func (a *Analyzer) setPath(p Path, size int64) {
+	info := a.paths[p]
+	if info == nil {
+		info = &pathInfo{}
+		a.paths[p] = info
+	}
+	info.versions++
+	info.total += size
+	if size > info.maxSize {
+		info.maxSize = size
+	}
+}
+
+func topBlobs(blobs []BlobStat, n int) []BlobStat {
+	sort.SliceStable(blobs, func(i, j int) bool { return blobs[i].Size > blobs[j].Size })
+	if len(blobs) > n {
+		blobs = blobs[:n]
+	}
+	return blobs
+}
+
+func topPaths(stats []PathStat, n int, less func(a, b PathStat) bool) []PathStat {
+	sorted := make([]PathStat, len(stats))
+	copy(sorted, stats)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// Report returns a report on all of the commands that have been
+// passed to Do so far.
+func (a *Analyzer) Report() *AnalysisReport {
+	r := &AnalysisReport{
+		Commands:      make(map[string]int, len(a.commands)),
+		BlobCount:     a.commands["blob"],
+		BlobTotalSize: a.blobTotal,
+		LargestBlobs:  topBlobs(append([]BlobStat(nil), a.blobs...), a.topN),
+		Authors:       make(map[string]int, len(a.authors)),
+		Branches:      make(map[string]int, len(a.branches)),
+		Commits:       a.commits,
+		Merges:        a.merges,
+	}
+	for k, v := range a.commands {
+		r.Commands[k] = v
+	}
+	for k, v := range a.authors {
+		r.Authors[k] = v
+	}
+	for k, v := range a.branches {
+		r.Branches[k] = v
+	}
+
+	// A path is deleted if it isn't in the tree of any ref.
+	live := make(map[Path]bool)
+	walked := make(map[*tree]bool)
+	for _, t := range a.replay.tips {
+		if walked[t] {
+			continue
+		}
+		walked[t] = true
+		t.walk(func(p Path, _ treeEntry) error {
+			live[p] = true
+			return nil
+		})
+	}
+
+	var all, deleted []PathStat
+	for p, info := range a.paths {
+		stat := PathStat{
+			Path:      p,
+			Versions:  info.versions,
+			MaxSize:   info.maxSize,
+			TotalSize: info.total,
+			Length:    len(p),
+			Depth:     strings.Count(string(p), "/"),
+			Deleted:   !live[p],
+		}
+		all = append(all, stat)
+		if stat.Deleted {
+			deleted = append(deleted, stat)
+		}
+	}
+	// Sort by path first, so that ties are broken deterministically.
+	sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].Path < deleted[j].Path })
+
+	r.LargestPaths = topPaths(all, a.topN, func(x, y PathStat) bool { return x.TotalSize > y.TotalSize })
+	r.DeletedPaths = topPaths(deleted, a.topN, func(x, y PathStat) bool { return x.MaxSize > y.MaxSize })
+	r.LongestPaths = topPaths(all, a.topN, func(x, y PathStat) bool { return x.Length > y.Length })
+	r.DeepestPaths = topPaths(all, a.topN, func(x, y PathStat) bool { return x.Depth > y.Depth })
+
+	return r
+}
@@ -0,0 +1,127 @@
+// Tests for analyzer
+
+package libfastimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyze(t *testing.T) {
+	input := `blob
+mark :1
+data 5
+test
+blob
+mark :2
+data 11
+0123456789
+reset refs/heads/main
+commit refs/heads/main
+mark :3
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 test.txt
+M 100644 :2 a/b/c/big.bin
+M 100644 :1 old.txt
+
+commit refs/heads/dev
+mark :4
+author Other Person <other@example.com> 1644399074 +0000
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+delete
+from :3
+D a
+D old.txt
+
+commit refs/heads/main
+mark :5
+author Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+committer Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 6
+merge
+from :3
+merge :4
+D old.txt
+M 100644 inline test.txt
+data 3
+abc
+
+`
+
+	report, err := Analyze(NewFrontend(strings.NewReader(input), nil, nil), 2)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, report.Commands["blob"])
+	assert.Equal(t, 3, report.Commands["commit"])
+	assert.Equal(t, 1, report.Commands["reset"])
+	assert.Equal(t, 4, report.Commands["M"])
+	assert.Equal(t, 3, report.Commands["D"])
+	assert.Equal(t, 0, report.Commands["commit-end"])
+
+	assert.Equal(t, 2, report.BlobCount)
+	assert.Equal(t, int64(16), report.BlobTotalSize)
+	assert.Equal(t, []BlobStat{{Mark: 2, Size: 11}, {Mark: 1, Size: 5}}, report.LargestBlobs)
+
+	assert.Equal(t, 3, report.Commits)
+	assert.Equal(t, 1, report.Merges)
+	assert.Equal(t, map[string]int{"refs/heads/main": 2, "refs/heads/dev": 1}, report.Branches)
+	assert.Equal(t, map[string]int{
+		"Robert Cowham <rcowham@perforce.com>": 2,
+		"Other Person <other@example.com>":     1,
+	}, report.Authors)
+
+	assert.Len(t, report.LargestPaths, 2)
+	assert.Equal(t, Path("a/b/c/big.bin"), report.LargestPaths[0].Path)
+	assert.Equal(t, Path("test.txt"), report.LargestPaths[1].Path)
+	assert.Equal(t, 2, report.LargestPaths[1].Versions)
+	assert.Equal(t, int64(8), report.LargestPaths[1].TotalSize)
+
+	// a/b/c/big.bin is only deleted on dev; main still has it.
+	assert.Len(t, report.DeletedPaths, 1)
+	assert.Equal(t, Path("old.txt"), report.DeletedPaths[0].Path)
+	assert.Equal(t, int64(5), report.DeletedPaths[0].MaxSize)
+	assert.False(t, report.LargestPaths[0].Deleted)
+
+	assert.Equal(t, Path("a/b/c/big.bin"), report.LongestPaths[0].Path)
+	assert.Equal(t, 3, report.DeepestPaths[0].Depth)
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, report.WriteJSON(buf))
+	var decoded AnalysisReport
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *report, decoded)
+}
+
+func TestAnalyzeRename(t *testing.T) {
+	input := `blob
+mark :1
+data 5
+test
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 d/x.txt
+
+commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+rename
+R d e
+
+`
+	report, err := Analyze(NewFrontend(strings.NewReader(input), nil, nil), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []PathStat{{Path: "d/x.txt", Versions: 1, MaxSize: 5, TotalSize: 5, Length: 7, Depth: 1, Deleted: true}}, report.DeletedPaths)
+	assert.Contains(t, report.LargestPaths, PathStat{Path: "e/x.txt", Versions: 1, MaxSize: 5, TotalSize: 5, Length: 7, Depth: 1})
+}
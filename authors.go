@@ -0,0 +1,48 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReadAuthors reads an authors file, in the format used by
+// git-svn and git-cvsimport: a line of "user = Name <email>" for each
+// user.  Blank lines, and lines starting with "#", are ignored.
+func ReadAuthors(r io.Reader) (map[string]Ident, error) {
+	authors := make(map[string]Ident)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, errors.Errorf("authors: line %d: expected \"user = Name <email>\": %q", lineno, line)
+		}
+		ident, err := parseNameEmail(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "authors: line %d", lineno)
+		}
+		authors[strings.TrimSpace(line[:eq])] = ident
+	}
+	return authors, scanner.Err()
+}
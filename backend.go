@@ -31,15 +31,31 @@ import (
 // given that name because the GetMark, CatBlob, and Ls methods
 // actually provide 2-way communication.
 type Backend struct {
-	fastImportClose io.Closer
+	fastImportClose io.WriteCloser
 	fastImportFlush *bufio.Writer
 	fastImportWrite *textproto.FIWriter
 	catBlob         *textproto.CatBlobReader
 
 	inCommit bool
 
-	err   error
-	onErr func(error) error
+	// If batch is > 0, the buffer is only flushed when it has at
+	// least that many bytes in it; see SetBatch.
+	batch       int
+	delimitText bool
+
+	// Nested comments (see Preserved) that are waiting for the
+	// command that they are in.  afresh is whether the last
+	// command was written afresh, rather than as its original
+	// text.
+	nested []*Preserved
+	afresh bool
+
+	// Set by StartGitFastImport.
+	git *gitFastImport
+
+	closed bool
+	err    error
+	onErr  func(error) error
 }
 
 // NewBackend creates a new Backend object that writes to the given
@@ -66,9 +82,12 @@ func NewBackend(fastImport io.WriteCloser, catBlob io.Reader, onErr func(error)
 
 		// Close the underlying writer, but don't let the
 		// error mask the previous error.
-		err = ret.fastImportClose.Close()
-		if ret.err == nil {
-			ret.err = err
+		if !ret.closed {
+			ret.closed = true
+			err = ret.fastImportClose.Close()
+			if ret.err == nil {
+				ret.err = err
+			}
 		}
 
 		if onErr != nil {
@@ -80,6 +99,71 @@ func NewBackend(fastImport io.WriteCloser, catBlob io.Reader, onErr func(error)
 	return ret
 }
 
+// SetDelimitText sets whether text data (commit messages, and the
+// content of text files) is written in the delimited format ("data
+// <<EOF") rather than with an exact byte count; see
+// textproto.FIWriter.SetDelimitText.  This makes streams easier for
+// humans to read.
+func (b *Backend) SetDelimitText(delimitText bool) {
+	b.delimitText = delimitText
+	b.fastImportWrite.SetDelimitText(delimitText)
+}
+
+// SetBatch sets whether writes are batched.  Normally, the stream is
+// flushed to the underlying writer after every command.  If threshold
+// is > 0, it is instead flushed only once at least threshold bytes
+// are waiting, on CmdCheckpoint and CmdDone, before the commands sent
+// by GetMark, CatBlob and Ls (which wait for a response), and when
+// Flush or Close is called.  This makes far fewer writes to the
+// underlying writer, which is much faster for streams of many small
+// commands.
+func (b *Backend) SetBatch(threshold int) error {
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	b.batch = threshold
+	if threshold > 0 {
+		// Big enough that bufio doesn't flush by itself first.
+		b.fastImportFlush = bufio.NewWriterSize(b.fastImportClose, threshold+4096)
+	} else {
+		b.fastImportFlush = bufio.NewWriter(b.fastImportClose)
+	}
+	b.fastImportWrite = textproto.NewFIWriter(b.fastImportFlush)
+	b.fastImportWrite.SetDelimitText(b.delimitText)
+	return nil
+}
+
+// Flush writes any commands that are waiting in the buffer to the
+// underlying writer; see SetBatch.
+func (b *Backend) Flush() error {
+	if b.err != nil || b.closed {
+		return b.err
+	}
+	if err := b.fastImportFlush.Flush(); err != nil {
+		return b.onErr(err)
+	}
+	return nil
+}
+
+// Close closes the underlying writer, unless a CmdDone or an error
+// already has, and returns the Backend's error, if any.
+func (b *Backend) Close() error {
+	if b.closed {
+		return b.err
+	}
+	if b.err == nil {
+		// Nested comments at the end of the stream, with no
+		// command for them to be in.
+		if _, err := b.writeNested(nil); err != nil {
+			return b.onErr(err)
+		}
+	}
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	return b.onErr(nil)
+}
+
 // Do tells the Backend to do the given command.
 //
 // It is an error (panic) if Cmd is a type that may only be used in a
@@ -96,11 +180,18 @@ func (b *Backend) Do(cmd Cmd) error {
 		panic(errors.Errorf("Cannot issue commit sub-command outside of a commit: %[1]T(%#[1]v)", cmd))
 	}
 
-	err := cmd.fiCmdWrite(b.fastImportWrite)
+	err := b.write(cmd)
 	if err != nil {
 		return b.onErr(err)
 	}
-	err = b.fastImportFlush.Flush()
+	if b.batch <= 0 || b.fastImportFlush.Buffered() >= b.batch {
+		err = b.fastImportFlush.Flush()
+	} else {
+		switch cmd.(type) {
+		case CmdCheckpoint, CmdDone, CmdGetMark, CmdCatBlob, CmdLs:
+			err = b.fastImportFlush.Flush()
+		}
+	}
 	if err != nil {
 		return b.onErr(err)
 	}
@@ -112,6 +203,86 @@ func (b *Backend) Do(cmd Cmd) error {
 	return nil
 }
 
+// write writes the command; as its original text if it has a
+// Preserved and has not been modified.
+func (b *Backend) write(cmd Cmd) error {
+	p := cmdPreserved(cmd)
+	if p != nil && !p.unmodified(cmd) {
+		p = nil
+	}
+
+	if p != nil && p.isNested {
+		// Wait to see if the command that it's in gets
+		// written too.
+		b.nested = append(b.nested, p)
+		return nil
+	}
+	if _, isEnd := cmd.(CmdCommitEnd); isEnd && p != nil {
+		// A CmdCommitEnd has no text of its own, except for
+		// blank lines at the end of the stream; any nested
+		// comments that are waiting are in the next command.
+		return b.writeRaw(p, nil)
+	}
+
+	// Any nested comments that aren't in this command are written
+	// on their own, first.
+	var mine map[*Preserved]bool
+	if p != nil {
+		mine = make(map[*Preserved]bool)
+		for _, part := range p.parts {
+			if part.nested != nil {
+				mine[part.nested] = true
+			}
+		}
+	}
+	written, err := b.writeNested(mine)
+	if err != nil {
+		return err
+	}
+
+	if p != nil {
+		return b.writeRaw(p, func(n *Preserved) bool { return written[n] })
+	}
+	if p := cmdPreserved(cmd); p != nil {
+		// Modified, but keep the blank lines before it.
+		prefix := p.prefix
+		if b.afresh && p.afterData {
+			prefix = prefix[1:]
+		}
+		if _, err := io.WriteString(b.fastImportFlush, prefix); err != nil {
+			return err
+		}
+	}
+	b.afresh = true
+	return cmd.fiCmdWrite(b.fastImportWrite)
+}
+
+// writeNested writes the nested comments that are waiting, except
+// for those in mine, which it returns instead.
+func (b *Backend) writeNested(mine map[*Preserved]bool) (map[*Preserved]bool, error) {
+	written := make(map[*Preserved]bool)
+	for _, n := range b.nested {
+		if mine[n] {
+			written[n] = true
+		} else if err := b.writeRaw(n, nil); err != nil {
+			return nil, err
+		}
+	}
+	b.nested = nil
+	return written, nil
+}
+
+// writeRaw writes the original text of a command.
+func (b *Backend) writeRaw(p *Preserved, nested func(*Preserved) bool) error {
+	if err := p.writeTo(b.fastImportFlush, b.afresh, nested); err != nil {
+		return err
+	}
+	if !p.empty() {
+		b.afresh = false
+	}
+	return nil
+}
+
 // GetMark gets the SHA-1 referred to by the given mark from the
 // Backend.
 //
@@ -10,6 +10,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -181,3 +182,139 @@ R %s dst.txt
 	outstr := outbuf.String()
 	assert.Equal(t, strings.Split(input, "\n"), strings.Split(outstr, "\n"))
 }
+
+func TestWriteData(t *testing.T) {
+	cmds := []Cmd{
+		CmdBlob{Mark: 1, Data: ""},
+		CmdBlob{Mark: 2, Data: "no newline"},
+		CmdBlob{Mark: 3, Data: "line\nEOF\nEOF1\n"},
+		CmdBlob{Mark: 4, Data: "nul\x00\n"},
+		CmdCommit{
+			Ref:       "refs/heads/main",
+			Committer: Ident{Name: "Robert Cowham", Email: "rcowham@perforce.com", Time: time.Unix(1644399073, 0).UTC()},
+			Msg:       "",
+		},
+		CmdCommitEnd{},
+	}
+	write := func(delimit bool) string {
+		outbuf := new(bytes.Buffer)
+		bw := bufio.NewWriter(outbuf)
+		backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+		backend.SetDelimitText(delimit)
+		for _, cmd := range cmds {
+			assert.Nil(t, backend.Do(cmd))
+		}
+		bw.Flush()
+		return outbuf.String()
+	}
+
+	counted := write(false)
+	assert.Equal(t, "blob\nmark :1\ndata 0\n\n"+
+		"blob\nmark :2\ndata 10\nno newline\n"+
+		"blob\nmark :3\ndata 14\nline\nEOF\nEOF1\n"+
+		"blob\nmark :4\ndata 5\nnul\x00\n"+
+		"commit refs/heads/main\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 0\n\n\n", counted)
+
+	delimited := write(true)
+	assert.Equal(t, "blob\nmark :1\ndata 0\n\n"+
+		"blob\nmark :2\ndata 10\nno newline\n"+
+		"blob\nmark :3\ndata <<EOF2\nline\nEOF\nEOF1\nEOF2\n"+
+		"blob\nmark :4\ndata 5\nnul\x00\n"+
+		"commit refs/heads/main\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 0\n\n\n", delimited)
+
+	// Both read back as the same commands.
+	for _, stream := range []string{counted, delimited} {
+		frontend := NewFrontend(strings.NewReader(stream), nil, nil)
+		for _, expected := range cmds {
+			cmd, err := frontend.ReadCmd()
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(cmd))
+		}
+	}
+}
+
+// countingWriter counts the writes made to it.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func (w *countingWriter) Close() error {
+	return nil
+}
+
+func TestBatch(t *testing.T) {
+	committer := Ident{Name: "Robert Cowham", Email: "rcowham@perforce.com", Time: time.Unix(1644399073, 0).UTC()}
+	w := &countingWriter{}
+	catBlob := strings.NewReader("0123456789012345678901234567890123456789\n")
+	backend := NewBackend(w, catBlob, nil)
+	assert.Nil(t, backend.SetBatch(1000))
+
+	assert.Nil(t, backend.Do(CmdCommit{Ref: "refs/heads/main", Mark: 1, Committer: committer, Msg: "test\n"}))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, backend.Do(FileModify{Mode: 0100644, DataRef: "0123456789012345678901234567890123456789", Path: Path(fmt.Sprintf("file%d.txt", i))}))
+	}
+	assert.Nil(t, backend.Do(CmdCommitEnd{}))
+	assert.Equal(t, 0, w.writes)
+
+	// Waiting for a response flushes.
+	sha1, err := backend.GetMark(CmdGetMark{Mark: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789012345678901234567890123456789", sha1)
+	assert.Equal(t, 1, w.writes)
+
+	assert.Nil(t, backend.Do(CmdProgress{Str: "progress"}))
+	assert.Nil(t, backend.Flush())
+	assert.Equal(t, 2, w.writes)
+	assert.Nil(t, backend.Do(CmdCheckpoint{}))
+	assert.Equal(t, 3, w.writes)
+
+	// The threshold flushes.
+	for i := 0; w.writes == 3; i++ {
+		assert.Nil(t, backend.Do(CmdProgress{Str: fmt.Sprintf("progress %d", i)}))
+	}
+	assert.True(t, w.Len() >= 1000)
+
+	assert.Nil(t, backend.Do(CmdProgress{Str: "last"}))
+	assert.Nil(t, backend.Close())
+	assert.True(t, strings.HasSuffix(w.String(), "progress last\n"))
+}
+
+// benchmarkBackend writes a commit with many small FileModify
+// commands to /dev/null, so that each write is a real system call.
+func benchmarkBackend(b *testing.B, batch int) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	backend := NewBackend(devNull, nil, nil)
+	if err := backend.SetBatch(batch); err != nil {
+		b.Fatal(err)
+	}
+	committer := Ident{Name: "Robert Cowham", Email: "rcowham@perforce.com", Time: time.Unix(1644399073, 0).UTC()}
+	cmds := []Cmd{CmdCommit{Ref: "refs/heads/main", Committer: committer, Msg: "test\n"}}
+	for i := 0; i < 1000; i++ {
+		cmds = append(cmds, FileModify{Mode: 0100644, DataRef: "0123456789012345678901234567890123456789", Path: Path(fmt.Sprintf("dir/file%d.txt", i))})
+	}
+	cmds = append(cmds, CmdCommitEnd{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, cmd := range cmds {
+			if err := backend.Do(cmd); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := backend.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkBackendUnbatched(b *testing.B) { benchmarkBackend(b, 0) }
+func BenchmarkBackendBatched(b *testing.B)   { benchmarkBackend(b, 64*1024) }
@@ -0,0 +1,321 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultNotesRef is the ref that Builder.Notes writes to, if no
+// other is given.
+const DefaultNotesRef = "refs/notes/commits"
+
+// A Builder writes commits, annotated tags and notes to a Backend,
+// allocating marks for them, and for the blobs that they need.
+//
+//	mark, err := builder.Commit("refs/heads/main").
+//		Author("A U Thor", "author@example.com", when).
+//		Message("Initial commit\n").
+//		Add("README", ModeFil, "Hello\n").
+//		Done()
+type Builder struct {
+	backend  *Backend
+	nextMark int
+}
+
+// NewBuilder creates a new Builder that writes to the Backend.  The
+// marks that it allocates start at firstMark (or 1, if firstMark is <
+// 1); no other marks at or above that should be used in the stream.
+func NewBuilder(backend *Backend, firstMark int) *Builder {
+	if firstMark < 1 {
+		firstMark = 1
+	}
+	return &Builder{
+		backend:  backend,
+		nextMark: firstMark,
+	}
+}
+
+func (bld *Builder) mark() int {
+	mark := bld.nextMark
+	bld.nextMark++
+	return mark
+}
+
+// Blob writes a blob, and returns its mark.
+func (bld *Builder) Blob(data string) (int, error) {
+	mark := bld.mark()
+	if err := bld.backend.Do(CmdBlob{Mark: mark, Data: data}); err != nil {
+		return 0, err
+	}
+	return mark, nil
+}
+
+// A CommitBuilder builds a commit; see Builder.Commit.  Nothing is
+// written until Done is called.
+type CommitBuilder struct {
+	bld   *Builder
+	cmd   CmdCommit
+	files []FileChange
+	notes []NoteChange
+	// The data of the blobs to write for Add and Note, by index
+	// in files or notes.
+	fileData map[int]string
+	noteData map[int]string
+	err      error
+}
+
+// Commit starts building a commit on the given ref.
+func (bld *Builder) Commit(ref string) *CommitBuilder {
+	return &CommitBuilder{
+		bld:      bld,
+		cmd:      CmdCommit{Ref: ref},
+		fileData: make(map[int]string),
+		noteData: make(map[int]string),
+	}
+}
+
+// Notes starts building a commit on the given notes ref (or
+// DefaultNotesRef, if it is empty), for adding notes with Note.
+func (bld *Builder) Notes(ref string) *CommitBuilder {
+	if ref == "" {
+		ref = DefaultNotesRef
+	}
+	return bld.Commit(ref)
+}
+
+// Author sets the author of the commit.  If it isn't set, the
+// committer is the author.
+func (c *CommitBuilder) Author(name, email string, when time.Time) *CommitBuilder {
+	c.cmd.Author = &Ident{Name: name, Email: email, Time: when}
+	return c
+}
+
+// Committer sets the committer of the commit.  If it isn't set, the
+// author is the committer.
+func (c *CommitBuilder) Committer(name, email string, when time.Time) *CommitBuilder {
+	c.cmd.Committer = Ident{Name: name, Email: email, Time: when}
+	return c
+}
+
+// Message sets the commit message.
+func (c *CommitBuilder) Message(msg string) *CommitBuilder {
+	c.cmd.Msg = msg
+	return c
+}
+
+// OriginalOID sets the original object ID of the commit.
+func (c *CommitBuilder) OriginalOID(oid string) *CommitBuilder {
+	c.cmd.OriginalOID = oid
+	return c
+}
+
+// Parent adds the commit with the given mark as a parent; the first
+// parent is "from", and the rest are "merge"s.
+func (c *CommitBuilder) Parent(mark int) *CommitBuilder {
+	if mark < 1 {
+		c.setErr(errors.Errorf("Parent: invalid mark: %d", mark))
+		return c
+	}
+	return c.ParentRef(markRef(mark))
+}
+
+// ParentRef is like Parent, but takes any commit-ish: a mark
+// reference, a SHA-1, or a ref.
+func (c *CommitBuilder) ParentRef(commitish string) *CommitBuilder {
+	if c.cmd.From == "" {
+		c.cmd.From = commitish
+	} else {
+		c.cmd.Merge = append(c.cmd.Merge, commitish)
+	}
+	return c
+}
+
+// Add sets the content of the file at path; the blob for data is
+// written before the commit.
+func (c *CommitBuilder) Add(path string, mode Mode, data string) *CommitBuilder {
+	c.fileData[len(c.files)] = data
+	c.files = append(c.files, FileModify{Mode: mode, Path: Path(path)})
+	return c
+}
+
+// AddRef sets the file at path to an existing blob (or, for ModeGit,
+// commit): a mark reference or a SHA-1.
+func (c *CommitBuilder) AddRef(path string, mode Mode, dataref string) *CommitBuilder {
+	c.files = append(c.files, FileModify{Mode: mode, Path: Path(path), DataRef: dataref})
+	return c
+}
+
+// Delete deletes the file or directory at path.
+func (c *CommitBuilder) Delete(path string) *CommitBuilder {
+	c.files = append(c.files, FileDelete{Path: Path(path)})
+	return c
+}
+
+// Rename renames the file or directory at src to dst.
+func (c *CommitBuilder) Rename(src, dst string) *CommitBuilder {
+	c.files = append(c.files, FileRename{Src: Path(src), Dst: Path(dst)})
+	return c
+}
+
+// Copy copies the file or directory at src to dst.
+func (c *CommitBuilder) Copy(src, dst string) *CommitBuilder {
+	c.files = append(c.files, FileCopy{Src: Path(src), Dst: Path(dst)})
+	return c
+}
+
+// DeleteAll deletes every file, so that the commit has only the files
+// that are added after it.
+func (c *CommitBuilder) DeleteAll() *CommitBuilder {
+	c.files = append(c.files, FileDeleteAll{})
+	return c
+}
+
+// Note sets the note for the commit with the given mark; the blob for
+// data is written before the commit.
+func (c *CommitBuilder) Note(mark int, data string) *CommitBuilder {
+	if mark < 1 {
+		c.setErr(errors.Errorf("Note: invalid mark: %d", mark))
+		return c
+	}
+	return c.NoteRef(markRef(mark), data)
+}
+
+// NoteRef is like Note, but takes any commit-ish.
+func (c *CommitBuilder) NoteRef(commitish string, data string) *CommitBuilder {
+	c.noteData[len(c.notes)] = data
+	c.notes = append(c.notes, NoteModify{CommitIsh: commitish})
+	return c
+}
+
+func (c *CommitBuilder) setErr(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Done writes the blobs that the commit needs, then the commit, and
+// returns the commit's mark.
+func (c *CommitBuilder) Done() (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	switch {
+	case c.cmd.Committer == Ident{} && c.cmd.Author == nil:
+		return 0, errors.Errorf("Done: commit to %q has no author or committer", c.cmd.Ref)
+	case c.cmd.Committer == Ident{}:
+		c.cmd.Committer = *c.cmd.Author
+	}
+
+	for i, file := range c.files {
+		if data, ok := c.fileData[i]; ok {
+			mark, err := c.bld.Blob(data)
+			if err != nil {
+				return 0, err
+			}
+			modify := file.(FileModify)
+			modify.DataRef = markRef(mark)
+			c.files[i] = modify
+		}
+	}
+	for i, note := range c.notes {
+		if data, ok := c.noteData[i]; ok {
+			mark, err := c.bld.Blob(data)
+			if err != nil {
+				return 0, err
+			}
+			modify := note.(NoteModify)
+			modify.DataRef = markRef(mark)
+			c.notes[i] = modify
+		}
+	}
+
+	c.cmd.Mark = c.bld.mark()
+	err := c.bld.backend.WriteCommit(Commit{Header: c.cmd, Files: c.files, Notes: c.notes})
+	if err != nil {
+		return 0, err
+	}
+	return c.cmd.Mark, nil
+}
+
+// A TagBuilder builds an annotated tag; see Builder.Tag.  Nothing is
+// written until Done is called.
+type TagBuilder struct {
+	bld *Builder
+	cmd CmdTag
+	err error
+}
+
+// Tag starts building an annotated tag with the given name (without
+// "refs/tags/").
+func (bld *Builder) Tag(name string) *TagBuilder {
+	return &TagBuilder{
+		bld: bld,
+		cmd: CmdTag{RefName: name},
+	}
+}
+
+// Target sets the commit with the given mark as what is tagged.
+func (t *TagBuilder) Target(mark int) *TagBuilder {
+	if mark < 1 {
+		if t.err == nil {
+			t.err = errors.Errorf("Target: invalid mark: %d", mark)
+		}
+		return t
+	}
+	return t.TargetRef(markRef(mark))
+}
+
+// TargetRef is like Target, but takes any commit-ish.
+func (t *TagBuilder) TargetRef(commitish string) *TagBuilder {
+	t.cmd.CommitIsh = commitish
+	return t
+}
+
+// Tagger sets the tagger.
+func (t *TagBuilder) Tagger(name, email string, when time.Time) *TagBuilder {
+	t.cmd.Tagger = Ident{Name: name, Email: email, Time: when}
+	return t
+}
+
+// Message sets the tag message.
+func (t *TagBuilder) Message(msg string) *TagBuilder {
+	t.cmd.Data = msg
+	return t
+}
+
+// OriginalOID sets the original object ID of the tag.
+func (t *TagBuilder) OriginalOID(oid string) *TagBuilder {
+	t.cmd.OriginalOID = oid
+	return t
+}
+
+// Done writes the tag, and returns its mark.
+func (t *TagBuilder) Done() (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if t.cmd.CommitIsh == "" {
+		return 0, errors.Errorf("Done: tag %q has no target", t.cmd.RefName)
+	}
+	t.cmd.Mark = t.bld.mark()
+	if err := t.bld.backend.Do(t.cmd); err != nil {
+		return 0, err
+	}
+	return t.cmd.Mark, nil
+}
@@ -0,0 +1,116 @@
+// Tests for Builder
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilder(t *testing.T) {
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	builder := NewBuilder(NewBackend(&MyWriteCloser{bw}, nil, nil), 0)
+	when := time.Unix(1644399073, 0).UTC()
+
+	first, err := builder.Commit("refs/heads/main").
+		Author("Robert Cowham", "rcowham@perforce.com", when).
+		Message("initial\n").
+		Add("a.txt", ModeFil, "a\n").
+		Add("run.sh", ModeExe, "#!/bin/sh\n").
+		Done()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, first)
+
+	second, err := builder.Commit("refs/heads/main").
+		Author("Robert Cowham", "rcowham@perforce.com", when).
+		Committer("Someone Else", "else@example.com", when.Add(time.Hour)).
+		Message("second\n").
+		Parent(first).
+		ParentRef("refs/heads/other").
+		Delete("a.txt").
+		Rename("run.sh", "bin/run.sh").
+		Copy("bin/run.sh", "run.sh").
+		AddRef("sub", ModeGit, "0123456789012345678901234567890123456789").
+		Done()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, second)
+
+	tag, err := builder.Tag("v1").
+		Target(second).
+		Tagger("Robert Cowham", "rcowham@perforce.com", when).
+		Message("version 1\n").
+		Done()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, tag)
+
+	notes, err := builder.Notes("").
+		Committer("Robert Cowham", "rcowham@perforce.com", when).
+		Message("notes\n").
+		Note(first, "a note\n").
+		Done()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, notes)
+
+	bw.Flush()
+	assert.Equal(t, `blob
+mark :1
+data 2
+a
+blob
+mark :2
+data 10
+#!/bin/sh
+commit refs/heads/main
+mark :3
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 8
+initial
+M 100644 :1 a.txt
+M 100755 :2 run.sh
+
+commit refs/heads/main
+mark :4
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+committer Someone Else <else@example.com> 1644402673 +0000
+data 7
+second
+from :3
+merge refs/heads/other
+D a.txt
+R run.sh bin/run.sh
+C bin/run.sh run.sh
+M 160000 0123456789012345678901234567890123456789 sub
+
+tag v1
+mark :5
+from :4
+tagger Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 10
+version 1
+blob
+mark :6
+data 7
+a note
+commit refs/notes/commits
+mark :7
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 6
+notes
+N :6 :3
+
+`, outbuf.String())
+
+	// Errors.
+	_, err = builder.Commit("refs/heads/main").Message("no one\n").Done()
+	assert.NotNil(t, err)
+	_, err = builder.Commit("refs/heads/main").Author("A", "a@b", when).Parent(0).Done()
+	assert.NotNil(t, err)
+	_, err = builder.Tag("v2").Message("nothing\n").Done()
+	assert.NotNil(t, err)
+}
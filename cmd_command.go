@@ -42,6 +42,8 @@ type CmdCommit struct {
 	Msg         string
 	From        string
 	Merge       []string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdCommit) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -135,7 +137,9 @@ func (CmdCommit) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // it is not really a command in the stream.  It is thus not
 // nescessary to send a CmdCommitEnd command when writing to a
 // Backend. However git 2.x writes a blank line so we do too.
-type CmdCommitEnd struct{}
+type CmdCommitEnd struct {
+	Preserved *Preserved // optional; see Frontend.SetPreserve
+}
 
 func (CmdCommitEnd) fiCmdClass() cmdClass                { return cmdClassInCommit }
 func (CmdCommitEnd) fiCmdWrite(fiw fiWriter) error       { return fiw.WriteLine("") }
@@ -154,6 +158,8 @@ type CmdTag struct {
 	OriginalOID string // optional
 	Tagger      Ident
 	Data        string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdTag) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -221,6 +227,8 @@ func (CmdTag) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type CmdReset struct {
 	RefName   string
 	CommitIsh string // optional
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdReset) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -261,6 +269,8 @@ type CmdBlob struct {
 	Mark        int    // optional
 	OriginalOID string // optional
 	Data        string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdBlob) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -312,6 +322,8 @@ func (CmdBlob) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type CmdAlias struct {
 	Mark      int
 	CommitIsh string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdAlias) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -351,7 +363,9 @@ func (CmdAlias) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // checkpoint //////////////////////////////////////////////////////////////////
 
 // CmdCheckpoint requests that the Backend flush already-sent data.
-type CmdCheckpoint struct{}
+type CmdCheckpoint struct {
+	Preserved *Preserved // optional; see Frontend.SetPreserve
+}
 
 func (c CmdCheckpoint) fiCmdClass() cmdClass { return cmdClassCommand }
 func (c CmdCheckpoint) fiCmdWrite(fiw fiWriter) error {
@@ -372,6 +386,8 @@ func (CmdCheckpoint) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // standard output channel.
 type CmdProgress struct {
 	Str string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdProgress) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -394,6 +410,8 @@ func (CmdProgress) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type CmdFeature struct {
 	Feature  string
 	Argument string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdFeature) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -428,6 +446,8 @@ func (CmdFeature) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // CmdOption requests that the Backend changes its settings.
 type CmdOption struct {
 	Option string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdOption) fiCmdClass() cmdClass { return cmdClassCommand }
@@ -448,7 +468,9 @@ func (CmdOption) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 
 // CmdDone indicates to the Backend that no more commands will be
 // sent.
-type CmdDone struct{}
+type CmdDone struct {
+	Preserved *Preserved // optional; see Frontend.SetPreserve
+}
 
 func (c CmdDone) fiCmdClass() cmdClass { return cmdClassCommand }
 func (c CmdDone) fiCmdWrite(fiw fiWriter) error {
@@ -31,6 +31,8 @@ import (
 // CmdComment is a comment line; not a real command.
 type CmdComment struct {
 	Comment string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdComment) fiCmdClass() cmdClass { return cmdClassComment }
@@ -53,6 +55,8 @@ func (CmdComment) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // given Mark.
 type CmdGetMark struct {
 	Mark int
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdGetMark) fiCmdClass() cmdClass {
@@ -88,6 +92,8 @@ func (CmdGetMark) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // reference (":<idnum>") or by a full 40-byte SHA-1.
 type CmdCatBlob struct {
 	DataRef string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdCatBlob) fiCmdClass() cmdClass {
@@ -122,6 +128,8 @@ func (CmdCatBlob) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type CmdLs struct {
 	DataRef string // optional if inside of a commit
 	Path    Path
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (c CmdLs) fiCmdClass() cmdClass {
@@ -140,12 +148,14 @@ func (c CmdLs) fiCmdClass() cmdClass {
 }
 func (c CmdLs) fiCmdWrite(fiw fiWriter) error {
 	if c.DataRef == "" {
-		return fiw.WriteLine("ls", c.Path)
+		// Without a dataref, the path must be quoted.
+		return fiw.WriteLine("ls", pathQuote(c.Path))
 	} else {
 		return fiw.WriteLine("ls", c.DataRef, c.Path)
 	}
 }
 func init() { parser_registerCmd("ls ", CmdLs{}) }
+
 func (CmdLs) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 	// 'ls' SP <dataref> SP <path> LF
 	line, err := fir.ReadLine()
@@ -155,7 +165,7 @@ func (CmdLs) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 	str := trimLinePrefix(line, "ls ")
 	sp := -1
 	if !strings.HasPrefix(str, "\"") {
-		sp = strings.IndexByte(line, ' ')
+		sp = strings.IndexByte(str, ' ')
 	}
 	c := CmdLs{}
 	c.Path = PathUnescape(str[sp+1:])
@@ -164,3 +174,8 @@ func (CmdLs) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 	}
 	return c, nil
 }
+
+// pathQuote is like PathEscape, but always quotes the path.
+func pathQuote(path Path) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(string(path)) + "\""
+}
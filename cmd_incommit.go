@@ -36,9 +36,12 @@ type FileModify struct {
 	Mode    Mode
 	Path    Path
 	DataRef string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o FileModify) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o FileModify) fiFileChange()        {}
 func (o FileModify) fiCmdWrite(fiw fiWriter) error {
 	return fiw.WriteLine("M", o.Mode, o.DataRef, PathEscape(o.Path))
 }
@@ -97,9 +100,12 @@ type FileModifyInline struct {
 	Mode Mode
 	Path Path
 	Data string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o FileModifyInline) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o FileModifyInline) fiFileChange()        {}
 func (o FileModifyInline) fiCmdWrite(fiw fiWriter) error {
 	ez := &ezfiw{fiw: fiw}
 	ez.WriteLine("M", o.Mode, "inline", PathEscape(o.Path))
@@ -114,9 +120,12 @@ func (FileModifyInline) fiCmdRead(fiReader) (Cmd, error) { panic("not reached")
 // and causes the CmdCommit to recursively remove a file or directory.
 type FileDelete struct {
 	Path Path
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o FileDelete) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o FileDelete) fiFileChange()        {}
 func (o FileDelete) fiCmdWrite(fiw fiWriter) error {
 	return fiw.WriteLine("D", PathEscape(o.Path))
 }
@@ -137,9 +146,12 @@ func (FileDelete) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type FileCopy struct {
 	Src Path
 	Dst Path
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o FileCopy) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o FileCopy) fiFileChange()        {}
 func (o FileCopy) fiCmdWrite(fiw fiWriter) error {
 	return fiw.WriteLine("C", PathEscape(o.Src), PathEscape(o.Dst))
 }
@@ -168,9 +180,12 @@ func (FileCopy) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type FileRename struct {
 	Src Path
 	Dst Path
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o FileRename) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o FileRename) fiFileChange()        {}
 func (o FileRename) fiCmdWrite(fiw fiWriter) error {
 	return fiw.WriteLine("R", PathEscape(o.Src), PathEscape(o.Dst))
 }
@@ -196,9 +211,12 @@ func (FileRename) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 // FileDeleteAll appears after a CmdCommit (and before a
 // CmdCommitEnd), and removes all files and directories from the
 // CmdCommit.
-type FileDeleteAll struct{}
+type FileDeleteAll struct {
+	Preserved *Preserved // optional; see Frontend.SetPreserve
+}
 
 func (o FileDeleteAll) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o FileDeleteAll) fiFileChange()        {}
 func (o FileDeleteAll) fiCmdWrite(fiw fiWriter) error {
 	return fiw.WriteLine("deleteall")
 }
@@ -224,9 +242,12 @@ func (FileDeleteAll) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type NoteModify struct {
 	CommitIsh string
 	DataRef   string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o NoteModify) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o NoteModify) fiNoteChange()        {}
 func (o NoteModify) fiCmdWrite(fiw fiWriter) error {
 	return fiw.WriteLine("N", o.DataRef, o.CommitIsh)
 }
@@ -278,9 +299,12 @@ func (NoteModify) fiCmdRead(fir fiReader) (cmd Cmd, err error) {
 type NoteModifyInline struct {
 	CommitIsh string
 	Data      string
+
+	Preserved *Preserved // optional; see Frontend.SetPreserve
 }
 
 func (o NoteModifyInline) fiCmdClass() cmdClass { return cmdClassInCommit }
+func (o NoteModifyInline) fiNoteChange()        {}
 func (o NoteModifyInline) fiCmdWrite(fiw fiWriter) error {
 	ez := &ezfiw{fiw: fiw}
 	ez.WriteLine("N", "inline", o.CommitIsh)
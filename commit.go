@@ -0,0 +1,121 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"io"
+)
+
+// A FileChange is a command that changes the files of a commit:
+// FileModify, FileModifyInline, FileDelete, FileCopy, FileRename, or
+// FileDeleteAll.
+type FileChange interface {
+	Cmd
+	fiFileChange()
+}
+
+// A NoteChange is a command that changes the notes of a commit:
+// NoteModify or NoteModifyInline.
+type NoteChange interface {
+	Cmd
+	fiNoteChange()
+}
+
+// A Commit is a whole commit: the CmdCommit, and the commands that
+// follow it, up to the CmdCommitEnd.
+type Commit struct {
+	Header CmdCommit
+	Files  []FileChange
+	Notes  []NoteChange
+}
+
+// ReadObject reads the next object from the Frontend.  A commit is
+// returned as a Commit, with all of its changes; anything else is
+// returned as the Cmd that it is.
+//
+// Commands that may appear in the middle of a commit (such as
+// "cat-blob" or "ls") are returned as soon as they are read, before
+// the Commit that they are in, so that they may be responded to.
+func (f *Frontend) ReadObject() (interface{}, error) {
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			if err == io.EOF && f.commit != nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch {
+		case cmdIs(cmd, cmdClassInCommand):
+			return cmd, nil
+		case f.commit != nil:
+			switch cmd := cmd.(type) {
+			case FileChange:
+				f.commit.Files = append(f.commit.Files, cmd)
+			case NoteChange:
+				f.commit.Notes = append(f.commit.Notes, cmd)
+			case CmdCommitEnd:
+				commit := *f.commit
+				f.commit = nil
+				return commit, nil
+			default:
+				// Such as "ls"; the commit continues after it.
+				return cmd, nil
+			}
+		default:
+			if header, isCommit := cmd.(CmdCommit); isCommit {
+				f.commit = &Commit{Header: header}
+				continue
+			}
+			return cmd, nil
+		}
+	}
+}
+
+// ReadCommit reads the next commit from the Frontend, skipping any
+// other commands.  It returns io.EOF if there are no more commits.
+//
+// Since commands that need a response are skipped, ReadObject should
+// be used instead for streams that may contain them.
+func (f *Frontend) ReadCommit() (Commit, error) {
+	for {
+		obj, err := f.ReadObject()
+		if err != nil {
+			return Commit{}, err
+		}
+		if commit, isCommit := obj.(Commit); isCommit {
+			return commit, nil
+		}
+	}
+}
+
+// WriteCommit writes the whole commit to the Backend.
+func (b *Backend) WriteCommit(commit Commit) error {
+	if err := b.Do(commit.Header); err != nil {
+		return err
+	}
+	for _, cmd := range commit.Files {
+		if err := b.Do(cmd); err != nil {
+			return err
+		}
+	}
+	for _, cmd := range commit.Notes {
+		if err := b.Do(cmd); err != nil {
+			return err
+		}
+	}
+	return b.Do(CmdCommitEnd{})
+}
@@ -0,0 +1,131 @@
+// Tests for reading and writing whole commits
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const commitInput = `blob
+mark :1
+data 5
+test
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+D b.txt
+
+commit refs/notes/commits
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 6
+notes
+N inline :2
+data 5
+note
+get-mark :1
+M 100644 :1 c.txt
+
+reset refs/heads/other
+from :2
+`
+
+func TestReadObject(t *testing.T) {
+	f := NewFrontend(strings.NewReader(commitInput), nil, nil)
+	var objs []interface{}
+	for {
+		obj, err := f.ReadObject()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		objs = append(objs, obj)
+	}
+	if assert.Equal(t, 5, len(objs)) {
+		assert.Equal(t, CmdBlob{Mark: 1, Data: "test\n"}, objs[0])
+		commit := objs[1].(Commit)
+		assert.Equal(t, "refs/heads/main", commit.Header.Ref)
+		assert.Equal(t, []FileChange{
+			FileModify{Mode: 0100644, DataRef: ":1", Path: "a.txt"},
+			FileDelete{Path: "b.txt"},
+		}, commit.Files)
+		assert.Nil(t, commit.Notes)
+		// Returned before the commit that it is in.
+		assert.Equal(t, CmdGetMark{Mark: 1}, objs[2])
+		commit = objs[3].(Commit)
+		assert.Equal(t, "refs/notes/commits", commit.Header.Ref)
+		assert.Equal(t, []FileChange{FileModify{Mode: 0100644, DataRef: ":1", Path: "c.txt"}}, commit.Files)
+		assert.Equal(t, []NoteChange{NoteModifyInline{CommitIsh: ":2", Data: "note\n"}}, commit.Notes)
+		assert.Equal(t, CmdReset{RefName: "refs/heads/other", CommitIsh: ":2"}, objs[4])
+	}
+
+	f = NewFrontend(strings.NewReader(commitInput), nil, nil)
+	var refs []string
+	for {
+		commit, err := f.ReadCommit()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		refs = append(refs, commit.Header.Ref)
+	}
+	assert.Equal(t, []string{"refs/heads/main", "refs/notes/commits"}, refs)
+
+	f = NewFrontend(strings.NewReader("commit refs/heads/main\ncommitter A <a@b> 1 +0000\ndata 0\nD a.txt\n"), nil, nil)
+	_, err := f.ReadObject()
+	assert.Nil(t, err)
+
+	// An "ls" doesn't end the commit that it is in.
+	f = NewFrontend(strings.NewReader("commit refs/heads/main\ncommitter A <a@b> 1 +0000\ndata 0\nls \"a.txt\"\nM 100644 :1 a.txt\n\nreset refs/heads/other\n"), nil, nil)
+	objs = nil
+	for {
+		obj, err := f.ReadObject()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		objs = append(objs, obj)
+	}
+	if assert.Equal(t, 3, len(objs)) {
+		assert.Equal(t, CmdLs{Path: "a.txt"}, objs[0])
+		commit := objs[1].(Commit)
+		assert.Equal(t, []FileChange{FileModify{Mode: 0100644, DataRef: ":1", Path: "a.txt"}}, commit.Files)
+		assert.Equal(t, CmdReset{RefName: "refs/heads/other"}, objs[2])
+	}
+}
+
+func TestWriteCommit(t *testing.T) {
+	f := NewFrontend(strings.NewReader(commitInput), nil, nil)
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	for {
+		obj, err := f.ReadObject()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		switch obj := obj.(type) {
+		case Commit:
+			assert.Nil(t, backend.WriteCommit(obj))
+		case CmdGetMark:
+			// Not part of the output.
+		case Cmd:
+			assert.Nil(t, backend.Do(obj))
+		}
+	}
+	bw.Flush()
+	expected := strings.Replace(commitInput, "get-mark :1\n", "", 1)
+	// Files are written before notes.
+	expected = strings.Replace(expected, "N inline :2\ndata 5\nnote\nM 100644 :1 c.txt\n", "M 100644 :1 c.txt\nN inline :2\ndata 5\nnote\n", 1)
+	assert.Equal(t, expected, outbuf.String())
+}
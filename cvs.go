@@ -0,0 +1,681 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CVSOptions are the options for ImportCVS.
+type CVSOptions struct {
+	// TrunkRef is "refs/heads/main" if empty.  Each branch is
+	// refs/heads/<name>, and a branch without a name (such as from
+	// a deleted symbol) is refs/heads/unlabeled-<number>.
+	TrunkRef string
+	// Window is the most time between revisions in the same
+	// changeset; 5 minutes if 0.
+	Window time.Duration
+	// Authors maps CVS user names to git identities (the time of
+	// which is ignored); see ReadAuthors.  A user that isn't in
+	// Authors is "<user> <<user>>", unless RequireAuthors is set,
+	// in which case it is an error.
+	Authors        map[string]Ident
+	RequireAuthors bool
+	// Tagger is the tagger of every tag; if it is the zero value,
+	// it is the author of the commit tagged.
+	Tagger Ident
+	// ExpandMode is the keyword expansion mode ("kv", "kkv", "k",
+	// "v" or "o") for every file, rather than the mode of each (as
+	// set with "cvs admin -k"); files in binary mode ("b") are
+	// never expanded.
+	ExpandMode string
+	// Root is the path of the repository in the $Header$ and
+	// $Source$ keywords; the directory imported if empty.
+	Root string
+	// FirstMark is the first mark to use; 1 if < 1.
+	FirstMark int
+}
+
+// CVSCommit is a commit written by ImportCVS.
+type CVSCommit struct {
+	Ref       string
+	Mark      int
+	Revisions []CVSRevision
+}
+
+// CVSRevision is a revision of a file.
+type CVSRevision struct {
+	Path Path
+	Rev  string
+}
+
+// CVSResult is the result of ImportCVS.
+type CVSResult struct {
+	Commits []CVSCommit // in order
+}
+
+// cvsFile is a file in the repository.
+type cvsFile struct {
+	path     Path
+	mode     Mode
+	revs     map[string]*cvsRev
+	tags     map[string]string // name => revision
+	branches map[string]string // name => the revision branched from
+}
+
+// cvsRev is a revision of a file.
+type cvsRev struct {
+	file     *cvsFile
+	rev      string
+	branch   string // "" for the trunk
+	pred     *cvsRev
+	time     time.Time
+	eff      time.Time // no earlier than that of pred
+	depth    int
+	author   string
+	log      string
+	commitID string
+	dead     bool
+	mark     int // of the blob
+	cs       *cvsChangeset
+}
+
+// cvsChangeset is a group of revisions that are committed together.
+type cvsChangeset struct {
+	index  int
+	branch string
+	revs   []*cvsRev
+	files  map[*cvsFile]bool
+	last   time.Time // of the revisions, by eff
+	time   time.Time // of the commit
+
+	// Once written, the commit (or the last commit before it on
+	// the branch, if it changes nothing) and its tree.
+	mark int
+	tree *tree
+}
+
+type cvsTip struct {
+	mark int
+	tree *tree
+}
+
+// cvsImporter holds the state of ImportCVS.
+type cvsImporter struct {
+	b        *Backend
+	opts     CVSOptions
+	result   *CVSResult
+	nextMark int
+	blobs    map[string]int // SHA-1 => mark
+	files    []*cvsFile
+	revs     []*cvsRev
+	sets     []*cvsChangeset
+	tips     map[string]cvsTip // branch => tip
+}
+
+// ImportCVS imports a CVS (or RCS) repository: a directory tree of
+// ",v" files, which are read directly.  Revisions of files (on the
+// same branch, by the same author, with the same log message, and
+// less than Window apart, or with the same CVS commit ID) are grouped
+// into changesets, each of which is written to the Backend as a
+// commit; files in "Attic" directories are in the directory above.
+// Each branch starts from the latest commit of those that its files
+// were branched from, and a tag is an annotated tag of the latest
+// commit of those of its files; if the files of the commit aren't
+// those of the branch (or tag), a commit to make them so is written
+// too.  Keywords are expanded as "cvs checkout" would.  A "CVSROOT"
+// directory at the top is not imported, and neither are vendor
+// branches as the default branch.
+//
+// Blobs are written for every revision of a file before any commit.
+func ImportCVS(b *Backend, dir string, opts CVSOptions) (*CVSResult, error) {
+	if opts.TrunkRef == "" {
+		opts.TrunkRef = "refs/heads/main"
+	}
+	if opts.Window == 0 {
+		opts.Window = 5 * time.Minute
+	}
+	if opts.Root == "" {
+		opts.Root = dir
+	}
+	if opts.FirstMark < 1 {
+		opts.FirstMark = 1
+	}
+	imp := &cvsImporter{
+		b:        b,
+		opts:     opts,
+		result:   &CVSResult{},
+		nextMark: opts.FirstMark,
+		blobs:    make(map[string]int),
+		tips:     make(map[string]cvsTip),
+	}
+	if err := imp.readDir(dir); err != nil {
+		return imp.result, err
+	}
+	imp.group()
+	for _, cs := range imp.sets {
+		if err := imp.commit(cs); err != nil {
+			return imp.result, err
+		}
+	}
+	if err := imp.symbols(); err != nil {
+		return imp.result, err
+	}
+	return imp.result, nil
+}
+
+func (imp *cvsImporter) mark() int {
+	mark := imp.nextMark
+	imp.nextMark++
+	return mark
+}
+
+// readDir reads every ",v" file in dir.
+func (imp *cvsImporter) readDir(dir string) error {
+	seen := make(map[Path]bool)
+	var attic []string
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if rel == "CVSROOT" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(rel, ",v") {
+			return nil
+		}
+		if path.Base(path.Dir(rel)) == "Attic" {
+			// Read after the others, so that a file that is
+			// also outside of the Attic is only read from there.
+			attic = append(attic, rel)
+			return nil
+		}
+		seen[Path(strings.TrimSuffix(rel, ",v"))] = true
+		return imp.readFile(dir, rel, info)
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range attic {
+		p := Path(strings.TrimSuffix(path.Join(path.Dir(path.Dir(rel)), path.Base(rel)), ",v"))
+		if seen[p] {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if err := imp.readFile(dir, rel, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile reads a ",v" file, writing a blob for each revision.
+func (imp *cvsImporter) readFile(dir, rel string, info os.FileInfo) error {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	rf, err := parseRCS(string(data))
+	if err != nil {
+		return errors.Wrap(err, rel)
+	}
+
+	p := strings.TrimSuffix(rel, ",v")
+	if path.Base(path.Dir(p)) == "Attic" {
+		p = path.Join(path.Dir(path.Dir(p)), path.Base(p))
+	}
+	f := &cvsFile{
+		path:     Path(p),
+		mode:     ModeFil,
+		revs:     make(map[string]*cvsRev),
+		tags:     make(map[string]string),
+		branches: make(map[string]string),
+	}
+	if info.Mode()&0111 != 0 {
+		f.mode = ModeExe
+	}
+	branchNames := make(map[string]string)
+	for _, sym := range rf.symbols {
+		if branch := rcsSymbolBranch(sym.rev); branch != "" {
+			f.branches[sym.name] = branch[:strings.LastIndexByte(branch, '.')]
+			branchNames[branch] = sym.name
+		} else {
+			f.tags[sym.name] = sym.rev
+		}
+	}
+
+	mode := rf.expand
+	if mode == "" {
+		mode = "kv"
+	}
+	if imp.opts.ExpandMode != "" && mode != "b" {
+		mode = imp.opts.ExpandMode
+	}
+	rcsfile := path.Base(rel)
+	err = rf.walk(func(d *rcsDelta, text string) error {
+		r := &cvsRev{
+			file:     f,
+			rev:      d.rev,
+			time:     d.date,
+			author:   d.author,
+			log:      d.log,
+			commitID: d.commitID,
+			dead:     d.state == "dead",
+		}
+		if branch := rcsBranch(d.rev); branch != "" {
+			r.branch = branchNames[branch]
+			if r.branch == "" {
+				r.branch = "unlabeled-" + branch
+			}
+		}
+		f.revs[d.rev] = r
+		imp.revs = append(imp.revs, r)
+		if r.dead {
+			return nil
+		}
+
+		info := d.rev + " " + d.date.Format("2006/01/02 15:04:05") + " " + d.author + " " + d.state
+		text = expandKeywords(text, mode, func(keyword string) string {
+			switch keyword {
+			case "Author":
+				return d.author
+			case "CVSHeader":
+				return rel + " " + info
+			case "Date":
+				return d.date.Format("2006/01/02 15:04:05")
+			case "Header":
+				return path.Join(filepath.ToSlash(imp.opts.Root), rel) + " " + info
+			case "Id":
+				return rcsfile + " " + info
+			case "Log", "RCSfile":
+				return rcsfile
+			case "Revision":
+				return d.rev
+			case "Source":
+				return path.Join(filepath.ToSlash(imp.opts.Root), rel)
+			case "State":
+				return d.state
+			}
+			// Locker and Name.
+			return ""
+		})
+		sha1 := BlobSHA1(text)
+		mark, ok := imp.blobs[sha1]
+		if !ok {
+			mark = imp.mark()
+			if err := imp.b.Do(CmdBlob{Mark: mark, Data: text}); err != nil {
+				return err
+			}
+			imp.blobs[sha1] = mark
+		}
+		r.mark = mark
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, rel)
+	}
+
+	// Link each revision to the one before it.
+	for _, d := range rf.deltas {
+		r := f.revs[d.rev]
+		if r == nil {
+			continue
+		}
+		if d.next != "" {
+			if rcsBranch(d.rev) == "" {
+				r.pred = f.revs[d.next]
+			} else if next := f.revs[d.next]; next != nil {
+				next.pred = r
+			}
+		}
+		for _, branch := range d.branches {
+			if b := f.revs[branch]; b != nil {
+				b.pred = r
+			}
+		}
+	}
+	imp.files = append(imp.files, f)
+	return nil
+}
+
+// group groups the revisions into changesets, ordered so that every
+// revision is after the one before it.
+func (imp *cvsImporter) group() {
+	// The effective time of a revision is no earlier than that of
+	// the one before it, despite clock skew.
+	for _, r := range imp.revs {
+		var chain []*cvsRev
+		for p := r; p != nil && p.eff.IsZero(); p = p.pred {
+			chain = append(chain, p)
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			p := chain[i]
+			p.eff = p.time
+			if p.pred != nil {
+				if p.pred.eff.After(p.eff) {
+					p.eff = p.pred.eff
+				}
+				p.depth = p.pred.depth + 1
+			}
+		}
+	}
+	sort.SliceStable(imp.revs, func(i, j int) bool {
+		a, b := imp.revs[i], imp.revs[j]
+		switch {
+		case !a.eff.Equal(b.eff):
+			return a.eff.Before(b.eff)
+		case a.depth != b.depth:
+			return a.depth < b.depth
+		case a.file.path != b.file.path:
+			return a.file.path < b.file.path
+		}
+		return a.rev < b.rev
+	})
+
+	// A revision joins the latest changeset with the same key,
+	// unless that would put it before the revision before it.
+	latest := make(map[string]*cvsChangeset)
+	for _, r := range imp.revs {
+		key := r.branch + "\x00" + r.author + "\x00" + r.log
+		if r.commitID != "" {
+			key = r.branch + "\x00\x00" + r.commitID
+		}
+		cs := latest[key]
+		if cs == nil || cs.files[r.file] ||
+			(r.pred != nil && r.pred.cs.index >= cs.index) ||
+			(r.commitID == "" && r.eff.Sub(cs.last) > imp.opts.Window) {
+			cs = &cvsChangeset{index: len(imp.sets), branch: r.branch, files: make(map[*cvsFile]bool)}
+			imp.sets = append(imp.sets, cs)
+			latest[key] = cs
+		}
+		cs.revs = append(cs.revs, r)
+		cs.files[r.file] = true
+		cs.last = r.eff
+		if r.time.After(cs.time) {
+			cs.time = r.time
+		}
+		r.cs = cs
+	}
+}
+
+func (imp *cvsImporter) ref(branch string) string {
+	if branch == "" {
+		return imp.opts.TrunkRef
+	}
+	return "refs/heads/" + branch
+}
+
+func cvsEntry(r *cvsRev) treeEntry {
+	return treeEntry{Mode: r.file.mode, ID: markRef(r.mark)}
+}
+
+// cvsChanges returns the changes from the files of a to those of b.
+func cvsChanges(a, b *tree) []FileChange {
+	var files []FileChange
+	diffTrees(a, b, func(path Path, ea, eb treeEntry) {
+		if eb.Mode == 0 {
+			files = append(files, FileDelete{Path: path})
+		} else {
+			files = append(files, FileModify{Mode: eb.Mode, DataRef: eb.ID, Path: path})
+		}
+	})
+	return files
+}
+
+// branchBase returns the latest changeset before index with a
+// revision that a file of a branch was branched from (or that is
+// before one of revs), and the files as of those revisions.
+func (imp *cvsImporter) branchBase(branch string, index int, revs []*cvsRev) (*cvsChangeset, *tree) {
+	var from *cvsChangeset
+	consider := func(r *cvsRev) bool {
+		if r == nil || r.cs == nil || r.cs.index >= index {
+			return false
+		}
+		if from == nil || r.cs.index > from.index {
+			from = r.cs
+		}
+		return true
+	}
+	var base *tree
+	named := false
+	for _, f := range imp.files {
+		rev, ok := f.branches[branch]
+		if !ok {
+			continue
+		}
+		named = true
+		if r := f.revs[rev]; consider(r) && !r.dead {
+			base = base.set(f.path, cvsEntry(r))
+		}
+	}
+	for _, r := range revs {
+		consider(r.pred)
+	}
+	if !named && from != nil {
+		base = from.tree
+	}
+	return from, base
+}
+
+// commit writes the commit of a changeset.
+func (imp *cvsImporter) commit(cs *cvsChangeset) error {
+	tip, ok := imp.tips[cs.branch]
+	parent, parentTree, cur := tip.mark, tip.tree, tip.tree
+	if !ok && cs.branch != "" {
+		var from *cvsChangeset
+		from, cur = imp.branchBase(cs.branch, cs.index, cs.revs)
+		if from != nil {
+			parent, parentTree = from.mark, from.tree
+		}
+	}
+	tree := cur
+	revisions := make([]CVSRevision, 0, len(cs.revs))
+	for _, r := range cs.revs {
+		if r.dead {
+			tree = tree.remove(r.file.path)
+		} else {
+			tree = tree.set(r.file.path, cvsEntry(r))
+		}
+		revisions = append(revisions, CVSRevision{Path: r.file.path, Rev: r.rev})
+	}
+	files := cvsChanges(parentTree, tree)
+	if len(files) == 0 {
+		cs.mark, cs.tree = parent, parentTree
+		if !ok && parent != 0 {
+			imp.tips[cs.branch] = cvsTip{mark: parent, tree: parentTree}
+			return imp.b.Do(CmdReset{RefName: imp.ref(cs.branch), CommitIsh: markRef(parent)})
+		}
+		return nil
+	}
+
+	ident, err := imp.ident(cs.revs[0].author, cs.time)
+	if err != nil {
+		return err
+	}
+	mark := imp.mark()
+	commit := Commit{
+		Header: CmdCommit{
+			Ref:       imp.ref(cs.branch),
+			Mark:      mark,
+			Author:    &ident,
+			Committer: ident,
+			Msg:       cs.revs[0].log,
+		},
+		Files: files,
+	}
+	if parent != 0 {
+		commit.Header.From = markRef(parent)
+	}
+	if err := imp.b.WriteCommit(commit); err != nil {
+		return err
+	}
+	imp.tips[cs.branch] = cvsTip{mark: mark, tree: tree}
+	cs.mark, cs.tree = mark, tree
+	imp.result.Commits = append(imp.result.Commits, CVSCommit{Ref: commit.Header.Ref, Mark: mark, Revisions: revisions})
+	return nil
+}
+
+// ident returns the identity of a CVS user.
+func (imp *cvsImporter) ident(user string, when time.Time) (Ident, error) {
+	ident, ok := imp.opts.Authors[user]
+	if !ok {
+		if imp.opts.RequireAuthors {
+			return Ident{}, errors.Errorf("unknown author %q", user)
+		}
+		ident = Ident{Name: user, Email: user}
+	}
+	ident.Time = when
+	return ident, nil
+}
+
+// tagger returns the tagger of a tag of the commit of a changeset.
+func (imp *cvsImporter) tagger(cs *cvsChangeset) (Ident, error) {
+	tagger := imp.opts.Tagger
+	if tagger.Name == "" && tagger.Email == "" {
+		return imp.ident(cs.revs[0].author, cs.time)
+	}
+	if tagger.Time.IsZero() {
+		tagger.Time = cs.time
+	}
+	return tagger, nil
+}
+
+// fixup returns the commit to start a branch or tag from: that of a
+// changeset, or if its files aren't those given, a commit to ref from
+// it that makes them so.
+func (imp *cvsImporter) fixup(ref string, from *cvsChangeset, files *tree, msg string) (int, error) {
+	changes := cvsChanges(from.tree, files)
+	if len(changes) == 0 {
+		return from.mark, nil
+	}
+	ident, err := imp.tagger(from)
+	if err != nil {
+		return 0, err
+	}
+	mark := imp.mark()
+	commit := Commit{
+		Header: CmdCommit{
+			Ref:       ref,
+			Mark:      mark,
+			Author:    &ident,
+			Committer: ident,
+			Msg:       msg,
+			From:      markRef(from.mark),
+		},
+		Files: changes,
+	}
+	if err := imp.b.WriteCommit(commit); err != nil {
+		return 0, err
+	}
+	imp.result.Commits = append(imp.result.Commits, CVSCommit{Ref: ref, Mark: mark})
+	return mark, nil
+}
+
+// symbols writes the branches that have no commits, and the tags.
+func (imp *cvsImporter) symbols() error {
+	branchSet := make(map[string]bool)
+	tagSet := make(map[string]bool)
+	for _, f := range imp.files {
+		for name := range f.branches {
+			branchSet[name] = true
+		}
+		for name := range f.tags {
+			tagSet[name] = true
+		}
+	}
+
+	for _, name := range sortedKeys(branchSet) {
+		if _, ok := imp.tips[name]; ok {
+			continue
+		}
+		from, base := imp.branchBase(name, len(imp.sets), nil)
+		if from == nil || from.mark == 0 {
+			continue
+		}
+		mark, err := imp.fixup(imp.ref(name), from, base, "Create branch "+name+"\n")
+		if err != nil {
+			return err
+		}
+		if mark == from.mark {
+			if err := imp.b.Do(CmdReset{RefName: imp.ref(name), CommitIsh: markRef(mark)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(tagSet) {
+		var from *cvsChangeset
+		var files *tree
+		for _, f := range imp.files {
+			rev, ok := f.tags[name]
+			if !ok {
+				continue
+			}
+			r := f.revs[rev]
+			if r == nil || r.cs == nil {
+				continue
+			}
+			if from == nil || r.cs.index > from.index {
+				from = r.cs
+			}
+			if !r.dead {
+				files = files.set(f.path, cvsEntry(r))
+			}
+		}
+		if from == nil || from.mark == 0 {
+			continue
+		}
+		mark, err := imp.fixup("refs/tags/"+name, from, files, "Create tag "+name+"\n")
+		if err != nil {
+			return err
+		}
+		tagger, err := imp.tagger(from)
+		if err != nil {
+			return err
+		}
+		if err := imp.b.Do(CmdTag{RefName: name, CommitIsh: markRef(mark), Tagger: tagger}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,312 @@
+// Tests for importing CVS repositories
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fooRCS = `head	1.3;
+access;
+symbols
+	old:1.1
+	rel-1:1.2
+	dev:1.2.0.2;
+locks; strict;
+comment	@ * @;
+
+
+1.3
+date	2022.02.09.09.40.00;	author alice;	state Exp;
+branches;
+next	1.2;
+commitid	abc123;
+
+1.2
+date	2022.02.09.09.35.00;	author alice;	state Exp;
+branches
+	1.2.2.1;
+next	1.1;
+
+1.1
+date	2022.02.09.09.31.13;	author alice;	state Exp;
+branches;
+next	;
+
+1.2.2.1
+date	2022.02.09.09.45.00;	author bob;	state Exp;
+branches;
+next	;
+
+
+desc
+@@
+
+
+1.3
+log
+@third
+@
+text
+@/* $Id$ */
+line 2 changed
+line 3 has an @@
+@
+
+
+1.2
+log
+@second
+@
+text
+@d2 1
+a2 1
+line 2
+@
+
+
+1.1
+log
+@first
+@
+text
+@d3 1
+@
+
+
+1.2.2.1
+log
+@on dev
+@
+text
+@a3 1
+dev line
+@
+`
+
+const barRCS = `head	1.2;
+access;
+symbols
+	rel-1:1.1
+	dev:1.1.0.2;
+locks; strict;
+expand	@k@;
+
+
+1.2
+date	2022.02.09.09.50.00;	author alice;	state dead;
+branches;
+next	1.1;
+commitid	abc123;
+
+1.1
+date	2022.02.09.09.31.20;	author alice;	state Exp;
+branches;
+next	;
+
+
+desc
+@@
+
+
+1.2
+log
+@third
+@
+text
+@$Revision: 1.1 $
+@
+
+
+1.1
+log
+@first
+@
+text
+@@
+`
+
+const runRCS = `head	1.1;
+access;
+symbols
+	rel-1:1.1
+	dev:1.1.0.2;
+locks; strict;
+expand	@b@;
+
+
+1.1
+date	2022.02.09.09.31.00;	author alice;	state Exp;
+branches;
+next	;
+
+
+desc
+@@
+
+
+1.1
+log
+@first
+@
+text
+@$Id$
+@
+`
+
+func TestApplyRCSDiff(t *testing.T) {
+	lines := splitLines("a\nb\nc")
+	assert.Equal(t, []string{"a\n", "b\n", "c"}, lines)
+	got, err := applyRCSDiff(lines, "d1 1\na2 2\nx\ny\nd3 1\na3 1\nz")
+	assert.Nil(t, err)
+	assert.Equal(t, "b\nx\ny\nz", strings.Join(got, ""))
+
+	for _, diff := range []string{"x1 1\n", "d3 2\n", "a4 1\nx\n", "a1 2\nx\n", "d2 1\nd1 1\n",
+		"a1 -1\n", "d1 -5\n", "d0 1\n", "a-1 1\nx\n"} {
+		_, err := applyRCSDiff(lines, diff)
+		assert.NotNil(t, err, "%q", diff)
+	}
+}
+
+func TestExpandKeywords(t *testing.T) {
+	value := func(keyword string) string { return strings.ToLower(keyword) }
+	text := "$Id$ $Revision: 1.1 $ $Name$ $Unknown$ $Author:\n$"
+	assert.Equal(t, "$Id: id $ $Revision: revision $ $Name: name $ $Unknown$ $Author:\n$", expandKeywords(text, "kv", value))
+	assert.Equal(t, "$Id$ $Revision$ $Name$ $Unknown$ $Author:\n$", expandKeywords(text, "k", value))
+	assert.Equal(t, "id revision name $Unknown$ $Author:\n$", expandKeywords(text, "v", value))
+	assert.Equal(t, text, expandKeywords(text, "o", value))
+	assert.Equal(t, text, expandKeywords(text, "b", value))
+}
+
+func TestImportCVS(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "Attic"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "CVSROOT"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "foo.c,v"), []byte(fooRCS), 0444))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "Attic", "bar.txt,v"), []byte(barRCS), 0444))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bin", "run.sh,v"), []byte(runRCS), 0555))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "CVSROOT", "loginfo,v"), []byte("bogus"), 0444))
+
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	result, err := ImportCVS(backend, dir, CVSOptions{
+		Authors: map[string]Ident{"alice": {Name: "Alice Liddell", Email: "alice@example.com"}},
+		Root:    "/cvsroot/mod",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []CVSCommit{
+		{Ref: "refs/heads/main", Mark: 7, Revisions: []CVSRevision{{"bin/run.sh", "1.1"}, {"foo.c", "1.1"}, {"bar.txt", "1.1"}}},
+		{Ref: "refs/heads/main", Mark: 8, Revisions: []CVSRevision{{"foo.c", "1.2"}}},
+		{Ref: "refs/heads/main", Mark: 9, Revisions: []CVSRevision{{"foo.c", "1.3"}, {"bar.txt", "1.2"}}},
+		{Ref: "refs/heads/dev", Mark: 10, Revisions: []CVSRevision{{"foo.c", "1.2.2.1"}}},
+		{Ref: "refs/tags/old", Mark: 11},
+	}, result.Commits)
+	bw.Flush()
+	assert.Equal(t, `blob
+mark :1
+data 5
+$Id$
+blob
+mark :2
+data 86
+/* $Id: foo.c,v 1.3 2022/02/09 09:40:00 alice Exp $ */
+line 2 changed
+line 3 has an @
+blob
+mark :3
+data 78
+/* $Id: foo.c,v 1.2 2022/02/09 09:35:00 alice Exp $ */
+line 2
+line 3 has an @
+blob
+mark :4
+data 89
+/* $Id: foo.c,v 1.2.2.1 2022/02/09 09:45:00 bob Exp $ */
+line 2
+line 3 has an @
+dev line
+blob
+mark :5
+data 62
+/* $Id: foo.c,v 1.1 2022/02/09 09:31:13 alice Exp $ */
+line 2
+blob
+mark :6
+data 11
+$Revision$
+commit refs/heads/main
+mark :7
+author Alice Liddell <alice@example.com> 1644399080 +0000
+committer Alice Liddell <alice@example.com> 1644399080 +0000
+data 6
+first
+M 100644 :6 bar.txt
+M 100755 :1 bin/run.sh
+M 100644 :5 foo.c
+
+commit refs/heads/main
+mark :8
+author Alice Liddell <alice@example.com> 1644399300 +0000
+committer Alice Liddell <alice@example.com> 1644399300 +0000
+data 7
+second
+from :7
+M 100644 :3 foo.c
+
+commit refs/heads/main
+mark :9
+author Alice Liddell <alice@example.com> 1644400200 +0000
+committer Alice Liddell <alice@example.com> 1644400200 +0000
+data 6
+third
+from :8
+D bar.txt
+M 100644 :2 foo.c
+
+commit refs/heads/dev
+mark :10
+author bob <bob> 1644399900 +0000
+committer bob <bob> 1644399900 +0000
+data 7
+on dev
+from :8
+M 100644 :4 foo.c
+
+commit refs/tags/old
+mark :11
+author Alice Liddell <alice@example.com> 1644399080 +0000
+committer Alice Liddell <alice@example.com> 1644399080 +0000
+data 15
+Create tag old
+from :7
+D bar.txt
+D bin/run.sh
+
+tag old
+from :11
+tagger Alice Liddell <alice@example.com> 1644399080 +0000
+data 0
+
+tag rel-1
+from :8
+tagger Alice Liddell <alice@example.com> 1644399300 +0000
+data 0
+
+`, outbuf.String())
+
+	// Errors.
+	_, err = ImportCVS(backend, dir, CVSOptions{RequireAuthors: true})
+	assert.NotNil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bad,v"), []byte("head 1.1;\n1.1\ndate 2022;\ndesc\n@@\n"), 0444))
+	_, err = ImportCVS(backend, dir, CVSOptions{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,345 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DiffMatch is a way of deciding which commit in one stream
+// corresponds to which commit in another.
+type DiffMatch int
+
+const (
+	// MatchAuto matches commits by original-oid where both have
+	// one, and the rest by fingerprint.
+	MatchAuto DiffMatch = iota
+	// MatchOriginalOID matches commits with the same original-oid.
+	MatchOriginalOID
+	// MatchPosition matches the nth commit of one stream with
+	// the nth commit of the other.
+	MatchPosition
+	// MatchFingerprint matches commits with the same author,
+	// committer time, and message.  Commits with the same
+	// fingerprint are matched in stream order.
+	MatchFingerprint
+)
+
+// DiffOptions configures DiffStreams.
+type DiffOptions struct {
+	Match DiffMatch
+}
+
+// A DiffCommit identifies a commit in a stream.
+type DiffCommit struct {
+	Index       int    `json:"index"` // position among the commits of the stream, from 0
+	Ref         string `json:"ref"`
+	Mark        int    `json:"mark,omitempty"`
+	OriginalOID string `json:"original_oid,omitempty"`
+}
+
+func (c DiffCommit) String() string {
+	var ret string
+	switch {
+	case c.OriginalOID != "":
+		ret = c.OriginalOID
+	case c.Mark > 0:
+		ret = markRef(c.Mark)
+	default:
+		ret = fmt.Sprintf("#%d", c.Index)
+	}
+	return ret + " (" + c.Ref + ")"
+}
+
+// A FieldChange is a difference in a piece of commit metadata.
+type FieldChange struct {
+	Field string `json:"field"` // "ref", "author", "committer", "encoding", "message", or "parents"
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// A TreeChange is a difference in a file between the trees of two
+// commits.  Mode is 0 for a file that is not present; ID is the SHA-1
+// of the file's content where it is known, and its dataref otherwise.
+type TreeChange struct {
+	Path    Path   `json:"path"`
+	OldMode Mode   `json:"old_mode,omitempty"`
+	OldID   string `json:"old_id,omitempty"`
+	NewMode Mode   `json:"new_mode,omitempty"`
+	NewID   string `json:"new_id,omitempty"`
+}
+
+// A CommitDiff describes how a commit differs between two streams.
+type CommitDiff struct {
+	Old      DiffCommit    `json:"old"`
+	New      DiffCommit    `json:"new"`
+	Metadata []FieldChange `json:"metadata,omitempty"`
+	Tree     []TreeChange  `json:"tree,omitempty"`
+}
+
+// A StreamDiff describes how two streams differ.
+type StreamDiff struct {
+	Removed []DiffCommit `json:"removed"` // commits only in the old stream
+	Added   []DiffCommit `json:"added"`   // commits only in the new stream
+	Changed []CommitDiff `json:"changed"` // matched commits that differ
+}
+
+// Empty returns whether the two streams are equivalent.
+func (d *StreamDiff) Empty() bool {
+	return len(d.Removed) == 0 && len(d.Added) == 0 && len(d.Changed) == 0
+}
+
+// WriteJSON writes the diff to w as indented JSON.
+func (d *StreamDiff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(d)
+}
+
+// diffCommit is everything about a commit that DiffStreams compares.
+type diffCommit struct {
+	id        DiffCommit
+	author    string
+	committer string
+	encoding  string
+	msg       string
+	parents   []string // commit-ishes, with commits from the stream as "#<index>"
+	tree      *tree
+}
+
+func (c *diffCommit) fingerprint() string {
+	return c.author + "\x00" + c.committer[strings.LastIndexByte(c.committer, '>')+1:] + "\x00" + c.msg
+}
+
+// readDiffStream reads every commit from a Frontend.
+func readDiffStream(f *Frontend) ([]*diffCommit, error) {
+	var commits []*diffCommit
+	replay := newTreeReplay()
+	names := make(map[string]string) // commit mark, original-oid, or branch => "#<index>"
+	name := func(commitish string) string {
+		commitish = strings.TrimSuffix(commitish, "^0")
+		if n, ok := names[commitish]; ok {
+			return n
+		}
+		return commitish
+	}
+	var cur *diffCommit
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			if err == io.EOF {
+				return commits, nil
+			}
+			return nil, err
+		}
+		replay.Do(cmd)
+		switch c := cmd.(type) {
+		case CmdCommit:
+			cur = &diffCommit{
+				id: DiffCommit{
+					Index:       len(commits),
+					Ref:         c.Ref,
+					Mark:        c.Mark,
+					OriginalOID: c.OriginalOID,
+				},
+				committer: c.Committer.String(),
+				encoding:  c.Encoding,
+				msg:       c.Msg,
+			}
+			if c.Author != nil {
+				cur.author = c.Author.String()
+			}
+			if c.From != "" {
+				cur.parents = append(cur.parents, name(c.From))
+			} else if tip, ok := names[c.Ref]; ok {
+				cur.parents = append(cur.parents, tip)
+			}
+			for _, merge := range c.Merge {
+				cur.parents = append(cur.parents, name(merge))
+			}
+		case CmdCommitEnd:
+			cur.tree = replay.cur
+			self := fmt.Sprintf("#%d", cur.id.Index)
+			if cur.id.Mark > 0 {
+				names[markRef(cur.id.Mark)] = self
+			}
+			if cur.id.OriginalOID != "" {
+				names[cur.id.OriginalOID] = self
+			}
+			names[cur.id.Ref] = self
+			commits = append(commits, cur)
+			cur = nil
+		case CmdReset:
+			if c.CommitIsh == "" {
+				delete(names, c.RefName)
+			} else {
+				names[c.RefName] = name(c.CommitIsh)
+			}
+		case CmdAlias:
+			if n, ok := names[c.CommitIsh]; ok {
+				names[markRef(c.Mark)] = n
+			}
+		}
+	}
+}
+
+// matchCommits returns, for each old commit, the index of the matching
+// new commit, or -1.
+func matchCommits(olds, news []*diffCommit, how DiffMatch) []int {
+	ret := make([]int, len(olds))
+	for i := range ret {
+		ret[i] = -1
+	}
+	taken := make([]bool, len(news))
+
+	if how == MatchPosition {
+		for i := range olds {
+			if i < len(news) {
+				ret[i] = i
+			}
+		}
+		return ret
+	}
+
+	if how == MatchAuto || how == MatchOriginalOID {
+		byOID := make(map[string]int)
+		for j, c := range news {
+			if c.id.OriginalOID != "" {
+				byOID[c.id.OriginalOID] = j
+			}
+		}
+		for i, c := range olds {
+			if j, ok := byOID[c.id.OriginalOID]; ok && c.id.OriginalOID != "" && !taken[j] {
+				ret[i] = j
+				taken[j] = true
+			}
+		}
+	}
+
+	if how == MatchAuto || how == MatchFingerprint {
+		byPrint := make(map[string][]int)
+		for j, c := range news {
+			if !taken[j] {
+				fp := c.fingerprint()
+				byPrint[fp] = append(byPrint[fp], j)
+			}
+		}
+		for i, c := range olds {
+			if ret[i] >= 0 {
+				continue
+			}
+			fp := c.fingerprint()
+			if js := byPrint[fp]; len(js) > 0 {
+				ret[i] = js[0]
+				taken[js[0]] = true
+				byPrint[fp] = js[1:]
+			}
+		}
+	}
+	return ret
+}
+
+// DiffStreams reads every command from two Frontends, and describes
+// how the commits in the new stream differ from those in the old
+// one.
+//
+// The trees of the commits are compared by replaying the file
+// commands of each stream, so two streams that build the same trees
+// in different ways are equivalent.  Blobs, tags, and notes are not
+// compared except as they affect the trees of commits.
+func DiffStreams(oldStream, newStream *Frontend, opts DiffOptions) (*StreamDiff, error) {
+	olds, err := readDiffStream(oldStream)
+	if err != nil {
+		return nil, err
+	}
+	news, err := readDiffStream(newStream)
+	if err != nil {
+		return nil, err
+	}
+	match := matchCommits(olds, news, opts.Match)
+
+	ret := &StreamDiff{
+		Removed: []DiffCommit{},
+		Added:   []DiffCommit{},
+		Changed: []CommitDiff{},
+	}
+	matched := make([]bool, len(news))
+	for i, j := range match {
+		if j >= 0 {
+			matched[j] = true
+		}
+		if j < 0 {
+			ret.Removed = append(ret.Removed, olds[i].id)
+		}
+	}
+	for j, c := range news {
+		if !matched[j] {
+			ret.Added = append(ret.Added, c.id)
+		}
+	}
+
+	// An old parent is translated to the new stream's name for it
+	// before comparing.
+	translate := func(parent string) string {
+		var i int
+		if _, err := fmt.Sscanf(parent, "#%d", &i); err == nil && i < len(match) {
+			if match[i] < 0 {
+				return parent + " (removed)"
+			}
+			return fmt.Sprintf("#%d", match[i])
+		}
+		return parent
+	}
+
+	for i, j := range match {
+		if j < 0 {
+			continue
+		}
+		a, b := olds[i], news[j]
+		diff := CommitDiff{Old: a.id, New: b.id}
+		field := func(name, old, new string) {
+			if old != new {
+				diff.Metadata = append(diff.Metadata, FieldChange{Field: name, Old: old, New: new})
+			}
+		}
+		field("ref", a.id.Ref, b.id.Ref)
+		field("author", a.author, b.author)
+		field("committer", a.committer, b.committer)
+		field("encoding", a.encoding, b.encoding)
+		field("message", a.msg, b.msg)
+		var aParents []string
+		for _, p := range a.parents {
+			aParents = append(aParents, translate(p))
+		}
+		field("parents", strings.Join(aParents, " "), strings.Join(b.parents, " "))
+		diffTrees(a.tree, b.tree, func(path Path, ea, eb treeEntry) {
+			diff.Tree = append(diff.Tree, TreeChange{
+				Path:    path,
+				OldMode: ea.Mode,
+				OldID:   ea.ID,
+				NewMode: eb.Mode,
+				NewID:   eb.ID,
+			})
+		})
+		if len(diff.Metadata) > 0 || len(diff.Tree) > 0 {
+			ret.Changed = append(ret.Changed, diff)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,74 @@
+// Tests for stream diff
+
+package libfastimport
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree(t *testing.T) {
+	var a *tree
+	a = a.set("d/e/f.txt", treeEntry{Mode: ModeFil, ID: "1"})
+	a = a.set("g.txt", treeEntry{Mode: ModeFil, ID: "2"})
+	b := a.remove("d/e/f.txt")
+	assert.Equal(t, []string{"g.txt"}, b.names())
+	assert.True(t, b.remove("nonexistent") == b)
+	e, ok := a.get("d/e")
+	assert.True(t, ok)
+	c := a.set("x/y", e)
+	var changes []string
+	diffTrees(a, c, func(path Path, ea, eb treeEntry) {
+		changes = append(changes, fmt.Sprintf("%s %q %q", path, ea.ID, eb.ID))
+	})
+	assert.Equal(t, []string{`x/y/f.txt "" "1"`}, changes)
+}
+
+func TestDiffStreams(t *testing.T) {
+	commit := func(oid, msg, extra string) string {
+		return fmt.Sprintf("commit refs/heads/main\noriginal-oid %s\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata %d\n%s%s\n",
+			oid, len(msg), msg, extra)
+	}
+	old := "blob\nmark :1\ndata 2\nx\nblob\nmark :2\ndata 2\ny\n" +
+		commit("A", "one\n", "M 100644 :1 a.txt\nM 100644 :2 b.txt\n") +
+		commit("B", "two\n", "M 100644 inline a.txt\ndata 2\nz\n") +
+		commit("C", "three\n", "D a.txt\n")
+	newer := "blob\nmark :5\ndata 2\ny\n" +
+		commit("A", "one\n", "M 100644 inline a.txt\ndata 2\nx\nM 100644 :5 b.txt\n") +
+		commit("B", "two!\n", "M 100644 inline a.txt\ndata 2\nz\nR b.txt c.txt\n") +
+		commit("D", "four\n", "")
+
+	diff, err := DiffStreams(NewFrontend(strings.NewReader(old), nil, nil), NewFrontend(strings.NewReader(newer), nil, nil), DiffOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []DiffCommit{{Index: 2, Ref: "refs/heads/main", OriginalOID: "C"}}, diff.Removed)
+	assert.Equal(t, []DiffCommit{{Index: 2, Ref: "refs/heads/main", OriginalOID: "D"}}, diff.Added)
+	assert.Equal(t, []CommitDiff{{
+		Old:      DiffCommit{Index: 1, Ref: "refs/heads/main", OriginalOID: "B"},
+		New:      DiffCommit{Index: 1, Ref: "refs/heads/main", OriginalOID: "B"},
+		Metadata: []FieldChange{{Field: "message", Old: "two\n", New: "two!\n"}},
+		Tree: []TreeChange{
+			{Path: "b.txt", OldMode: ModeFil, OldID: BlobSHA1("y\n")},
+			{Path: "c.txt", NewMode: ModeFil, NewID: BlobSHA1("y\n")},
+		},
+	}}, diff.Changed)
+
+	// Without original-oids, "two" and "two!" can't be matched by
+	// fingerprint, but can be by position.
+	strip := func(s string) string {
+		for _, oid := range []string{"A", "B", "C", "D"} {
+			s = strings.Replace(s, "original-oid "+oid+"\n", "", 1)
+		}
+		return s
+	}
+	diff, err = DiffStreams(NewFrontend(strings.NewReader(strip(old)), nil, nil), NewFrontend(strings.NewReader(strip(newer)), nil, nil), DiffOptions{Match: MatchFingerprint})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(diff.Removed))
+	assert.Equal(t, 0, len(diff.Changed))
+	diff, err = DiffStreams(NewFrontend(strings.NewReader(strip(old)), nil, nil), NewFrontend(strings.NewReader(strip(newer)), nil, nil), DiffOptions{Match: MatchPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(diff.Removed))
+	assert.Equal(t, 2, len(diff.Changed))
+}
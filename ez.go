@@ -16,6 +16,7 @@
 package libfastimport
 
 import (
+	"io"
 	"strconv"
 
 	"github.com/pkg/errors"
@@ -72,14 +73,25 @@ func (e *ezfir) Errcheck(err error) {
 	panic(ezPanic)
 }
 
+// PeekLine returns the next line, or "" at the end of the stream; the
+// end of the stream is only an error if something then tries to
+// ReadLine.
 func (e *ezfir) PeekLine() string {
 	line, err := e.fir.PeekLine()
+	if err == io.EOF {
+		return ""
+	}
 	e.Errcheck(err)
 	return line
 }
 
+// ReadLine returns the next line.  Since the caller is in the middle
+// of parsing a command, the end of the stream is unexpected.
 func (e *ezfir) ReadLine() string {
 	line, err := e.fir.ReadLine()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
 	e.Errcheck(err)
 	return line
 }
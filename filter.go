@@ -0,0 +1,68 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A CmdWriter is something that commands may be written to.  A
+// *Backend is a CmdWriter, and so are the filters in this package,
+// which transform the commands written to them before passing them on
+// to another CmdWriter; filters may thus be chained in front of a
+// Backend.
+type CmdWriter interface {
+	Do(Cmd) error
+}
+
+// BlobSHA1 returns the git object name of a blob with the given
+// content, in the 40-character hex form that may be used as a
+// dataref.
+func BlobSHA1(data string) string {
+	sum := blobSHA1Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func blobSHA1Sum(data string) [20]byte {
+	var sum [20]byte
+	h := sha1.New()
+	io.WriteString(h, "blob "+strconv.Itoa(len(data))+"\x00")
+	io.WriteString(h, data)
+	h.Sum(sum[:0])
+	return sum
+}
+
+// parseMarkRef returns the idnum of a mark reference (":<idnum>"), or
+// 0 if ref is not a mark reference.
+func parseMarkRef(ref string) int {
+	if !strings.HasPrefix(ref, ":") {
+		return 0
+	}
+	mark, err := strconv.Atoi(ref[1:])
+	if err != nil || mark < 1 {
+		return 0
+	}
+	return mark
+}
+
+// markRef returns the mark reference (":<idnum>") for a mark.
+func markRef(mark int) string {
+	return ":" + strconv.Itoa(mark)
+}
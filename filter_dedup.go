@@ -0,0 +1,243 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// DedupOptions configures a DedupFilter.
+type DedupOptions struct {
+	// TempDir is the directory that the filter's indexes (of
+	// content hashes, and of marks) spill to once they each have
+	// MaxMemEntries entries in memory.  If empty, os.TempDir() is
+	// used.  If MaxMemEntries is < 1, a default of 1048576 is
+	// used.
+	TempDir       string
+	MaxMemEntries int
+}
+
+// dedupMark is what the filter knows about a blob's mark.
+type dedupMark struct {
+	dropped bool     // the blob was dropped
+	mark    int      // if dropped, the mark of the original (0 if it has none)
+	sum     [20]byte // the content
+}
+
+const dedupMarkLen = 1 + 8 + 20
+
+func dedupMarkKey(mark int) [20]byte {
+	var key [20]byte
+	binary.BigEndian.PutUint64(key[12:], uint64(mark))
+	return key
+}
+
+// A DedupFilter is a CmdWriter that drops every CmdBlob whose content
+// is identical to that of an earlier CmdBlob, before passing commands
+// on to another CmdWriter.
+//
+// References to the mark of a dropped blob are rewritten to refer to
+// the original blob: by its mark, if that mark still refers to it, or
+// else by its SHA-1.  A blob with a mark is not dropped if its
+// original has no mark, so that a get-mark of it still works.
+type DedupFilter struct {
+	next  CmdWriter
+	index *hashIndex // content => mark of the latest blob kept with it
+	marks *hashIndex // mark => dedupMark, for blobs
+
+	dropped      int
+	droppedBytes int64
+}
+
+// NewDedupFilter creates a new DedupFilter that writes to next.
+//
+// The caller should call Close when done with the filter, to remove
+// any temporary files.
+func NewDedupFilter(next CmdWriter, opts DedupOptions) *DedupFilter {
+	return &DedupFilter{
+		next:  next,
+		index: newHashIndex(opts.TempDir, opts.MaxMemEntries, 8),
+		marks: newHashIndex(opts.TempDir, opts.MaxMemEntries, dedupMarkLen),
+	}
+}
+
+// Dropped returns the number of blobs that have been dropped, and
+// their total size.
+func (f *DedupFilter) Dropped() (count int, size int64) {
+	return f.dropped, f.droppedBytes
+}
+
+func (f *DedupFilter) getMark(mark int) (dedupMark, bool, error) {
+	val, ok, err := f.marks.Get(dedupMarkKey(mark))
+	if err != nil || !ok || val[0] == 0 {
+		return dedupMark{}, false, err
+	}
+	m := dedupMark{
+		dropped: val[0] == 2,
+		mark:    int(binary.BigEndian.Uint64(val[1:9])),
+	}
+	copy(m.sum[:], val[9:])
+	return m, true, nil
+}
+
+// putMark records what a mark refers to; a nil m forgets it.
+func (f *DedupFilter) putMark(mark int, m *dedupMark) error {
+	var val [dedupMarkLen]byte
+	if m != nil {
+		val[0] = 1
+		if m.dropped {
+			val[0] = 2
+		}
+		binary.BigEndian.PutUint64(val[1:9], uint64(m.mark))
+		copy(val[9:], m.sum[:])
+	}
+	return f.marks.Put(dedupMarkKey(mark), val[:])
+}
+
+// holds returns whether mark refers to a blob with the content sum
+// that was kept.
+func (f *DedupFilter) holds(mark int, sum [20]byte) (bool, error) {
+	if mark < 1 {
+		return false, nil
+	}
+	m, ok, err := f.getMark(mark)
+	return ok && !m.dropped && m.sum == sum, err
+}
+
+// redefine forgets what a mark referred to, as it is being defined
+// again.
+func (f *DedupFilter) redefine(mark int) error {
+	if mark < 1 {
+		return nil
+	}
+	_, ok, err := f.getMark(mark)
+	if err != nil || !ok {
+		return err
+	}
+	return f.putMark(mark, nil)
+}
+
+// Do passes the command on to the next CmdWriter, unless it is a
+// duplicate blob.
+func (f *DedupFilter) Do(cmd Cmd) error {
+	var err error
+	switch c := cmd.(type) {
+	case CmdBlob:
+		if err := f.redefine(c.Mark); err != nil {
+			return err
+		}
+		sum := blobSHA1Sum(c.Data)
+		val, seen, err := f.index.Get(sum)
+		if err != nil {
+			return err
+		}
+		holder := 0
+		if seen {
+			orig := int(binary.BigEndian.Uint64(val))
+			if ok, err := f.holds(orig, sum); err != nil {
+				return err
+			} else if ok {
+				holder = orig
+			}
+		}
+		if seen && (c.Mark < 1 || holder > 0) {
+			f.dropped++
+			f.droppedBytes += int64(len(c.Data))
+			if c.Mark > 0 {
+				return f.putMark(c.Mark, &dedupMark{dropped: true, mark: holder, sum: sum})
+			}
+			return nil
+		}
+		if c.Mark > 0 {
+			if err := f.putMark(c.Mark, &dedupMark{sum: sum}); err != nil {
+				return err
+			}
+		}
+		// If it was seen, then the mark that the index had for
+		// it has been redefined; this one replaces it.
+		var mark [8]byte
+		binary.BigEndian.PutUint64(mark[:], uint64(c.Mark))
+		if err := f.index.Put(sum, mark[:]); err != nil {
+			return err
+		}
+		return f.next.Do(c)
+	case CmdCommit:
+		err = f.redefine(c.Mark)
+	case CmdTag:
+		if c.CommitIsh, err = f.rewrite(c.CommitIsh); err == nil {
+			err = f.redefine(c.Mark)
+		}
+		cmd = c
+	case CmdAlias:
+		if c.CommitIsh, err = f.rewrite(c.CommitIsh); err == nil {
+			err = f.redefine(c.Mark)
+		}
+		cmd = c
+	case FileModify:
+		c.DataRef, err = f.rewrite(c.DataRef)
+		cmd = c
+	case NoteModify:
+		c.DataRef, err = f.rewrite(c.DataRef)
+		cmd = c
+	case CmdCatBlob:
+		c.DataRef, err = f.rewrite(c.DataRef)
+		cmd = c
+	case CmdGetMark:
+		var m dedupMark
+		var ok bool
+		if m, ok, err = f.getMark(c.Mark); err == nil && ok && m.dropped {
+			if ok, err = f.holds(m.mark, m.sum); err == nil && !ok {
+				return errors.Errorf("dedup: get-mark :%d: the blob was dropped, and the mark of its original has been redefined", c.Mark)
+			}
+			c.Mark = m.mark
+			cmd = c
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return f.next.Do(cmd)
+}
+
+func (f *DedupFilter) rewrite(ref string) (string, error) {
+	mark := parseMarkRef(ref)
+	if mark < 1 {
+		return ref, nil
+	}
+	m, ok, err := f.getMark(mark)
+	if err != nil || !ok || !m.dropped {
+		return ref, err
+	}
+	if ok, err := f.holds(m.mark, m.sum); err != nil {
+		return "", err
+	} else if ok {
+		return markRef(m.mark), nil
+	}
+	return hex.EncodeToString(m.sum[:]), nil
+}
+
+// Close releases the resources used by the filter.  It does not close
+// the next CmdWriter.
+func (f *DedupFilter) Close() error {
+	err := f.index.Close()
+	if merr := f.marks.Close(); merr != nil && err == nil {
+		err = merr
+	}
+	return err
+}
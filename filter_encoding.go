@@ -0,0 +1,300 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+// LookupEncoding returns the character encoding with the given name,
+// as it might appear in a commit's "encoding" header ("ISO-8859-1",
+// "Shift_JIS", "latin1", "cp1252", ...).
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	if enc, err := ianaindex.IANA.Encoding(name); err == nil && enc != nil {
+		return enc, nil
+	}
+	if enc, err := htmlindex.Get(name); err == nil {
+		return enc, nil
+	}
+	return nil, errors.Errorf("unsupported encoding: %q", name)
+}
+
+// TranscodeOptions configures a TranscodeFilter.
+type TranscodeOptions struct {
+	// Fallback is the list of encodings to try for text that is
+	// not valid UTF-8 and that has no declared encoding.  Of the
+	// encodings that can decode the whole text, the one whose
+	// result looks the most like real text is used: the fewest
+	// control characters (such as the C1 controls that
+	// ISO-8859-1 makes of Windows-1252 quotes), the fewest
+	// non-ASCII punctuation marks and symbols (such as the
+	// quotes and dashes that Windows-1252 makes of Shift_JIS),
+	// and the fewest words that switch between scripts (such as
+	// the kanji that Shift_JIS makes of Latin-1 accented
+	// letters).  Ties go to
+	// the encoding that is earliest in the list.  If Fallback is
+	// empty, such text is passed through unchanged.
+	//
+	// This is still a guess, so the list should only name the
+	// encodings that the repository is known to use.
+	Fallback []string
+}
+
+// A TranscodeProblem describes text that a TranscodeFilter could not
+// convert to UTF-8, and passed through unchanged, or that it
+// converted with a Fallback encoding, which is only a guess.
+type TranscodeProblem struct {
+	Ref   string   // the commit's branch or the tag's name
+	Mark  int      // the commit's or tag's mark, if it has one
+	Field string   // "message", "author", "committer", "tagger", or "path"
+	Text  string   // the original text
+	Bytes []string // the byte sequences that could not be decoded
+	Guess string   // the Fallback encoding that the text was converted with, if it was
+}
+
+// A TranscodeFilter is a CmdWriter that converts commit messages, tag
+// messages, the names and emails of Idents, and paths to UTF-8.
+//
+// A commit message is decoded according to the commit's "encoding"
+// header (which is then dropped) if it has one; everything else is
+// decoded according to TranscodeOptions.Fallback if it isn't already
+// valid UTF-8.
+type TranscodeFilter struct {
+	next         CmdWriter
+	fallback     []encoding.Encoding
+	fallbackName []string
+	problems     []TranscodeProblem
+
+	ref  string
+	mark int
+}
+
+// NewTranscodeFilter creates a new TranscodeFilter that writes to
+// next.
+func NewTranscodeFilter(next CmdWriter, opts TranscodeOptions) (*TranscodeFilter, error) {
+	f := &TranscodeFilter{next: next}
+	for _, name := range opts.Fallback {
+		enc, err := LookupEncoding(name)
+		if err != nil {
+			return nil, err
+		}
+		f.fallback = append(f.fallback, enc)
+		f.fallbackName = append(f.fallbackName, name)
+	}
+	return f, nil
+}
+
+// Problems returns a list of all of the text that could not be
+// converted so far, and of all of the text that was converted with a
+// Fallback encoding.
+func (f *TranscodeFilter) Problems() []TranscodeProblem {
+	return f.problems
+}
+
+// Do converts the text in the command, and passes it on to the next
+// CmdWriter.
+func (f *TranscodeFilter) Do(cmd Cmd) error {
+	switch c := cmd.(type) {
+	case CmdCommit:
+		f.ref, f.mark = c.Ref, c.Mark
+		if c.Author != nil {
+			author := f.ident("author", *c.Author)
+			c.Author = &author
+		}
+		c.Committer = f.ident("committer", c.Committer)
+		if c.Encoding != "" {
+			enc, err := LookupEncoding(c.Encoding)
+			if err != nil {
+				f.problem("message", c.Msg, nil, "")
+			} else if msg, bad := decodeWith(enc, c.Msg); len(bad) > 0 {
+				f.problem("message", c.Msg, bad, "")
+			} else {
+				c.Msg = msg
+				c.Encoding = ""
+			}
+		} else {
+			c.Msg = f.text("message", c.Msg)
+		}
+		cmd = c
+	case CmdTag:
+		f.ref, f.mark = c.RefName, c.Mark
+		c.Tagger = f.ident("tagger", c.Tagger)
+		c.Data = f.text("message", c.Data)
+		cmd = c
+	case FileModify:
+		c.Path = f.path(c.Path)
+		cmd = c
+	case FileModifyInline:
+		c.Path = f.path(c.Path)
+		cmd = c
+	case FileDelete:
+		c.Path = f.path(c.Path)
+		cmd = c
+	case FileCopy:
+		c.Src, c.Dst = f.path(c.Src), f.path(c.Dst)
+		cmd = c
+	case FileRename:
+		c.Src, c.Dst = f.path(c.Src), f.path(c.Dst)
+		cmd = c
+	case CmdLs:
+		c.Path = f.path(c.Path)
+		cmd = c
+	}
+	return f.next.Do(cmd)
+}
+
+func (f *TranscodeFilter) problem(field, text string, bad []string, guess string) {
+	f.problems = append(f.problems, TranscodeProblem{
+		Ref:   f.ref,
+		Mark:  f.mark,
+		Field: field,
+		Text:  text,
+		Bytes: bad,
+		Guess: guess,
+	})
+}
+
+func (f *TranscodeFilter) ident(field string, ident Ident) Ident {
+	ident.Name = f.text(field, ident.Name)
+	ident.Email = f.text(field, ident.Email)
+	return ident
+}
+
+func (f *TranscodeFilter) path(path Path) Path {
+	return Path(f.text("path", string(path)))
+}
+
+// text converts str to UTF-8 using the most plausible fallback
+// encoding that can decode all of it, and records the guess.
+func (f *TranscodeFilter) text(field, str string) string {
+	if utf8.ValidString(str) {
+		return str
+	}
+	var firstBad []string
+	best, bestScore, bestText := -1, 0, ""
+	for i, enc := range f.fallback {
+		ret, bad := decodeWith(enc, str)
+		if len(bad) > 0 {
+			if i == 0 {
+				firstBad = bad
+			}
+			continue
+		}
+		if score := implausibility(ret); best < 0 || score < bestScore {
+			best, bestScore, bestText = i, score, ret
+		}
+	}
+	if best < 0 {
+		f.problem(field, str, firstBad, "")
+		return str
+	}
+	f.problem(field, str, nil, f.fallbackName[best])
+	return bestText
+}
+
+// scripts are the groups of scripts that words are written in; a
+// letter that is in none of them is in a group of its own.  Japanese
+// mixes kanji and kana within words, so they are one group.
+var scripts = [][]*unicode.RangeTable{
+	{unicode.Latin},
+	{unicode.Greek},
+	{unicode.Cyrillic},
+	{unicode.Armenian},
+	{unicode.Hebrew},
+	{unicode.Arabic},
+	{unicode.Thai},
+	{unicode.Han, unicode.Hiragana, unicode.Katakana},
+	{unicode.Hangul},
+}
+
+func scriptOf(r rune) int {
+	for i, tables := range scripts {
+		if unicode.IsOneOf(tables, r) {
+			return i
+		}
+	}
+	return len(scripts)
+}
+
+// implausibility scores how unlikely it is that text is what was
+// meant, rather than the result of decoding it with the wrong
+// encoding: each control character other than a tab or newline
+// scores 10, and each non-ASCII punctuation mark or symbol, and each
+// switch from one script to another within a word, scores 1.
+func implausibility(text string) int {
+	score := 0
+	prev := -1 // the script of the previous rune, if it is a letter
+	for _, r := range text {
+		switch {
+		case unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r':
+			score += 10
+			prev = -1
+		case unicode.IsLetter(r):
+			script := scriptOf(r)
+			if prev >= 0 && script != prev {
+				score++
+			}
+			prev = script
+		case unicode.IsMark(r):
+			// A combining mark belongs to the letter before
+			// it.
+		default:
+			if r >= utf8.RuneSelf && (unicode.IsPunct(r) || unicode.IsSymbol(r)) {
+				score++
+			}
+			prev = -1
+		}
+	}
+	return score
+}
+
+// decodeWith decodes str from enc to UTF-8, returning the byte
+// sequences that enc could not decode.
+func decodeWith(enc encoding.Encoding, str string) (string, []string) {
+	dec := enc.NewDecoder()
+	var out strings.Builder
+	var bad []string
+	var dst [utf8.UTFMax]byte
+	src := []byte(str)
+	for len(src) > 0 {
+		nDst, nSrc, err := dec.Transform(dst[:], src, true)
+		if err != nil && err != transform.ErrShortDst {
+			bad = append(bad, string(src))
+			break
+		}
+		chunk := dst[:nDst]
+		// A decoded replacement character is only a problem if
+		// it wasn't literally in the input.
+		if strings.ContainsRune(string(chunk), utf8.RuneError) && !strings.Contains(string(src[:nSrc]), "\uFFFD") {
+			bad = append(bad, string(src[:nSrc]))
+		}
+		out.Write(chunk)
+		src = src[nSrc:]
+		if nSrc == 0 && nDst == 0 {
+			bad = append(bad, string(src))
+			break
+		}
+	}
+	return out.String(), bad
+}
@@ -0,0 +1,112 @@
+// Tests for transcoding filter
+
+package libfastimport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscode(t *testing.T) {
+	input := "commit refs/heads/main\nmark :1\n" +
+		"author Ren\xe9 <rene@example.com> 1644399073 +0000\n" +
+		"committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000\n" +
+		"encoding ISO-8859-1\n" +
+		"data 6\ncaf\xe9!\n" +
+		"M 100644 inline \x93\xfa\x96\x7b\x8c\xea.txt\ndata 2\nx\n\n" +
+		"commit refs/heads/main\nmark :2\n" +
+		"committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000\n" +
+		"encoding Shift_JIS\n" +
+		"data 4\nab\x82\n\n" +
+		"done\n"
+
+	var f *TranscodeFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		var err error
+		f, err = NewTranscodeFilter(next, TranscodeOptions{Fallback: []string{"Shift_JIS", "latin1"}})
+		assert.Nil(t, err)
+		return f
+	})
+	assert.Equal(t, "commit refs/heads/main\nmark :1\n"+
+		"author René <rene@example.com> 1644399073 +0000\n"+
+		"committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000\n"+
+		"data 7\ncafé!\n"+
+		"M 100644 inline 日本語.txt\ndata 2\nx\n\n"+
+		"commit refs/heads/main\nmark :2\n"+
+		"committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000\n"+
+		"encoding Shift_JIS\n"+
+		"data 4\nab\x82\n\n"+
+		"done\n", output)
+
+	assert.Equal(t, []TranscodeProblem{{
+		Ref:   "refs/heads/main",
+		Mark:  1,
+		Field: "author",
+		Text:  "Ren\xe9",
+		Guess: "latin1",
+	}, {
+		Ref:   "refs/heads/main",
+		Mark:  1,
+		Field: "path",
+		Text:  "\x93\xfa\x96\x7b\x8c\xea.txt",
+		Guess: "Shift_JIS",
+	}, {
+		Ref:   "refs/heads/main",
+		Mark:  2,
+		Field: "message",
+		Text:  "ab\x82\n",
+		Bytes: []string{"\x82\n"},
+	}}, f.Problems())
+}
+
+func TestTranscodeFallback(t *testing.T) {
+	input := "commit refs/heads/main\nmark :1\n" +
+		"committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000\n" +
+		"data 14\nr\xe9pare le bug\n\n"
+	transcode := func(fallback ...string) (string, []TranscodeProblem) {
+		var f *TranscodeFilter
+		output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+			var err error
+			f, err = NewTranscodeFilter(next, TranscodeOptions{Fallback: fallback})
+			assert.Nil(t, err)
+			return f
+		})
+		return output, f.Problems()
+	}
+
+	// With no fallback, the text is left alone.
+	output, problems := transcode()
+	assert.Equal(t, input, output)
+	assert.Equal(t, []TranscodeProblem{{
+		Ref:   "refs/heads/main",
+		Mark:  1,
+		Field: "message",
+		Text:  "r\xe9pare le bug\n",
+	}}, problems)
+
+	output, problems = transcode("ISO-8859-1")
+	assert.Contains(t, output, "data 15\nrépare le bug\n")
+	assert.Equal(t, "ISO-8859-1", problems[0].Guess)
+
+	// Latin-1 text can also be valid Shift_JIS, but as Shift_JIS
+	// the word "répare" would switch from Latin to kanji and back.
+	output, problems = transcode("Shift_JIS", "ISO-8859-1")
+	assert.Contains(t, output, "data 15\nrépare le bug\n")
+	assert.Equal(t, "ISO-8859-1", problems[0].Guess)
+}
+
+func TestImplausibility(t *testing.T) {
+	assert.Equal(t, 0, implausibility("répare le bug\n"))
+	assert.Equal(t, 0, implausibility("日本語のテキスト.txt"))
+	assert.Equal(t, 2, implausibility("r\u9aeeare"))
+	// Windows-1252 quotes, decoded as ISO-8859-1.
+	assert.Equal(t, 20, implausibility("\u0093quoted\u0094"))
+	assert.Equal(t, 2, implausibility("“quoted”"))
+
+	f, err := NewTranscodeFilter(nil, TranscodeOptions{Fallback: []string{"ISO-8859-1", "windows-1252", "Shift_JIS"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "“quoted”", f.text("message", "\x93quoted\x94"))
+	assert.Equal(t, "日本語.txt", f.text("path", "\x93\xfa\x96\x7b\x8c\xea.txt"))
+	assert.Equal(t, []string{"windows-1252", "Shift_JIS"}, []string{f.Problems()[0].Guess, f.Problems()[1].Guess})
+}
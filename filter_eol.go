@@ -0,0 +1,225 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TextMode is the state of the "text" attribute for a path.
+type TextMode int
+
+const (
+	TextUnspecified TextMode = iota // no rule says anything
+	TextSet                         // "text", "eol=...", or legacy "crlf"
+	TextUnset                       // "-text", "binary", or legacy "-crlf"
+	TextAuto                        // "text=auto"
+)
+
+type textRule struct {
+	pattern *PathPattern
+	text    TextMode
+}
+
+// TextRules is a set of gitattributes(5)-style rules about which
+// paths contain text.
+//
+// Of the attributes, only "text", "eol", "binary", and the legacy
+// "crlf" are looked at; anything else is ignored.  As in git, when
+// several lines match a path, the last one wins.
+type TextRules struct {
+	rules []textRule
+}
+
+// ParseTextRules parses rules in the format of a .gitattributes file.
+func ParseTextRules(r io.Reader) (*TextRules, error) {
+	ret := &TextRules{}
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pattern, err := CompilePathPattern(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineno)
+		}
+		rule := textRule{pattern: pattern}
+		set := false // whether the line says anything about "text"; "!text" says TextUnspecified
+		eol := false
+		for _, attr := range fields[1:] {
+			switch attr {
+			case "text", "crlf":
+				rule.text, set = TextSet, true
+			case "-text", "binary", "-crlf":
+				rule.text, set = TextUnset, true
+			case "!text", "!crlf":
+				rule.text, set = TextUnspecified, true
+			case "text=auto":
+				rule.text, set = TextAuto, true
+			case "eol=lf", "eol=crlf", "crlf=input":
+				eol = true
+			}
+		}
+		if eol && rule.text == TextUnspecified {
+			// Setting "eol" implies "text".
+			rule.text, set = TextSet, true
+		}
+		if set {
+			ret.rules = append(ret.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Text returns the state of the "text" attribute for the path.
+func (r *TextRules) Text(path Path) TextMode {
+	if r == nil {
+		return TextUnspecified
+	}
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		if r.rules[i].pattern.Match(path) {
+			return r.rules[i].text
+		}
+	}
+	return TextUnspecified
+}
+
+// IsBinary guesses whether data is binary rather than text, using the
+// same heuristic that git uses for "text=auto": content is binary if
+// it contains a NUL byte or a CR that isn't part of a CRLF, or if
+// fewer than 1 in 128 bytes is non-printable.
+func IsBinary(data string) bool {
+	var lonecr, nul, printable, nonprintable int
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\r':
+			if i+1 < len(data) && data[i+1] == '\n' {
+				i++
+			} else {
+				lonecr++
+			}
+		case c == '\n':
+		case c == 127:
+			nonprintable++
+		case c < 32:
+			switch c {
+			case '\b', '\t', '\033', '\014':
+				printable++
+			case 0:
+				nul++
+				nonprintable++
+			default:
+				nonprintable++
+			}
+		default:
+			printable++
+		}
+	}
+	// A trailing DOS end-of-file marker isn't held against it.
+	if len(data) > 0 && data[len(data)-1] == '\032' {
+		nonprintable--
+	}
+	return lonecr > 0 || nul > 0 || (printable>>7) < nonprintable
+}
+
+// EOLOptions configures an EOLFilter.
+type EOLOptions struct {
+	Rules *TextRules
+
+	// If DefaultAuto is set, then paths that no rule has
+	// anything to say about are treated as "text=auto", as with
+	// "core.autocrlf=input".
+	DefaultAuto bool
+}
+
+// An EOLFilter is a CmdWriter that normalizes the line endings of
+// text files from CRLF to LF, as git would when adding them to the
+// index.
+//
+// Whether a file is text is decided by the path it is stored at,
+// according to a set of TextRules; for "text=auto" paths, by IsBinary.
+// A blob that is used both at paths that are text and at paths that
+// aren't keeps its original content at the latter, stored inline.
+type EOLFilter struct {
+	*pathBlobFilter
+
+	rules       *TextRules
+	defaultAuto bool
+	normalized  int
+}
+
+// NewEOLFilter creates a new EOLFilter that writes to next.
+//
+// The filter holds on to commands, so Flush must be called once the
+// last command has been written to it.
+func NewEOLFilter(next CmdWriter, opts EOLOptions) *EOLFilter {
+	f := &EOLFilter{
+		pathBlobFilter: newPathBlobFilter(next),
+		rules:          opts.Rules,
+		defaultAuto:    opts.DefaultAuto,
+	}
+	f.pathBlobFilter.convert = f.convert
+	f.pathBlobFilter.key = func(path Path) string {
+		return strconv.Itoa(int(f.text(path)))
+	}
+	f.pathBlobFilter.retain = func(data string) bool {
+		return strings.Contains(data, "\r\n")
+	}
+	return f
+}
+
+// Normalized returns the number of blobs (and inline files) whose
+// content has been changed.
+func (f *EOLFilter) Normalized() int {
+	return f.normalized
+}
+
+// text returns the state of the "text" attribute for the path, taking
+// DefaultAuto into account.
+func (f *EOLFilter) text(path Path) TextMode {
+	mode := f.rules.Text(path)
+	if mode == TextUnspecified && f.defaultAuto {
+		mode = TextAuto
+	}
+	return mode
+}
+
+func (f *EOLFilter) convert(path Path, _ Mode, data string) (string, error) {
+	if path == "" || !strings.Contains(data, "\r\n") {
+		return data, nil
+	}
+	switch f.text(path) {
+	case TextSet:
+	case TextAuto:
+		if IsBinary(data) {
+			return data, nil
+		}
+	default:
+		return data, nil
+	}
+	f.normalized++
+	return strings.Replace(data, "\r\n", "\n", -1), nil
+}
@@ -0,0 +1,105 @@
+// Tests for line-ending filter
+
+package libfastimport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, IsBinary("hello\r\nworld\r\n"))
+	assert.False(t, IsBinary("tab\tand\033escape\n"))
+	assert.True(t, IsBinary("nul\x00byte\n"))
+	assert.True(t, IsBinary("lone\rcr\n"))
+	assert.True(t, IsBinary("\x01\x02\x03"))
+	assert.False(t, IsBinary("dos eof\n\x1a"))
+}
+
+func TestTextRules(t *testing.T) {
+	rules, err := ParseTextRules(strings.NewReader(`# comment
+* text=auto
+*.bat eol=crlf
+*.txt text
+*.bin binary
+vendor/** -text
+vendor/keep.txt !text
+vendor/keep.sh !text eol=lf
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, TextAuto, rules.Text("a.c"))
+	assert.Equal(t, TextSet, rules.Text("x/run.bat"))
+	assert.Equal(t, TextSet, rules.Text("a.txt"))
+	assert.Equal(t, TextUnset, rules.Text("a.bin"))
+	assert.Equal(t, TextUnset, rules.Text("vendor/a.txt"))
+	// "!text" overrides the earlier rules.
+	assert.Equal(t, TextUnspecified, rules.Text("vendor/keep.txt"))
+	assert.Equal(t, TextSet, rules.Text("vendor/keep.sh"))
+}
+
+func TestEOL(t *testing.T) {
+	input := "blob\nmark :1\ndata 6\na\r\nb\r\n" +
+		"blob\nmark :2\ndata 6\na\r\nb\x00\n" +
+		"blob\nmark :3\ndata 4\nc\r\n\n" +
+		`commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+M 100644 :2 b.txt
+M 100644 :3 c.bin
+M 100644 inline d.txt
+` + "data 6\nd\r\ne\r\n\n"
+
+	rules, err := ParseTextRules(strings.NewReader("* text=auto\n*.bin -text\n"))
+	assert.Nil(t, err)
+	var eol *EOLFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		eol = NewEOLFilter(next, EOLOptions{Rules: rules})
+		return eol
+	})
+	assert.Equal(t, "blob\nmark :1\ndata 4\na\nb\n"+
+		"blob\nmark :2\ndata 6\na\r\nb\x00\n"+
+		"blob\nmark :3\ndata 4\nc\r\n\n"+
+		`commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+M 100644 :2 b.txt
+M 100644 :3 c.bin
+M 100644 inline d.txt
+`+"data 4\nd\ne\n\n", output)
+	assert.Equal(t, 2, eol.Normalized())
+}
+
+func TestEOLSharedBlob(t *testing.T) {
+	input := "blob\nmark :1\ndata 6\na\r\nb\r\n" + `commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+M 100644 :1 vendor/a.txt
+
+`
+	rules, err := ParseTextRules(strings.NewReader("* text\nvendor/** -text\n"))
+	assert.Nil(t, err)
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		return NewEOLFilter(next, EOLOptions{Rules: rules})
+	})
+	// The blob is normalized for a.txt, but vendor/a.txt keeps
+	// the original content.
+	assert.Equal(t, "blob\nmark :1\ndata 4\na\nb\n"+`commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+M 100644 inline vendor/a.txt
+`+"data 6\na\r\nb\r\n\n", output)
+}
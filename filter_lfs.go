@@ -0,0 +1,396 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LFSOptions configures an LFSFilter.
+type LFSOptions struct {
+	// Blobs of at least Threshold bytes are converted to LFS.  If
+	// Threshold is < 1, blobs are not converted based on size.
+	Threshold int64
+
+	// Blobs stored at a path matching any of Patterns (see
+	// PathPattern) are converted to LFS regardless of size.
+	Patterns []string
+
+	// ObjectsDir is the LFS object store that original content
+	// is written to; typically ".git/lfs/objects" in the
+	// repository being imported in to.
+	ObjectsDir string
+}
+
+// lfsAttrs are the attributes that "git lfs track" uses.
+const lfsAttrs = "filter=lfs diff=lfs merge=lfs -text"
+
+// An LFSFilter is a CmdWriter that replaces the content of large
+// files (or files at matching paths) with Git LFS pointer files,
+// writing the original content to a local LFS object store, and that
+// keeps the necessary entries in the top-level .gitattributes of each
+// affected commit.  Symlinks and gitlinks are never converted.
+//
+// A .gitattributes file that was inherited from a commit that is not
+// part of the stream is not seen by the filter, and will be
+// overwritten if the filter needs to add entries.
+type LFSFilter struct {
+	*pathBlobFilter
+
+	threshold  int64
+	patterns   []*PathPattern
+	objectsDir string
+
+	converted map[Path]bool     // inline files converted in the current commit
+	made      map[string]string // pointers that the filter made => LFS oid
+	pointers  map[string]string // emitted pointer blobs, by mark reference or SHA-1 => LFS oid
+
+	attrBlobs  map[int]string       // content of .gitattributes blobs, by mark
+	markStates map[string]*lfsState // by commit mark
+	tipStates  map[string]*lfsState // by ref
+}
+
+// lfsState is the state of the .gitattributes of a commit.
+type lfsState struct {
+	user  string        // the content that the stream gave it
+	paths map[Path]bool // converted files that need their own entry; not modified once shared
+	final string        // the content with the filter's entries
+}
+
+// NewLFSFilter creates a new LFSFilter that writes to next.
+//
+// The filter holds on to commands, so Flush must be called once the
+// last command has been written to it.
+func NewLFSFilter(next CmdWriter, opts LFSOptions) (*LFSFilter, error) {
+	patterns, err := compilePathPatterns(opts.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ObjectsDir == "" {
+		return nil, errors.New("lfs: ObjectsDir must be set")
+	}
+	f := &LFSFilter{
+		threshold:  opts.Threshold,
+		patterns:   patterns,
+		objectsDir: opts.ObjectsDir,
+		converted:  make(map[Path]bool),
+		made:       make(map[string]string),
+		pointers:   make(map[string]string),
+		attrBlobs:  make(map[int]string),
+		markStates: make(map[string]*lfsState),
+		tipStates:  make(map[string]*lfsState),
+	}
+	f.pathBlobFilter = newPathBlobFilter(lfsEmitWatcher{f: f, next: next})
+	f.pathBlobFilter.convert = f.convert
+	f.pathBlobFilter.endCommit = f.endCommit
+	return f, nil
+}
+
+// lfsEmitWatcher notes which blobs that an LFSFilter emits are
+// pointers, so that uses of them may be found, however they are
+// referred to.
+type lfsEmitWatcher struct {
+	f    *LFSFilter
+	next CmdWriter
+}
+
+func (w lfsEmitWatcher) Do(cmd Cmd) error {
+	if blob, ok := cmd.(CmdBlob); ok {
+		oid, isPointer := w.f.made[blob.Data]
+		switch {
+		case blob.Mark > 0 && isPointer:
+			w.f.pointers[markRef(blob.Mark)] = oid
+		case blob.Mark > 0:
+			delete(w.f.pointers, markRef(blob.Mark))
+		case isPointer:
+			w.f.pointers[BlobSHA1(blob.Data)] = oid
+		}
+	}
+	return w.next.Do(cmd)
+}
+
+// Do passes the command on to the next CmdWriter, converting blobs as
+// necessary.
+func (f *LFSFilter) Do(cmd Cmd) error {
+	if c, ok := cmd.(CmdReset); ok {
+		if c.CommitIsh == "" {
+			delete(f.tipStates, c.RefName)
+		} else {
+			f.tipStates[c.RefName] = f.stateOf(c.CommitIsh)
+		}
+	}
+	return f.pathBlobFilter.Do(cmd)
+}
+
+func (f *LFSFilter) stateOf(commitish string) *lfsState {
+	if state, ok := f.markStates[commitish]; ok {
+		return state
+	}
+	return f.tipStates[commitish]
+}
+
+// LFSPointer returns the content of a Git LFS pointer file for the
+// given content.
+func LFSPointer(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n",
+		hex.EncodeToString(sum[:]), len(data))
+}
+
+func (f *LFSFilter) convert(path Path, mode Mode, data string) (string, error) {
+	if path == ".gitattributes" {
+		return data, nil
+	}
+	if mode != 0 && mode != ModeFil && mode != ModeExe {
+		return data, nil
+	}
+	byPattern := path != "" && matchAny(f.patterns, path)
+	if !byPattern && !(f.threshold > 0 && int64(len(data)) >= f.threshold) {
+		return data, nil
+	}
+	oid, err := f.store(data)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		f.converted[path] = true
+	}
+	pointer := LFSPointer(data)
+	f.made[pointer] = oid
+	return pointer, nil
+}
+
+func (f *LFSFilter) objectPath(oid string) string {
+	return filepath.Join(f.objectsDir, oid[0:2], oid[2:4], oid)
+}
+
+// store writes data to the LFS object store, if it isn't already
+// there, and returns its LFS oid.
+func (f *LFSFilter) store(data string) (string, error) {
+	sum := sha256.Sum256([]byte(data))
+	oid := hex.EncodeToString(sum[:])
+	dst := f.objectPath(oid)
+	dir := filepath.Dir(dst)
+	if _, err := os.Stat(dst); err == nil {
+		return oid, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "lfs")
+	}
+	tmp, err := os.CreateTemp(dir, oid+".tmp*")
+	if err != nil {
+		return "", errors.Wrap(err, "lfs")
+	}
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", errors.Wrap(err, "lfs")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", errors.Wrap(err, "lfs")
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return "", errors.Wrap(err, "lfs")
+	}
+	return oid, nil
+}
+
+func (f *LFSFilter) endCommit(commit *CmdCommit, files []Cmd, emitted map[int]string) ([]Cmd, error) {
+	defer func() { f.converted = make(map[Path]bool) }()
+
+	var parent *lfsState
+	if commit.From != "" {
+		parent = f.stateOf(commit.From)
+	} else {
+		parent = f.tipStates[commit.Ref]
+	}
+	if parent == nil {
+		parent = &lfsState{}
+	}
+	state := &lfsState{user: parent.user, paths: parent.paths}
+	committed := parent.final // what the commit leaves .gitattributes as, without the filter
+	setUser := func(attrs string) {
+		state.user, committed = attrs, attrs
+	}
+	// The parent's paths are copied before they are first
+	// modified.
+	own := false
+	paths := func() map[Path]bool {
+		if !own {
+			m := make(map[Path]bool, len(state.paths))
+			for path := range state.paths {
+				m[path] = true
+			}
+			state.paths, own = m, true
+		}
+		return state.paths
+	}
+	under := func(p, dir Path) bool {
+		return p == dir || strings.HasPrefix(string(p), string(dir)+"/")
+	}
+	remove := func(path Path) {
+		for p := range state.paths {
+			if under(p, path) {
+				delete(paths(), p)
+			}
+		}
+	}
+	copyPaths := func(src, dst Path, rename bool) {
+		var copied []Path
+		for p := range state.paths {
+			if under(p, src) {
+				copied = append(copied, p)
+			}
+		}
+		if rename {
+			remove(src)
+		}
+		remove(dst)
+		for _, p := range copied {
+			paths()[dst+p[len(src):]] = true
+		}
+	}
+
+	for i, cmd := range files {
+		switch c := cmd.(type) {
+		case FileModify:
+			if c.Path == ".gitattributes" {
+				mark := parseMarkRef(c.DataRef)
+				if data, ok := emitted[mark]; ok {
+					f.attrBlobs[mark] = data
+				}
+				setUser(f.attrBlobs[mark])
+				continue
+			}
+			remove(c.Path)
+			oid, isPointer := f.pointers[c.DataRef]
+			switch {
+			case !isPointer || c.Mode == ModeGit:
+			case c.Mode != ModeFil && c.Mode != ModeExe:
+				// A converted blob that is also used
+				// for a symlink.
+				data, err := os.ReadFile(f.objectPath(oid))
+				if err != nil {
+					return nil, errors.Wrap(err, "lfs")
+				}
+				files[i] = FileModifyInline{Mode: c.Mode, Path: c.Path, Data: string(data)}
+			default:
+				paths()[c.Path] = true
+			}
+		case FileModifyInline:
+			if c.Path == ".gitattributes" {
+				setUser(c.Data)
+				continue
+			}
+			remove(c.Path)
+			if f.converted[c.Path] {
+				paths()[c.Path] = true
+			}
+		case FileDelete:
+			if c.Path == ".gitattributes" {
+				setUser("")
+			}
+			remove(c.Path)
+		case FileDeleteAll:
+			setUser("")
+			state.paths, own = make(map[Path]bool), true
+		case FileCopy:
+			copyPaths(c.Src, c.Dst, false)
+		case FileRename:
+			if c.Src == ".gitattributes" {
+				setUser("")
+			}
+			copyPaths(c.Src, c.Dst, true)
+		}
+	}
+
+	state.final = state.user
+	for _, pat := range f.patterns {
+		state.final = lfsAttrsAdd(state.final, pat.String())
+	}
+	var sorted []Path
+	for path := range state.paths {
+		if !matchAny(f.patterns, path) {
+			sorted = append(sorted, path)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, path := range sorted {
+		state.final = lfsAttrsAdd(state.final, lfsPathPattern(path))
+	}
+
+	if commit.Mark > 0 {
+		f.markStates[markRef(commit.Mark)] = state
+	}
+	f.tipStates[commit.Ref] = state
+
+	switch {
+	case state.final == committed:
+		return nil, nil
+	case state.final == "":
+		return []Cmd{FileDelete{Path: ".gitattributes"}}, nil
+	default:
+		return []Cmd{FileModifyInline{Mode: ModeFil, Path: ".gitattributes", Data: state.final}}, nil
+	}
+}
+
+// lfsPathPattern returns a .gitattributes pattern that matches exactly
+// the given path.
+func lfsPathPattern(path Path) string {
+	var pat strings.Builder
+	pat.WriteString("/")
+	for _, c := range string(path) {
+		switch c {
+		case ' ':
+			// This is how "git lfs track" escapes spaces.
+			pat.WriteString("[[:space:]]")
+			continue
+		case '*', '?', '[', '\\', '#', '!':
+			pat.WriteByte('\\')
+		}
+		pat.WriteRune(c)
+	}
+	return pat.String()
+}
+
+func lfsAttrsHas(attrs string, pattern string) bool {
+	for _, line := range strings.Split(attrs, "\n") {
+		if strings.TrimSpace(line) == pattern+" "+lfsAttrs {
+			return true
+		}
+	}
+	return false
+}
+
+func lfsAttrsAdd(attrs string, pattern string) string {
+	if lfsAttrsHas(attrs, pattern) {
+		return attrs
+	}
+	if attrs != "" && !strings.HasSuffix(attrs, "\n") {
+		attrs += "\n"
+	}
+	return attrs + pattern + " " + lfsAttrs + "\n"
+}
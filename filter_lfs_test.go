@@ -0,0 +1,217 @@
+// Tests for LFS filter
+
+package libfastimport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		path    Path
+		match   bool
+	}{
+		{"*.psd", "art/x.psd", true},
+		{"*.psd", "x.psd", true},
+		{"*.psd", "x.psd.txt", false},
+		{"/x.psd", "art/x.psd", false},
+		{"art/*.psd", "art/x.psd", true},
+		{"art/*.psd", "art/sub/x.psd", false},
+		{"art/**", "art/sub/x.psd", true},
+		{"**/sub/*.psd", "art/sub/x.psd", true},
+		{"**/sub/*.psd", "sub/x.psd", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"file?.[ch]", "dir/file1.c", true},
+		{"file?.[!ch]", "dir/file1.c", false},
+	} {
+		pat, err := CompilePathPattern(tc.pattern)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.match, pat.Match(tc.path), "%q ~ %q", tc.pattern, tc.path)
+	}
+}
+
+func TestLFS(t *testing.T) {
+	input := `blob
+mark :1
+data 5
+test
+blob
+mark :2
+data 11
+0123456789
+blob
+mark :3
+data 4
+psd
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 small.txt
+M 100644 :2 big file.bin
+M 100644 :3 art/x.psd
+
+commit refs/heads/main
+mark :5
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+rename
+from :4
+R "big file.bin" big2.bin
+
+commit refs/heads/main
+mark :6
+committer Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 5
+noop
+from :5
+M 100644 :1 small2.txt
+
+`
+	objects := t.TempDir()
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f, err := NewLFSFilter(next, LFSOptions{Threshold: 10, Patterns: []string{"*.psd"}, ObjectsDir: objects})
+		assert.Nil(t, err)
+		return f
+	})
+	assert.Equal(t, `blob
+mark :1
+data 5
+test
+blob
+mark :2
+data 127
+`+LFSPointer("0123456789\n")+`blob
+mark :3
+data 126
+`+LFSPointer("psd\n")+`commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 small.txt
+M 100644 :2 "big file.bin"
+M 100644 :3 art/x.psd
+M 100644 inline .gitattributes
+data 102
+*.psd filter=lfs diff=lfs merge=lfs -text
+/big[[:space:]]file.bin filter=lfs diff=lfs merge=lfs -text
+
+commit refs/heads/main
+mark :5
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+rename
+from :4
+R "big file.bin" big2.bin
+M 100644 inline .gitattributes
+data 88
+*.psd filter=lfs diff=lfs merge=lfs -text
+/big2.bin filter=lfs diff=lfs merge=lfs -text
+
+commit refs/heads/main
+mark :6
+committer Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 5
+noop
+from :5
+M 100644 :1 small2.txt
+
+`, output)
+
+	sum := sha256.Sum256([]byte("0123456789\n"))
+	oid := hex.EncodeToString(sum[:])
+	content, err := os.ReadFile(filepath.Join(objects, oid[0:2], oid[2:4], oid))
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789\n", string(content))
+}
+
+func TestLFSModes(t *testing.T) {
+	// Blob :1 is used first for a symlink, and :2 first for a
+	// file and then for a symlink; the unmarked blob is referred
+	// to by SHA-1.  Deleting a file removes its entry.
+	input := `blob
+mark :1
+data 11
+target.bin
+blob
+mark :2
+data 11
+0123456789
+blob
+data 11
+abcdefghij
+commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 120000 :1 link
+M 100644 :2 a.bin
+M 120000 :2 link2
+M 100644 ` + BlobSHA1("abcdefghij\n") + ` b.bin
+
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+delete
+from :3
+D a.bin
+D b.bin
+
+`
+	objects := t.TempDir()
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f, err := NewLFSFilter(next, LFSOptions{Threshold: 10, ObjectsDir: objects})
+		assert.Nil(t, err)
+		return f
+	})
+	// The unmarked blob is emitted at once; the others wait for
+	// the commit that uses them.
+	assert.Equal(t, `blob
+data 127
+`+LFSPointer("abcdefghij\n")+`blob
+mark :1
+data 11
+target.bin
+blob
+mark :2
+data 127
+`+LFSPointer("0123456789\n")+`commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 120000 :1 link
+M 100644 :2 a.bin
+M 120000 inline link2
+data 11
+0123456789
+M 100644 `+BlobSHA1(LFSPointer("abcdefghij\n"))+` b.bin
+M 100644 inline .gitattributes
+data 86
+/a.bin filter=lfs diff=lfs merge=lfs -text
+/b.bin filter=lfs diff=lfs merge=lfs -text
+
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+delete
+from :3
+D a.bin
+D b.bin
+D .gitattributes
+
+`, output)
+}
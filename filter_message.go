@@ -0,0 +1,128 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// MessageOptions configures a MessageFilter.
+type MessageOptions struct {
+	// Rules are applied to the messages of commits and tags.
+	Rules ReplaceRules
+
+	// Commit, if set, is called for each commit after Rules have
+	// been applied to its message, and may change anything about
+	// it; typically the message.  An error aborts the filter.
+	Commit func(*CmdCommit) error
+
+	// Tag is the same as Commit, but for annotated tags; their
+	// message is CmdTag.Data.
+	Tag func(*CmdTag) error
+}
+
+// A MessageChange identifies a commit or tag whose message a
+// MessageFilter changed.
+type MessageChange struct {
+	Ref         string `json:"ref"` // the commit's branch or the tag's name
+	Tag         bool   `json:"tag,omitempty"`
+	Mark        int    `json:"mark,omitempty"`
+	OriginalOID string `json:"original_oid,omitempty"`
+}
+
+// A MessageFilter is a CmdWriter that rewrites the messages of
+// commits (CmdCommit.Msg) and annotated tags (CmdTag.Data).
+//
+// Every commit and tag whose message is changed is recorded (but
+// not the messages themselves, which may be large), so that the
+// rewrite may be audited afterward; see Changes and WriteReport.
+type MessageFilter struct {
+	next    CmdWriter
+	rules   ReplaceRules
+	commit  func(*CmdCommit) error
+	tag     func(*CmdTag) error
+	changes []MessageChange
+}
+
+// NewMessageFilter creates a new MessageFilter that writes to next.
+func NewMessageFilter(next CmdWriter, opts MessageOptions) *MessageFilter {
+	return &MessageFilter{
+		next:   next,
+		rules:  opts.Rules,
+		commit: opts.Commit,
+		tag:    opts.Tag,
+	}
+}
+
+// Changes returns the commits and tags whose messages have been
+// changed so far, in stream order.
+func (f *MessageFilter) Changes() []MessageChange {
+	return f.changes
+}
+
+// WriteReport writes the list of commits and tags whose messages
+// were changed to w as indented JSON.
+func (f *MessageFilter) WriteReport(w io.Writer) error {
+	changes := f.changes
+	if changes == nil {
+		changes = []MessageChange{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(changes)
+}
+
+// Do rewrites the message of the command if it is a commit or tag,
+// and passes it on to the next CmdWriter.
+func (f *MessageFilter) Do(cmd Cmd) error {
+	switch c := cmd.(type) {
+	case CmdCommit:
+		old := c.Msg
+		c.Msg = f.rules.Replace(c.Msg)
+		if f.commit != nil {
+			if err := f.commit(&c); err != nil {
+				return err
+			}
+		}
+		if c.Msg != old {
+			f.changes = append(f.changes, MessageChange{
+				Ref:         c.Ref,
+				Mark:        c.Mark,
+				OriginalOID: c.OriginalOID,
+			})
+		}
+		cmd = c
+	case CmdTag:
+		old := c.Data
+		c.Data = f.rules.Replace(c.Data)
+		if f.tag != nil {
+			if err := f.tag(&c); err != nil {
+				return err
+			}
+		}
+		if c.Data != old {
+			f.changes = append(f.changes, MessageChange{
+				Ref:         c.RefName,
+				Tag:         true,
+				Mark:        c.Mark,
+				OriginalOID: c.OriginalOID,
+			})
+		}
+		cmd = c
+	}
+	return f.next.Do(cmd)
+}
@@ -0,0 +1,108 @@
+// Tests for message rewriting filter
+
+package libfastimport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceRules(t *testing.T) {
+	rules, err := ParseReplaceRules(strings.NewReader(`# comment
+password
+literal:$1.00==>cheap
+regex:https?://bugs\.internal/(\d+)==>BUG-$1
+a==>b==>c
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(rules))
+	assert.Equal(t, "my ***REMOVED***, cheap, BUG-42, c",
+		rules.Replace("my password, $1.00, http://bugs.internal/42, a==>b"))
+
+	_, err = ParseReplaceRules(strings.NewReader("regex:(\n"))
+	assert.NotNil(t, err)
+}
+
+func TestMessageFilter(t *testing.T) {
+	input := `commit refs/heads/main
+mark :1
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 37
+see https://bugs.internal/7 for more
+M 100644 inline a.txt
+data 2
+a
+
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 6
+tidy!
+from :1
+
+tag v1
+from :2
+tagger Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 28
+see https://bugs.internal/9
+`
+	rules, err := ParseReplaceRules(strings.NewReader("regex:https://bugs\\.internal/(\\d+)==>BUG-$1\n"))
+	assert.Nil(t, err)
+	var f *MessageFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f = NewMessageFilter(next, MessageOptions{
+			Rules: rules,
+			Commit: func(c *CmdCommit) error {
+				c.Msg = strings.TrimRight(c.Msg, "\n") + fmt.Sprintf("\n\n[p4 change %d]\n", 100+c.Mark)
+				return nil
+			},
+			Tag: func(c *CmdTag) error {
+				c.Data += "[release]\n"
+				return nil
+			},
+		})
+		return f
+	})
+	assert.Equal(t, `commit refs/heads/main
+mark :1
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 36
+see BUG-7 for more
+
+[p4 change 101]
+M 100644 inline a.txt
+data 2
+a
+
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 23
+tidy!
+
+[p4 change 102]
+from :1
+
+tag v1
+from :2
+tagger Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 20
+see BUG-9
+[release]
+`, output)
+
+	assert.Equal(t, []MessageChange{
+		{Ref: "refs/heads/main", Mark: 1},
+		{Ref: "refs/heads/main", Mark: 2},
+		{Ref: "v1", Tag: true},
+	}, f.Changes())
+
+	var report bytes.Buffer
+	assert.Nil(t, f.WriteReport(&report))
+	assert.NotContains(t, report.String(), "tidy!")
+	assert.Contains(t, report.String(), `"tag": true`)
+}
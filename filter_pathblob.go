@@ -0,0 +1,281 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+// pathBlobFilter is the common core of filters that transform blob
+// content in a way that depends on the path that the blob is stored
+// at.
+//
+// A CmdBlob doesn't know its path; only the FileModify commands that
+// later refer to its mark do.  So pathBlobFilter holds on to each
+// marked CmdBlob until the commit that first uses it, and holds on to
+// each commit until its end; then it emits the (converted) blobs that
+// the commit needs, followed by the commit itself.
+//
+// A blob that is used at several paths is converted according to the
+// first path that it is used at, and later uses share the result,
+// unless key says that a later path converts it differently.  Then
+// the blob is converted again, and stored inline.
+//
+// An unmarked CmdBlob can only be referred to by its SHA-1, which
+// converting it may change; FileModify commands that refer to it are
+// changed to refer to the converted blob.
+type pathBlobFilter struct {
+	next CmdWriter
+
+	// convert transforms blob content that is to be stored at
+	// path, with mode.  For blobs that are never used by a
+	// commit, path is empty and mode is 0.
+	convert func(path Path, mode Mode, data string) (string, error)
+
+	// resolve, if non-nil, returns the content of a blob that was
+	// not defined in the stream (a SHA-1, or a mark from an
+	// earlier import), so that it may be converted too.  If the
+	// converted content differs, the FileModify is replaced with a
+	// FileModifyInline.
+	resolve func(dataref string) (string, error)
+
+	// endCommit, if non-nil, is called once the blobs for a
+	// commit have been emitted, but before the commit itself is.
+	// It may modify the commit and its files, and may return
+	// extra commands to append to the commit.  emitted holds the content of blobs
+	// that were emitted for this commit, by mark.
+	endCommit func(commit *CmdCommit, files []Cmd, emitted map[int]string) ([]Cmd, error)
+
+	// key, if non-nil, says which paths content is converted the
+	// same way at: any two paths with the same key.  The original
+	// content of each blob for which retain returns true is kept,
+	// so that it may be converted again for a path with another
+	// key.
+	key    func(path Path) string
+	retain func(data string) bool
+
+	pending      map[int]CmdBlob
+	pendingOrder []int
+	defined      map[int]bool
+	converted    map[string]convertedBlob // by the blob's mark reference or SHA-1
+
+	commit *CmdCommit
+	files  []Cmd
+}
+
+func newPathBlobFilter(next CmdWriter) *pathBlobFilter {
+	return &pathBlobFilter{
+		next:      next,
+		pending:   make(map[int]CmdBlob),
+		defined:   make(map[int]bool),
+		converted: make(map[string]convertedBlob),
+	}
+}
+
+// A convertedBlob records a blob that has been emitted.
+type convertedBlob struct {
+	key      string // of the path that it was converted for
+	orig     string // the original content, if it was retained
+	retained bool
+	dataref  string // what to refer to the converted blob by
+}
+
+// record records a blob that has been emitted, converted from orig for
+// path, if later uses of it may need to be changed.
+func (f *pathBlobFilter) record(dataref string, path Path, orig, data string) {
+	b := convertedBlob{dataref: dataref}
+	if dataref != markRef(parseMarkRef(dataref)) {
+		// An unmarked blob.
+		b.dataref = BlobSHA1(data)
+	}
+	if f.key != nil && f.retain(orig) {
+		b.key = f.key(path)
+		b.orig = orig
+		b.retained = true
+	} else if b.dataref == dataref {
+		return
+	}
+	f.converted[dataref] = b
+}
+
+func (f *pathBlobFilter) Do(cmd Cmd) error {
+	if f.commit != nil {
+		switch cmd.(type) {
+		case CmdCommitEnd:
+			return f.finishCommit()
+		default:
+			if cmdIs(cmd, cmdClassInCommit) {
+				f.files = append(f.files, cmd)
+				return nil
+			}
+			if err := f.finishCommit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch c := cmd.(type) {
+	case CmdBlob:
+		if c.Mark < 1 {
+			// Can only be referred to by SHA-1; convert it
+			// as if it were never used.
+			orig := c.Data
+			data, err := f.convert("", 0, orig)
+			if err != nil {
+				return err
+			}
+			c.Data = data
+			f.record(BlobSHA1(orig), "", orig, data)
+			return f.next.Do(c)
+		}
+		delete(f.converted, markRef(c.Mark))
+		if _, dup := f.pending[c.Mark]; !dup {
+			f.pendingOrder = append(f.pendingOrder, c.Mark)
+		}
+		f.pending[c.Mark] = c
+		f.defined[c.Mark] = true
+		return nil
+	case CmdCommit:
+		f.commit = &c
+		f.files = nil
+		return nil
+	case CmdCommitEnd:
+		return f.next.Do(c)
+	case CmdComment, CmdProgress, CmdReset, CmdFeature, CmdOption:
+		return f.next.Do(c)
+	default:
+		// Anything else might refer to a pending blob.
+		if err := f.Flush(); err != nil {
+			return err
+		}
+		return f.next.Do(cmd)
+	}
+}
+
+func (f *pathBlobFilter) takePending(mark int) CmdBlob {
+	blob := f.pending[mark]
+	delete(f.pending, mark)
+	for i, m := range f.pendingOrder {
+		if m == mark {
+			f.pendingOrder = append(f.pendingOrder[:i], f.pendingOrder[i+1:]...)
+			break
+		}
+	}
+	return blob
+}
+
+// emitBlob emits the pending blob with the given mark, converting it
+// as if it were stored at path with mode.  It returns the converted
+// content.
+func (f *pathBlobFilter) emitBlob(mark int, path Path, mode Mode) (string, error) {
+	blob := f.takePending(mark)
+	data, err := f.convert(path, mode, blob.Data)
+	if err != nil {
+		return "", err
+	}
+	f.record(markRef(mark), path, blob.Data, data)
+	blob.Data = data
+	return data, f.next.Do(blob)
+}
+
+func (f *pathBlobFilter) finishCommit() error {
+	commit, files := f.commit, f.files
+	f.commit, f.files = nil, nil
+
+	emitted := make(map[int]string)
+	for i, cmd := range files {
+		switch c := cmd.(type) {
+		case FileModify:
+			mark := parseMarkRef(c.DataRef)
+			if _, ok := f.pending[mark]; ok {
+				data, err := f.emitBlob(mark, c.Path, c.Mode)
+				if err != nil {
+					return err
+				}
+				emitted[mark] = data
+			} else if b, ok := f.converted[c.DataRef]; ok && c.Mode != ModeGit {
+				if b.retained && f.key(c.Path) != b.key {
+					data, err := f.convert(c.Path, c.Mode, b.orig)
+					if err != nil {
+						return err
+					}
+					files[i] = FileModifyInline{Mode: c.Mode, Path: c.Path, Data: data}
+				} else {
+					c.DataRef = b.dataref
+					files[i] = c
+				}
+			} else if f.resolve != nil && !f.defined[mark] && c.Mode != ModeGit {
+				orig, err := f.resolve(c.DataRef)
+				if err != nil {
+					return err
+				}
+				data, err := f.convert(c.Path, c.Mode, orig)
+				if err != nil {
+					return err
+				}
+				if data != orig {
+					files[i] = FileModifyInline{Mode: c.Mode, Path: c.Path, Data: data}
+				}
+			}
+		case FileModifyInline:
+			data, err := f.convert(c.Path, c.Mode, c.Data)
+			if err != nil {
+				return err
+			}
+			c.Data = data
+			files[i] = c
+		case NoteModify:
+			mark := parseMarkRef(c.DataRef)
+			if _, ok := f.pending[mark]; ok {
+				// Notes aren't stored at a path; pass
+				// them through unconverted.
+				if err := f.next.Do(f.takePending(mark)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if f.endCommit != nil {
+		extra, err := f.endCommit(commit, files, emitted)
+		if err != nil {
+			return err
+		}
+		files = append(files, extra...)
+	}
+
+	if err := f.next.Do(*commit); err != nil {
+		return err
+	}
+	for _, cmd := range files {
+		if err := f.next.Do(cmd); err != nil {
+			return err
+		}
+	}
+	return f.next.Do(CmdCommitEnd{})
+}
+
+// Flush emits any commit that is being held, and then any blobs that
+// no commit has used yet.
+func (f *pathBlobFilter) Flush() error {
+	if f.commit != nil {
+		if err := f.finishCommit(); err != nil {
+			return err
+		}
+	}
+	for len(f.pendingOrder) > 0 {
+		if _, err := f.emitBlob(f.pendingOrder[0], "", 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
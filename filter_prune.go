@@ -0,0 +1,364 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"sort"
+)
+
+// PruneOptions configures a PruneFilter.
+type PruneOptions struct {
+	// Filters makes the CmdWriter that the PruneFilter filters
+	// commands through, before pruning what they write to next.
+	// If nil, commands are pruned as they are.
+	Filters func(next CmdWriter) CmdWriter
+
+	// PruneEmpty sets whether commits that are empty before
+	// filtering are removed too, rather than only those that the
+	// filters leave empty.
+	PruneEmpty bool
+}
+
+// A PruneFilter is a CmdWriter that filters commands through other
+// filters (see PruneOptions.Filters), and then removes the commits
+// that they leave empty, as "git filter-repo" does with "--prune-empty
+// auto --prune-degenerate auto".
+//
+// A non-merge commit that has file (or note) commands before
+// filtering, but none after, is removed; anything that referred to it
+// (the From and Merge of later commits, CmdReset, CmdTag, CmdLs, and
+// CmdGetMark) is changed to refer to its nearest kept ancestor
+// instead, and a branch whose tip was removed is reset to that
+// ancestor.  A tag whose target has no kept ancestor at all is
+// dropped.
+//
+// A merge is collapsed when removing commits makes it degenerate: a
+// parent that was replaced by its nearest kept ancestor is dropped if
+// it is the same as, or an ancestor of, another parent.  A merge that
+// is left with a single parent and no file commands is removed like
+// any other empty commit.  Merges that were degenerate to begin with
+// are left alone.
+//
+// The filters must pass every commit on, in order.  Commits can only
+// be referred to reliably by mark; the branch name is used for a kept
+// commit that has no mark, which is only correct until the branch
+// moves on.
+type PruneFilter struct {
+	next       CmdWriter
+	filters    CmdWriter
+	pruneEmpty bool
+
+	orig   []bool // of each commit not yet pruned: whether it had changes before filtering
+	commit *CmdCommit
+	files  []Cmd
+
+	graph     *Graph            // of the commands that have been passed on
+	replaced  map[string]string // removed commit => nearest kept ancestor
+	tip       map[string]string // ref => what it should point at
+	importTip map[string]string // ref => what the Backend has it pointing at
+	moved     map[string]bool   // ref => whether tip is the replacement for a removed commit
+
+	pruned      int
+	droppedTags int
+}
+
+// pruneWriter is the CmdWriter that a PruneFilter's filters write to.
+type pruneWriter struct {
+	f *PruneFilter
+}
+
+func (w pruneWriter) Do(cmd Cmd) error {
+	return w.f.prune(cmd)
+}
+
+// NewPruneFilter creates a new PruneFilter that writes to next.
+//
+// The filter holds on to commands, so Flush must be called once the
+// last command has been written to it.
+func NewPruneFilter(next CmdWriter, opts PruneOptions) *PruneFilter {
+	f := &PruneFilter{
+		next:       next,
+		pruneEmpty: opts.PruneEmpty,
+		graph:      NewGraph(),
+		replaced:   make(map[string]string),
+		tip:        make(map[string]string),
+		importTip:  make(map[string]string),
+		moved:      make(map[string]bool),
+	}
+	f.filters = pruneWriter{f}
+	if opts.Filters != nil {
+		f.filters = opts.Filters(f.filters)
+	}
+	return f
+}
+
+// Pruned returns the number of commits that have been removed so far.
+func (f *PruneFilter) Pruned() int {
+	return f.pruned
+}
+
+// DroppedTags returns the number of annotated tags that have been
+// dropped because nothing was left for them to point at.
+func (f *PruneFilter) DroppedTags() int {
+	return f.droppedTags
+}
+
+// resolve returns what a commit-ish should now refer to, or "" if it
+// referred to a removed commit with no kept ancestors.
+func (f *PruneFilter) resolve(commitish string) string {
+	if r, ok := f.replaced[commitish]; ok {
+		return r
+	}
+	if t, ok := f.tip[commitish]; ok && t != f.importTip[commitish] {
+		return t
+	}
+	return commitish
+}
+
+// emit passes a command on to the next CmdWriter.
+func (f *PruneFilter) emit(cmd Cmd) error {
+	if err := f.graph.Do(cmd); err != nil {
+		return err
+	}
+	return f.next.Do(cmd)
+}
+
+// Do passes the command through the filters, and then on to the next
+// CmdWriter, unless it is part of a commit that is to be removed.
+func (f *PruneFilter) Do(cmd Cmd) error {
+	switch cmd.(type) {
+	case CmdCommit:
+		f.orig = append(f.orig, false)
+	case FileModify, FileModifyInline, FileDelete, FileCopy, FileRename, FileDeleteAll, NoteModify, NoteModifyInline:
+		if len(f.orig) > 0 {
+			f.orig[len(f.orig)-1] = true
+		}
+	}
+	return f.filters.Do(cmd)
+}
+
+// prune is called with each command that the filters write.
+func (f *PruneFilter) prune(cmd Cmd) error {
+	if f.commit != nil {
+		switch cmd.(type) {
+		case CmdCommitEnd:
+			return f.finishCommit()
+		default:
+			if cmdIs(cmd, cmdClassInCommit) {
+				f.files = append(f.files, cmd)
+				return nil
+			}
+			if err := f.finishCommit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch c := cmd.(type) {
+	case CmdCommit:
+		f.commit = &c
+		f.files = nil
+		return nil
+	case CmdReset:
+		if c.CommitIsh != "" {
+			c.CommitIsh = f.resolve(c.CommitIsh)
+		}
+		f.tip[c.RefName] = c.CommitIsh
+		f.importTip[c.RefName] = c.CommitIsh
+		f.moved[c.RefName] = false
+		return f.emit(c)
+	case CmdTag:
+		c.CommitIsh = f.resolve(c.CommitIsh)
+		if c.CommitIsh == "" {
+			f.droppedTags++
+			return nil
+		}
+		return f.emit(c)
+	case CmdLs:
+		if c.DataRef != "" {
+			c.DataRef = f.resolve(c.DataRef)
+		}
+		return f.emit(c)
+	case CmdGetMark:
+		if mark := parseMarkRef(f.resolve(markRef(c.Mark))); mark > 0 {
+			c.Mark = mark
+		}
+		return f.emit(c)
+	case CmdDone:
+		if err := f.flush(); err != nil {
+			return err
+		}
+		return f.emit(c)
+	default:
+		return f.emit(cmd)
+	}
+}
+
+func (f *PruneFilter) finishCommit() error {
+	commit, files := f.commit, f.files
+	f.commit, f.files = nil, nil
+	hadChanges := true
+	if len(f.orig) > 0 {
+		hadChanges, f.orig = f.orig[0], f.orig[1:]
+	}
+
+	changed := false
+	for i, cmd := range files {
+		switch c := cmd.(type) {
+		case FileModify, FileModifyInline, FileDelete, FileCopy, FileRename, FileDeleteAll, NoteModify, NoteModifyInline:
+			changed = true
+		case CmdLs:
+			if c.DataRef != "" {
+				c.DataRef = f.resolve(c.DataRef)
+				files[i] = c
+			}
+		}
+	}
+
+	// The full list of parents, with removed commits replaced.
+	var parents []string
+	var replaced []bool
+	if commit.From != "" {
+		parents = append(parents, f.resolve(commit.From))
+		replaced = append(replaced, parents[0] != commit.From)
+	} else {
+		parents = append(parents, f.tip[commit.Ref])
+		replaced = append(replaced, f.moved[commit.Ref])
+	}
+	for _, p := range commit.Merge {
+		parents = append(parents, f.resolve(p))
+		replaced = append(replaced, parents[len(parents)-1] != p)
+	}
+	parents = f.reduceParents(parents, replaced)
+
+	self := commit.Ref
+	if commit.Mark > 0 {
+		self = markRef(commit.Mark)
+	}
+
+	wasMerge := len(commit.Merge) > 0
+	if !changed && len(parents) < 2 && (hadChanges || wasMerge || f.pruneEmpty) {
+		f.pruned++
+		replacement := ""
+		if len(parents) > 0 {
+			replacement = parents[0]
+		}
+		if commit.Mark > 0 {
+			f.replaced[self] = replacement
+		}
+		f.tip[commit.Ref] = replacement
+		f.moved[commit.Ref] = true
+		return nil
+	}
+
+	switch {
+	case len(parents) == 0:
+		commit.From = ""
+		if f.importTip[commit.Ref] != "" {
+			// The Backend would give the commit the
+			// branch's current tip as a parent.
+			if err := f.emit(CmdReset{RefName: commit.Ref}); err != nil {
+				return err
+			}
+		}
+	case commit.From == "" && parents[0] == f.importTip[commit.Ref]:
+		// Leave the parent implicit.
+	default:
+		commit.From = parents[0]
+	}
+	if len(parents) > 1 {
+		commit.Merge = parents[1:]
+	} else {
+		commit.Merge = nil
+	}
+
+	f.tip[commit.Ref] = self
+	f.importTip[commit.Ref] = self
+	f.moved[commit.Ref] = false
+
+	if err := f.emit(*commit); err != nil {
+		return err
+	}
+	for _, cmd := range files {
+		if err := f.emit(cmd); err != nil {
+			return err
+		}
+	}
+	return f.emit(CmdCommitEnd{})
+}
+
+// reduceParents removes parents that are missing, and parents that
+// were replaced by their nearest kept ancestor and are the same as, or
+// an ancestor of, another parent.
+func (f *PruneFilter) reduceParents(parents []string, replaced []bool) []string {
+	var ret []string
+	for i, p := range parents {
+		if p == "" {
+			continue
+		}
+		redundant := false
+		for j, q := range parents {
+			if q == "" || i == j {
+				continue
+			}
+			if q == p && j < i && (replaced[i] || replaced[j]) {
+				redundant = true
+				break
+			}
+			if replaced[i] && q != p && f.graph.IsAncestor(f.graph.Commit(p), f.graph.Commit(q)) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Flush flushes the filters (if they have a Flush method), emits any
+// commit that is being held, and then resets any branch whose tip was
+// removed to the nearest kept ancestor.
+func (f *PruneFilter) Flush() error {
+	if flusher, ok := f.filters.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.flush()
+}
+
+func (f *PruneFilter) flush() error {
+	if f.commit != nil {
+		if err := f.finishCommit(); err != nil {
+			return err
+		}
+	}
+	var refs []string
+	for ref, tip := range f.tip {
+		if tip != "" && tip != f.importTip[ref] {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		if err := f.prune(CmdReset{RefName: ref, CommitIsh: f.tip[ref]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Tests for empty-commit pruning filter
+
+package libfastimport
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dropPathFilter drops the file commands for one path.
+type dropPathFilter struct {
+	next CmdWriter
+	path Path
+}
+
+func (f dropPathFilter) Do(cmd Cmd) error {
+	switch c := cmd.(type) {
+	case FileModify:
+		if c.Path == f.path {
+			return nil
+		}
+	case FileModifyInline:
+		if c.Path == f.path {
+			return nil
+		}
+	}
+	return f.next.Do(cmd)
+}
+
+func TestPrune(t *testing.T) {
+	commit := func(ref string, mark int, extra string) string {
+		return fmt.Sprintf("commit %s\nmark :%d\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n%d\n%s\n",
+			ref, mark, mark%10, extra)
+	}
+	tag := func(name, from string) string {
+		return fmt.Sprintf("tag %s\nfrom %s\ntagger Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\nt\n", name, from)
+	}
+	x := "M 100644 inline x\ndata 2\nx\n"
+	input := commit("refs/heads/main", 1, "M 100644 inline a\ndata 2\na\n") +
+		commit("refs/heads/main", 2, "from :1\n"+x) +
+		commit("refs/heads/main", 3, "from :2\nM 100644 inline b\ndata 2\nb\n") +
+		commit("refs/heads/side", 4, "from :2\nM 100644 inline c\ndata 2\nc\n") +
+		commit("refs/heads/main", 5, "from :3\nmerge :4\n") +
+		commit("refs/heads/side", 6, "from :4\n"+x) +
+		commit("refs/heads/feature", 7, "from :1\n"+x) +
+		commit("refs/heads/main", 8, "from :5\nmerge :6\n") +
+		tag("v1", ":2") +
+		"reset refs/tags/x\nfrom :8\n\n" +
+		commit("refs/heads/empty", 9, "") +
+		tag("v2", ":9") +
+		commit("refs/heads/main", 10, "from :8\nmerge :2\nD a\n") +
+		commit("refs/heads/topic", 11, "from :10\nM 100644 inline d\ndata 2\nd\n") +
+		commit("refs/heads/main", 12, "from :10\nmerge :11\n") +
+		commit("refs/heads/main", 13, "from :12\n")
+
+	t.Run("default", func(t *testing.T) {
+		var f *PruneFilter
+		output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+			f = NewPruneFilter(next, PruneOptions{
+				Filters: func(next CmdWriter) CmdWriter { return dropPathFilter{next, "x"} },
+			})
+			return f
+		})
+		assert.Equal(t, commit("refs/heads/main", 1, "M 100644 inline a\ndata 2\na\n")+
+			commit("refs/heads/main", 3, "from :1\nM 100644 inline b\ndata 2\nb\n")+
+			commit("refs/heads/side", 4, "from :1\nM 100644 inline c\ndata 2\nc\n")+
+			commit("refs/heads/main", 5, "from :3\nmerge :4\n")+
+			tag("v1", ":1")+
+			"reset refs/tags/x\nfrom :5\n"+
+			commit("refs/heads/empty", 9, "")+
+			tag("v2", ":9")+
+			commit("refs/heads/main", 10, "from :5\nD a\n")+
+			commit("refs/heads/topic", 11, "from :10\nM 100644 inline d\ndata 2\nd\n")+
+			commit("refs/heads/main", 12, "from :10\nmerge :11\n")+
+			commit("refs/heads/main", 13, "from :12\n")+
+			"reset refs/heads/feature\nfrom :1\n", output)
+		assert.Equal(t, 4, f.Pruned())
+		assert.Equal(t, 0, f.DroppedTags())
+	})
+
+	t.Run("PruneEmpty", func(t *testing.T) {
+		var f *PruneFilter
+		output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+			f = NewPruneFilter(next, PruneOptions{
+				Filters:    func(next CmdWriter) CmdWriter { return dropPathFilter{next, "x"} },
+				PruneEmpty: true,
+			})
+			return f
+		})
+		assert.Equal(t, commit("refs/heads/main", 1, "M 100644 inline a\ndata 2\na\n")+
+			commit("refs/heads/main", 3, "from :1\nM 100644 inline b\ndata 2\nb\n")+
+			commit("refs/heads/side", 4, "from :1\nM 100644 inline c\ndata 2\nc\n")+
+			commit("refs/heads/main", 5, "from :3\nmerge :4\n")+
+			tag("v1", ":1")+
+			"reset refs/tags/x\nfrom :5\n"+
+			commit("refs/heads/main", 10, "from :5\nD a\n")+
+			commit("refs/heads/topic", 11, "from :10\nM 100644 inline d\ndata 2\nd\n")+
+			commit("refs/heads/main", 12, "from :10\nmerge :11\n")+
+			"reset refs/heads/feature\nfrom :1\n", output)
+		assert.Equal(t, 6, f.Pruned())
+		assert.Equal(t, 1, f.DroppedTags())
+	})
+}
+
+func TestPruneUnfiltered(t *testing.T) {
+	// Nothing is filtered, so a "--no-ff" merge, whose first parent
+	// is an ancestor of its second, and an empty commit are kept.
+	input := "commit refs/heads/main\nmark :1\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n1\nM 100644 inline a\ndata 2\na\n\n" +
+		"commit refs/heads/main\nmark :2\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n2\nfrom :1\n\n" +
+		"commit refs/heads/topic\nmark :3\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n3\nfrom :2\nM 100644 inline b\ndata 2\nb\n\n" +
+		"commit refs/heads/main\nmark :4\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n4\nfrom :2\nmerge :3\n\n"
+	var f *PruneFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f = NewPruneFilter(next, PruneOptions{})
+		return f
+	})
+	assert.Equal(t, input, output)
+	assert.Equal(t, 0, f.Pruned())
+}
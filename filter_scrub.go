@@ -0,0 +1,193 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ScrubOptions configures a ScrubFilter.
+type ScrubOptions struct {
+	// Rules are applied to the content of blobs.
+	Rules ReplaceRules
+
+	// If Paths is non-empty, only files stored at a path matching
+	// one of the patterns (see PathPattern) are scanned.  Blobs
+	// that are never used at a path are then not scanned either.
+	// A blob is scrubbed (or not) according to the first path
+	// that it is used at; wherever it is later used at a path
+	// that is scrubbed differently, it is stored inline.
+	Paths []string
+
+	// Resolve, if set, returns the content of a blob that a
+	// FileModify refers to but that was not defined in the
+	// stream: a SHA-1, or a mark that was loaded from an external
+	// marks file (with "feature import-marks" or
+	// --import-marks).  The commit is changed to use the scrubbed
+	// content inline, and the marks file is left alone.  A
+	// function that calls Backend.CatBlob is the usual choice.
+	//
+	// If Resolve is not set, such files are not scanned, and are
+	// listed as Unscanned in the report.
+	Resolve func(dataref string) (string, error)
+}
+
+// A ScrubCommit records the files in a commit that a ScrubFilter
+// altered, or that it could not scan.
+type ScrubCommit struct {
+	Ref         string `json:"ref"`
+	Mark        int    `json:"mark,omitempty"`
+	OriginalOID string `json:"original_oid,omitempty"`
+	Paths       []Path `json:"paths,omitempty"`
+	Unscanned   []Path `json:"unscanned,omitempty"`
+}
+
+// A ScrubFilter is a CmdWriter that scrubs secrets (passwords,
+// keys, ...) out of the content of files, by applying ReplaceRules to
+// the data of every CmdBlob and FileModifyInline, in the manner of
+// BFG's --replace-text.
+type ScrubFilter struct {
+	*pathBlobFilter
+
+	rules      ReplaceRules
+	patterns   []*PathPattern
+	hasResolve bool
+
+	scrubbed     map[int]bool // blob marks whose content was changed
+	commitPaths  []Path       // paths scrubbed in the current commit
+	commits      []ScrubCommit
+	unreferenced int
+}
+
+// NewScrubFilter creates a new ScrubFilter that writes to next.
+//
+// The filter holds on to commands, so Flush must be called once the
+// last command has been written to it.
+func NewScrubFilter(next CmdWriter, opts ScrubOptions) (*ScrubFilter, error) {
+	patterns, err := compilePathPatterns(opts.Paths)
+	if err != nil {
+		return nil, err
+	}
+	f := &ScrubFilter{
+		pathBlobFilter: newPathBlobFilter(next),
+		rules:          opts.Rules,
+		patterns:       patterns,
+		hasResolve:     opts.Resolve != nil,
+		scrubbed:       make(map[int]bool),
+	}
+	f.pathBlobFilter.convert = f.convert
+	f.pathBlobFilter.resolve = opts.Resolve
+	f.pathBlobFilter.endCommit = f.endCommit
+	if len(patterns) > 0 {
+		f.pathBlobFilter.key = func(path Path) string {
+			if f.scan(path) {
+				return "scan"
+			}
+			return ""
+		}
+		f.pathBlobFilter.retain = func(data string) bool {
+			return f.rules.Replace(data) != data
+		}
+	}
+	return f, nil
+}
+
+// Commits returns the commits that have had files altered so far, in
+// stream order.
+func (f *ScrubFilter) Commits() []ScrubCommit {
+	return f.commits
+}
+
+// Unreferenced returns the number of blobs that were altered but that
+// no commit used.
+func (f *ScrubFilter) Unreferenced() int {
+	return f.unreferenced
+}
+
+// WriteReport writes the list of altered commits to w as indented
+// JSON.
+func (f *ScrubFilter) WriteReport(w io.Writer) error {
+	commits := f.commits
+	if commits == nil {
+		commits = []ScrubCommit{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(commits)
+}
+
+func (f *ScrubFilter) scan(path Path) bool {
+	if len(f.patterns) == 0 {
+		return true
+	}
+	return path != "" && matchAny(f.patterns, path)
+}
+
+func (f *ScrubFilter) convert(path Path, _ Mode, data string) (string, error) {
+	if !f.scan(path) {
+		return data, nil
+	}
+	ret := f.rules.Replace(data)
+	if ret != data {
+		if path == "" {
+			f.unreferenced++
+		} else {
+			f.commitPaths = append(f.commitPaths, path)
+		}
+	}
+	return ret, nil
+}
+
+func (f *ScrubFilter) endCommit(commit *CmdCommit, files []Cmd, emitted map[int]string) ([]Cmd, error) {
+	rec := ScrubCommit{
+		Ref:         commit.Ref,
+		Mark:        commit.Mark,
+		OriginalOID: commit.OriginalOID,
+		Paths:       f.commitPaths,
+	}
+	f.commitPaths = nil
+
+	for _, cmd := range files {
+		c, ok := cmd.(FileModify)
+		if !ok || c.Mode == ModeGit {
+			continue
+		}
+		mark := parseMarkRef(c.DataRef)
+		if _, ok := emitted[mark]; ok {
+			// Converted just now; already recorded if it
+			// changed.
+			for _, path := range rec.Paths {
+				if path == c.Path {
+					f.scrubbed[mark] = true
+				}
+			}
+			continue
+		}
+		switch {
+		case f.scrubbed[mark]:
+			// An earlier commit's blob that was altered.
+			rec.Paths = append(rec.Paths, c.Path)
+		case !f.defined[mark] && !f.hasResolve && f.scan(c.Path):
+			rec.Unscanned = append(rec.Unscanned, c.Path)
+		}
+	}
+
+	if len(rec.Paths) > 0 || len(rec.Unscanned) > 0 {
+		f.commits = append(f.commits, rec)
+	}
+	return nil, nil
+}
@@ -0,0 +1,188 @@
+// Tests for secret scrubbing filter
+
+package libfastimport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrub(t *testing.T) {
+	input := `blob
+mark :1
+data 15
+pass=hunter22!
+blob
+mark :2
+data 9
+hunter22
+commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 conf.txt
+M 100644 :2 logo.bin
+M 100644 :9 old.txt
+M 100644 inline new.txt
+data 9
+hunter22
+
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 5
+copy
+from :3
+M 100644 :1 conf2.txt
+
+`
+	rules, err := ParseReplaceRules(strings.NewReader("hunter22\nregex:token=\\w+==>token=XXX\n"))
+	assert.Nil(t, err)
+
+	var f *ScrubFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f, err = NewScrubFilter(next, ScrubOptions{
+			Rules: rules,
+			Paths: []string{"*.txt"},
+			Resolve: func(dataref string) (string, error) {
+				assert.Equal(t, ":9", dataref)
+				return "token=abc\n", nil
+			},
+		})
+		assert.Nil(t, err)
+		return f
+	})
+	assert.Equal(t, `blob
+mark :1
+data 20
+pass=***REMOVED***!
+blob
+mark :2
+data 9
+hunter22
+commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 conf.txt
+M 100644 :2 logo.bin
+M 100644 inline old.txt
+data 10
+token=XXX
+M 100644 inline new.txt
+data 14
+***REMOVED***
+
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 5
+copy
+from :3
+M 100644 :1 conf2.txt
+
+`, output)
+	assert.Equal(t, []ScrubCommit{
+		{Ref: "refs/heads/main", Mark: 3, Paths: []Path{"conf.txt", "old.txt", "new.txt"}},
+		{Ref: "refs/heads/main", Mark: 4, Paths: []Path{"conf2.txt"}},
+	}, f.Commits())
+
+	// Without Resolve, the externally-marked blob can't be
+	// scanned, and the report says so.
+	runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f, err = NewScrubFilter(next, ScrubOptions{Rules: rules, Paths: []string{"*.txt"}})
+		assert.Nil(t, err)
+		return f
+	})
+	assert.Equal(t, []Path{"old.txt"}, f.Commits()[0].Unscanned)
+}
+
+func TestScrubPerPath(t *testing.T) {
+	// The blobs are first used at paths that aren't scrubbed, and
+	// then at ones that are; the unmarked blob is referred to by
+	// its SHA-1.
+	input := `blob
+mark :1
+data 9
+hunter22
+blob
+data 9
+hunter22
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 logo.bin
+M 100644 2ec0c7d61cd098152d8ff374d01bdeeb797321f1 icon.bin
+M 100644 :1 conf.txt
+
+commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 5
+copy
+from :2
+M 100644 2ec0c7d61cd098152d8ff374d01bdeeb797321f1 conf2.txt
+M 100644 :1 logo2.bin
+
+`
+	rules, err := ParseReplaceRules(strings.NewReader("hunter22\n"))
+	assert.Nil(t, err)
+
+	var f *ScrubFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f, err = NewScrubFilter(next, ScrubOptions{Rules: rules, Paths: []string{"*.txt"}})
+		assert.Nil(t, err)
+		return f
+	})
+	assert.Equal(t, `blob
+data 9
+hunter22
+blob
+mark :1
+data 9
+hunter22
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 logo.bin
+M 100644 2ec0c7d61cd098152d8ff374d01bdeeb797321f1 icon.bin
+M 100644 inline conf.txt
+data 14
+***REMOVED***
+
+commit refs/heads/main
+mark :3
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 5
+copy
+from :2
+M 100644 inline conf2.txt
+data 14
+***REMOVED***
+M 100644 :1 logo2.bin
+
+`, output)
+	assert.Equal(t, []ScrubCommit{
+		{Ref: "refs/heads/main", Mark: 2, Paths: []Path{"conf.txt"}},
+		{Ref: "refs/heads/main", Mark: 3, Paths: []Path{"conf2.txt"}},
+	}, f.Commits())
+
+	// Without Paths, every use of the unmarked blob refers to the
+	// scrubbed copy.
+	output = runFilter(t, input, func(next CmdWriter) CmdWriter {
+		f, err = NewScrubFilter(next, ScrubOptions{Rules: rules})
+		assert.Nil(t, err)
+		return f
+	})
+	assert.Contains(t, output, "M 100644 0819b043fc6a50c1e196d8b1aee6cdf894443a2d icon.bin\n")
+	assert.Contains(t, output, "M 100644 0819b043fc6a50c1e196d8b1aee6cdf894443a2d conf2.txt\n")
+	assert.NotContains(t, output, "hunter22")
+}
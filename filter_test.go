@@ -0,0 +1,276 @@
+// Tests for filters
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runFilter reads every command from input and passes it to the
+// CmdWriter that mkFilter wraps around a Backend, returning what the
+// Backend wrote.
+func runFilter(t *testing.T, input string, mkFilter func(CmdWriter) CmdWriter) string {
+	t.Helper()
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	filter := mkFilter(backend)
+	frontend := NewFrontend(strings.NewReader(input), nil, nil)
+	for {
+		cmd, err := frontend.ReadCmd()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("ERROR: Failed to read cmd: %v\n", err)
+			}
+			break
+		}
+		if err := filter.Do(cmd); err != nil {
+			t.Errorf("ERROR: Failed to filter cmd: %v\n", err)
+		}
+	}
+	if flusher, ok := filter.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			t.Errorf("ERROR: Failed to flush filter: %v\n", err)
+		}
+	}
+	bw.Flush()
+	return outbuf.String()
+}
+
+func TestBlobSHA1(t *testing.T) {
+	assert.Equal(t, "9daeafb9864cf43055ae93beb0afd6c7d144bfa4", BlobSHA1("test\n"))
+}
+
+const dedupInput = `blob
+mark :1
+data 5
+test
+blob
+mark :2
+data 5
+test
+blob
+data 4
+abc
+blob
+mark :3
+data 4
+abc
+get-mark :2
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+M 100644 :2 b.txt
+M 100644 :3 c.txt
+
+`
+
+func TestDedupRewrite(t *testing.T) {
+	var dedup *DedupFilter
+	output := runFilter(t, dedupInput, func(next CmdWriter) CmdWriter {
+		dedup = NewDedupFilter(next, DedupOptions{})
+		return dedup
+	})
+	defer dedup.Close()
+	// Blob :3 is kept, as its original has no mark.
+	assert.Equal(t, `blob
+mark :1
+data 5
+test
+blob
+data 4
+abc
+blob
+mark :3
+data 4
+abc
+get-mark :1
+commit refs/heads/main
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+M 100644 :1 b.txt
+M 100644 :3 c.txt
+
+`, output)
+	count, size := dedup.Dropped()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(5), size)
+}
+
+const dedupRedefineInput = `blob
+mark :1
+data 2
+x
+blob
+mark :2
+data 2
+x
+blob
+mark :1
+data 2
+y
+blob
+mark :5
+data 2
+x
+blob
+mark :6
+data 2
+x
+commit refs/heads/main
+mark :7
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 y.txt
+M 100644 :2 x2.txt
+M 100644 :6 x6.txt
+
+`
+
+func TestDedupRedefine(t *testing.T) {
+	// Once mark :1 is redefined, the duplicate :2 is referred to
+	// by SHA-1, and the next "x" is the original.
+	output := runFilter(t, dedupRedefineInput, func(next CmdWriter) CmdWriter {
+		return NewDedupFilter(next, DedupOptions{})
+	})
+	assert.Equal(t, `blob
+mark :1
+data 2
+x
+blob
+mark :1
+data 2
+y
+blob
+mark :5
+data 2
+x
+commit refs/heads/main
+mark :7
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 y.txt
+M 100644 `+BlobSHA1("x\n")+` x2.txt
+M 100644 :5 x6.txt
+
+`, output)
+
+	// A get-mark of :2 can't be answered any more.
+	dedup := NewDedupFilter(NewBackend(&MyWriteCloser{bufio.NewWriter(new(bytes.Buffer))}, nil, nil), DedupOptions{})
+	defer dedup.Close()
+	frontend := NewFrontend(strings.NewReader(dedupRedefineInput), nil, nil)
+	for i := 0; i < 3; i++ {
+		cmd, err := frontend.ReadCmd()
+		assert.Nil(t, err)
+		assert.Nil(t, dedup.Do(cmd))
+	}
+	assert.NotNil(t, dedup.Do(CmdGetMark{Mark: 2}))
+
+	// git accepts the output.
+	d := initGitRepo(t)
+	backend, err := StartGitFastImport(context.Background(), d, FastImportOptions{})
+	assert.Nil(t, err)
+	dedup = NewDedupFilter(backend, DedupOptions{})
+	defer dedup.Close()
+	frontend = NewFrontend(strings.NewReader(dedupRedefineInput+dedupInput), nil, nil)
+	for {
+		cmd, err := frontend.ReadCmd()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		assert.Nil(t, dedup.Do(cmd))
+	}
+	assert.Nil(t, backend.Close())
+	for file, content := range map[string]string{"y.txt": "y\n", "x2.txt": "x\n", "x6.txt": "x\n", "b.txt": "test\n", "c.txt": "abc\n"} {
+		out, err := exec.Command("git", "-C", d, "show", "main:"+file).Output()
+		assert.Nil(t, err, file)
+		assert.Equal(t, content, string(out), file)
+	}
+}
+
+func TestHashIndexSpill(t *testing.T) {
+	idx := newHashIndex(t.TempDir(), 2, 8)
+	defer idx.Close()
+	val := func(i int) []byte {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(i))
+		return b[:]
+	}
+	for i := 1; i <= 50; i++ {
+		assert.Nil(t, idx.Put(blobSHA1Sum(strings.Repeat("x", i)), val(i)))
+	}
+	// Replace some, after they have been spilled.
+	for i := 1; i <= 50; i += 7 {
+		assert.Nil(t, idx.Put(blobSHA1Sum(strings.Repeat("x", i)), val(-i)))
+	}
+	assert.True(t, len(idx.runs) > 0)
+	assert.True(t, len(idx.mem) < 2)
+	for i := 1; i <= 50; i++ {
+		v, ok, err := idx.Get(blobSHA1Sum(strings.Repeat("x", i)))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		if i%7 == 1 {
+			assert.Equal(t, val(-i), v)
+		} else {
+			assert.Equal(t, val(i), v)
+		}
+	}
+	_, ok, err := idx.Get(blobSHA1Sum("y"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestDedupSpill(t *testing.T) {
+	// Many blobs, each a duplicate of one a little way back, and
+	// commits that refer to all of them.
+	var b strings.Builder
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&b, "blob\nmark :%d\ndata 4\n%03d\n", i, i%50)
+	}
+	for i := 1; i <= 200; i += 20 {
+		fmt.Fprintf(&b, "commit refs/heads/main\nmark :%d\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 0\n", 1000+i)
+		for j := i; j < i+20; j++ {
+			fmt.Fprintf(&b, "M 100644 :%d f%d.txt\n", j, j)
+		}
+		b.WriteString("\n")
+	}
+	input := b.String()
+
+	expected := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		return NewDedupFilter(next, DedupOptions{})
+	})
+	var dedup *DedupFilter
+	output := runFilter(t, input, func(next CmdWriter) CmdWriter {
+		dedup = NewDedupFilter(next, DedupOptions{TempDir: t.TempDir(), MaxMemEntries: 4})
+		return dedup
+	})
+	defer dedup.Close()
+	assert.Equal(t, expected, output)
+	assert.Contains(t, output, "M 100644 :1 f51.txt\n")
+	count, _ := dedup.Dropped()
+	assert.Equal(t, 150, count)
+	// Neither the index of content nor that of marks grew past
+	// the limit.
+	assert.True(t, len(dedup.index.mem) < 4)
+	assert.True(t, len(dedup.marks.mem) < 4)
+	assert.True(t, len(dedup.marks.runs) > 0)
+}
@@ -48,6 +48,12 @@ type Frontend struct {
 	catBlobWrite *textproto.CatBlobWriter
 	catBlobFlush *bufio.Writer
 
+	// Set by StartGitFastExport.
+	git *gitFastExport
+
+	// The commit that ReadObject is in the middle of.
+	commit *Commit
+
 	onErr func(error) error
 }
 
@@ -78,7 +84,30 @@ func NewFrontend(fastImport io.Reader, catBlob io.Writer, onErr func(error) erro
 	return ret
 }
 
-// ReadCmd reads a command from the Frontend.
+// SetPreserve sets whether the Frontend is in preservation mode, in
+// which the original text of each command is recorded in its
+// Preserved field, so that a Backend can write unmodified commands
+// exactly as they were read.  It must be called before the first
+// call to ReadCmd.
+func (f *Frontend) SetPreserve(preserve bool) {
+	f.fastImport.preserve = preserve
+}
+
+// Close waits for the process that the Frontend reads from to exit,
+// if it was started by StartGitFastExport, and returns its error, if
+// any.  Otherwise it does nothing.
+func (f *Frontend) Close() error {
+	if f.git == nil {
+		return nil
+	}
+	return f.git.wait()
+}
+
+// ReadCmd reads a command from the Frontend.  It returns io.EOF at
+// the end of the stream, or after a CmdDone; nothing after a "done"
+// command is read from the underlying io.Reader, beyond what a
+// bufio.Reader reads ahead.  To share the io.Reader with something
+// else, pass the Frontend a *bufio.Reader, which it will use as-is.
 func (f *Frontend) ReadCmd() (Cmd, error) {
 	cmd, err := f.fastImport.ReadCmd()
 	if err != nil {
@@ -4,6 +4,7 @@ package libfastimport
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -120,21 +121,11 @@ func TestParseRename(t *testing.T) {
 	runCmd(fmt.Sprintf("git mv %s %s", src, dst))
 	runCmd("git add .")
 	runCmd("git commit -m renamed")
-	export := "export.txt"
 	// fast-export with rename detection implemented
-	output, err := runCmd(fmt.Sprintf("git fast-export --all -M > %s", export))
-	if err != nil {
-		t.Errorf("ERROR: Failed to git export '%s': %v\n", export, err)
-	}
-	assert.Equal(t, "", output)
-
-	file, err := os.Open(export)
+	f, err := StartGitFastExport(context.Background(), d, FastExportOptions{DetectRenames: true})
 	if err != nil {
-		t.Errorf("ERROR: Failed to open file '%s': %v\n", export, err)
+		t.Fatalf("ERROR: Failed to start git fast-export: %v\n", err)
 	}
-
-	buf := bufio.NewReader(file)
-	f := NewFrontend(buf, nil, nil)
 	cmds := make([]Cmd, 0)
 	for {
 		cmd, err := f.ReadCmd()
@@ -146,6 +137,9 @@ func TestParseRename(t *testing.T) {
 		}
 		cmds = append(cmds, cmd)
 	}
+	if err := f.Close(); err != nil {
+		t.Errorf("ERROR: git fast-export failed: %v\n", err)
+	}
 	counts := map[string]int{}
 	for _, cmd := range cmds {
 		switch cmd.(type) {
@@ -367,3 +361,148 @@ func TestParseRenameQuotes(t *testing.T) {
 	assert.Equal(t, 1, counts["libfastimport.FileModify"])
 	assert.Equal(t, 1, counts["libfastimport.FileRename"])
 }
+
+func TestParseEOF(t *testing.T) {
+	const commit = "commit refs/heads/main\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\nx\n"
+	for _, tc := range []struct {
+		input string
+		cmds  int
+		err   error
+	}{
+		// The stream may end after any whole command, even without
+		// the optional blank line at the end of a commit.
+		{commit, 2, io.EOF},
+		{commit + "M 100644 :1 a\n", 3, io.EOF},
+		{"blob\nmark :1\ndata 0\n", 1, io.EOF},
+		// But not in the middle of one.
+		{"commit refs/heads/main\n", 0, io.ErrUnexpectedEOF},
+		{"commit refs/heads/main\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 5\nx\n", 0, io.ErrUnexpectedEOF},
+		{commit + "M 100644 inline a\n", 1, io.ErrUnexpectedEOF},
+		{commit + "M 100644 inline a\ndata <<EOF\nx\n", 1, io.ErrUnexpectedEOF},
+		{"blob\nmark :1\n", 0, io.ErrUnexpectedEOF},
+		{"tag v1\nfrom :1\n", 0, io.ErrUnexpectedEOF},
+	} {
+		f := NewFrontend(strings.NewReader(tc.input), nil, nil)
+		n := 0
+		var err error
+		for {
+			_, err = f.ReadCmd()
+			if err != nil {
+				break
+			}
+			n++
+		}
+		assert.Equal(t, tc.err, err, "%q", tc.input)
+		assert.Equal(t, tc.cmds, n, "%q", tc.input)
+	}
+}
+
+func TestParseLs(t *testing.T) {
+	const commit = "commit refs/heads/main\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\nx\n"
+	input := commit + "ls \"a b.txt\"\nls c.txt\nls :1 d e.txt\nls :1 \"f.txt\"\n"
+	f := NewFrontend(strings.NewReader(input), nil, nil)
+	var out strings.Builder
+	bw := bufio.NewWriter(&out)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	var ls []CmdLs
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		if c, ok := cmd.(CmdLs); ok {
+			ls = append(ls, c)
+		}
+		assert.NoError(t, backend.Do(cmd))
+	}
+	if assert.Len(t, ls, 4) {
+		assert.Equal(t, CmdLs{Path: "a b.txt"}, ls[0])
+		assert.Equal(t, CmdLs{Path: "c.txt"}, ls[1])
+		assert.Equal(t, CmdLs{DataRef: ":1", Path: "d e.txt"}, ls[2])
+		assert.Equal(t, CmdLs{DataRef: ":1", Path: "f.txt"}, ls[3])
+	}
+
+	// Without a dataref, git requires the path to be quoted.
+	bw.Flush()
+	assert.Contains(t, out.String(), "ls \"a b.txt\"\nls \"c.txt\"\nls :1 \"d e.txt\"\nls :1 f.txt\n")
+}
+
+// smallCommitsStream is a stream of many commits, each with a few
+// small files.
+func smallCommitsStream(commits int) string {
+	var b strings.Builder
+	for i := 1; i <= commits; i++ {
+		fmt.Fprintf(&b, "blob\nmark :%d\ndata 8\nfile %03d\n", 2*i-1, i%1000)
+		fmt.Fprintf(&b, "commit refs/heads/main\nmark :%d\n", 2*i)
+		fmt.Fprintf(&b, "author Robert Cowham <rcowham@perforce.com> %d +0000\n", 1644399073+i)
+		fmt.Fprintf(&b, "committer Robert Cowham <rcowham@perforce.com> %d +0000\n", 1644399073+i)
+		fmt.Fprintf(&b, "data <<EOF\ncommit %d\nEOF\n", i)
+		if i > 1 {
+			fmt.Fprintf(&b, "from :%d\n", 2*i-2)
+		}
+		for j := 0; j < 5; j++ {
+			fmt.Fprintf(&b, "M 100644 :%d dir/file%d.txt\n", 2*i-1, j)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// hugeBlobsStream is a stream of a few large blobs, in both the exact
+// byte count and delimited formats.
+func hugeBlobsStream(blobs int, size int) string {
+	line := strings.Repeat("x", 99) + "\n"
+	data := strings.Repeat(line, size/len(line))
+	var b strings.Builder
+	for i := 1; i <= blobs; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "blob\nmark :%d\ndata <<EOF\n%sEOF\n", i, data)
+		} else {
+			fmt.Fprintf(&b, "blob\nmark :%d\ndata %d\n%s", i, len(data), data)
+		}
+	}
+	return b.String()
+}
+
+func benchmarkParse(b *testing.B, stream string) {
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f := NewFrontend(strings.NewReader(stream), nil, nil)
+		for {
+			_, err := f.ReadCmd()
+			if err != nil {
+				if err != io.EOF {
+					b.Fatal(err)
+				}
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkParseSmallCommits(b *testing.B) {
+	benchmarkParse(b, smallCommitsStream(10000))
+}
+
+func BenchmarkParseHugeBlobs(b *testing.B) {
+	benchmarkParse(b, hugeBlobsStream(4, 16*1024*1024))
+}
+
+func TestParseSynthetic(t *testing.T) {
+	counts := make(map[string]int)
+	f := NewFrontend(strings.NewReader(smallCommitsStream(100)+hugeBlobsStream(2, 1024*1024)), nil, nil)
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		counts[cmdName(cmd)]++
+		if blob, ok := cmd.(CmdBlob); ok && blob.Mark <= 2 && counts["blob"] > 100 {
+			assert.Equal(t, 1024*1024/100*100, len(blob.Data))
+		}
+	}
+	assert.Equal(t, map[string]int{"blob": 102, "commit": 100, "commit-end": 100, "M": 500}, counts)
+}
@@ -0,0 +1,450 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FastImportOptions are the options for StartGitFastImport.
+type FastImportOptions struct {
+	Git string // the git binary to run; "git" if empty
+
+	ExportMarks         string // --export-marks=<file>
+	ImportMarks         string // --import-marks=<file>
+	ImportMarksIfExists bool   // use --import-marks-if-exists for ImportMarks
+	Force               bool   // --force
+	Quiet               bool   // --quiet
+	Stats               bool   // --stats
+
+	Args []string // any further arguments
+}
+
+// A FastImportError is returned when "git fast-import" exits
+// unsuccessfully.
+type FastImportError struct {
+	ExitCode    int    // -1 if it was killed by a signal
+	Stderr      string // everything it wrote to stderr
+	CrashReport string // the contents of its crash report, if it wrote one
+}
+
+func (e *FastImportError) Error() string {
+	for _, line := range strings.Split(e.Stderr, "\n") {
+		if strings.HasPrefix(line, "fatal: ") {
+			return "git fast-import: " + strings.TrimPrefix(line, "fatal: ")
+		}
+	}
+	return fmt.Sprintf("git fast-import: exit status %d", e.ExitCode)
+}
+
+// FastImportStats are the statistics that "git fast-import" prints
+// when it is finished, unless it is run with --quiet.
+type FastImportStats struct {
+	// Counts maps the name of each count (such as "Total
+	// objects", "blobs", "commits" or "Total branches") to its
+	// value.
+	Counts map[string]int
+	// Text is the statistics as git printed them.
+	Text string
+}
+
+var reFastImportStat = regexp.MustCompile(`^\s*([A-Za-z' ]*[A-Za-z'])\s*:\s*([0-9]+)\b`)
+
+func parseFastImportStats(stderr string) *FastImportStats {
+	const begin = "fast-import statistics:\n"
+	i := strings.Index(stderr, begin)
+	if i < 0 {
+		return nil
+	}
+	stats := &FastImportStats{
+		Counts: make(map[string]int),
+		Text:   stderr[i:],
+	}
+	for _, line := range strings.Split(stats.Text, "\n") {
+		if strings.HasPrefix(line, "pack_report:") {
+			continue
+		}
+		if m := reFastImportStat.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			stats.Counts[m[1]] = n
+		}
+	}
+	return stats
+}
+
+type gitFastImport struct {
+	ctx     context.Context
+	cmd     *exec.Cmd
+	gitDir  string
+	stdin   io.WriteCloser
+	catBlob *os.File
+	stderr  bytes.Buffer
+
+	once  sync.Once
+	err   error
+	stats *FastImportStats
+}
+
+// wait closes stdin and waits for the process to exit.  It may be
+// called more than once.
+func (g *gitFastImport) wait() error {
+	g.once.Do(func() {
+		g.stdin.Close()
+		err := g.cmd.Wait()
+		g.catBlob.Close()
+		if err == nil {
+			g.stats = parseFastImportStats(g.stderr.String())
+			return
+		}
+		if g.ctx.Err() != nil {
+			g.err = g.ctx.Err()
+			return
+		}
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			g.err = err
+			return
+		}
+		ferr := &FastImportError{
+			ExitCode: exitErr.ExitCode(),
+			Stderr:   g.stderr.String(),
+		}
+		crash := filepath.Join(g.gitDir, "fast_import_crash_"+strconv.Itoa(g.cmd.Process.Pid))
+		if report, err := os.ReadFile(crash); err == nil {
+			ferr.CrashReport = string(report)
+		}
+		g.err = ferr
+	})
+	return g.err
+}
+
+// Write writes to the process's stdin.  If that fails because the
+// process has died, the reason that it died is returned instead.
+func (g *gitFastImport) Write(p []byte) (int, error) {
+	n, err := g.stdin.Write(p)
+	if err != nil {
+		if werr := g.wait(); werr != nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (g *gitFastImport) Close() error {
+	return g.wait()
+}
+
+// gitCatBlob reads the responses to "cat-blob", "get-mark" and "ls"
+// commands; if that fails because the process has died, the reason
+// that it died is returned instead.
+type gitCatBlob struct {
+	g *gitFastImport
+}
+
+func (r gitCatBlob) Read(p []byte) (int, error) {
+	n, err := r.g.catBlob.Read(p)
+	if err != nil {
+		if werr := r.g.wait(); werr != nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// gitDir returns the absolute path of the git directory of the
+// repository at repoDir.
+func gitDir(ctx context.Context, git, repoDir string) (string, error) {
+	cmd := exec.CommandContext(ctx, git, "rev-parse", "--absolute-git-dir")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", errors.Errorf("%s is not a git repository: %s", repoDir, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// StartGitFastImport starts "git fast-import" in the repository at
+// repoDir, and returns a Backend that writes to it.  GetMark, CatBlob
+// and Ls may be used; their responses are read from a --cat-blob-fd
+// pipe.
+//
+// The process is stopped when the Backend is sent a CmdDone, or when
+// the Backend is closed; if it exits unsuccessfully, the Backend's
+// error is a *FastImportError.  Once it has exited successfully, its
+// statistics are available from the Backend's GitStats method.
+//
+// If ctx is cancelled, the process is killed.
+func StartGitFastImport(ctx context.Context, repoDir string, opts FastImportOptions) (*Backend, error) {
+	git := opts.Git
+	if git == "" {
+		git = "git"
+	}
+	dir, err := gitDir(ctx, git, repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"fast-import", "--cat-blob-fd=3"}
+	if opts.ExportMarks != "" {
+		args = append(args, "--export-marks="+opts.ExportMarks)
+	}
+	if opts.ImportMarks != "" {
+		if opts.ImportMarksIfExists {
+			args = append(args, "--import-marks-if-exists="+opts.ImportMarks)
+		} else {
+			args = append(args, "--import-marks="+opts.ImportMarks)
+		}
+	}
+	if opts.Force {
+		args = append(args, "--force")
+	}
+	if opts.Quiet {
+		args = append(args, "--quiet")
+	}
+	if opts.Stats {
+		args = append(args, "--stats")
+	}
+	args = append(args, opts.Args...)
+
+	g := &gitFastImport{
+		ctx:    ctx,
+		cmd:    exec.CommandContext(ctx, git, args...),
+		gitDir: dir,
+	}
+	g.cmd.Dir = repoDir
+	g.cmd.Stderr = &g.stderr
+
+	catBlobRead, catBlobWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	g.catBlob = catBlobRead
+	g.cmd.ExtraFiles = []*os.File{catBlobWrite}
+
+	g.stdin, err = g.cmd.StdinPipe()
+	if err != nil {
+		catBlobRead.Close()
+		catBlobWrite.Close()
+		return nil, err
+	}
+	if err := g.cmd.Start(); err != nil {
+		catBlobRead.Close()
+		catBlobWrite.Close()
+		return nil, err
+	}
+	// The process has its own copy; with ours closed, reads see
+	// EOF once it exits.
+	catBlobWrite.Close()
+
+	b := NewBackend(g, gitCatBlob{g}, nil)
+	b.git = g
+	return b, nil
+}
+
+// GitStats returns the statistics of the "git fast-import" process
+// that the Backend was started with, by StartGitFastImport.  It
+// returns nil if the process has not yet exited successfully, or if
+// it was run with --quiet.
+func (b *Backend) GitStats() *FastImportStats {
+	if b.git == nil {
+		return nil
+	}
+	return b.git.stats
+}
+
+// FastExportOptions are the options for StartGitFastExport.
+type FastExportOptions struct {
+	Git string // the git binary to run; "git" if empty
+
+	Refs []string // the revisions to export; "--all" if empty
+
+	DetectRenames            bool   // -M
+	DetectCopies             bool   // -C
+	ShowOriginalIDs          bool   // --show-original-ids
+	SignedTags               string // --signed-tags=<mode>, if not empty
+	Reencode                 string // --reencode=<mode>, if not empty
+	ImportMarks              string // --import-marks=<file>
+	ExportMarks              string // --export-marks=<file>
+	ReferenceExcludedParents bool   // --reference-excluded-parents
+	FakeMissingTagger        bool   // --fake-missing-tagger
+
+	Args []string // any further arguments, before Refs
+}
+
+// A FastExportError is returned when "git fast-export" exits
+// unsuccessfully.
+type FastExportError struct {
+	ExitCode int    // -1 if it was killed by a signal
+	Stderr   string // everything it wrote to stderr
+}
+
+func (e *FastExportError) Error() string {
+	for _, line := range strings.Split(e.Stderr, "\n") {
+		if strings.HasPrefix(line, "fatal: ") {
+			return "git fast-export: " + strings.TrimPrefix(line, "fatal: ")
+		}
+	}
+	return fmt.Sprintf("git fast-export: exit status %d", e.ExitCode)
+}
+
+type gitFastExport struct {
+	ctx    context.Context
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+
+	mu  sync.Mutex
+	eof bool
+
+	once sync.Once
+	err  error
+}
+
+func (g *gitFastExport) Read(p []byte) (int, error) {
+	n, err := g.stdout.Read(p)
+	if err == io.EOF {
+		g.mu.Lock()
+		g.eof = true
+		g.mu.Unlock()
+	}
+	return n, err
+}
+
+// wait waits for the process to exit.  If not all of its output has
+// been read, the rest is discarded by closing the pipe that it is
+// read from; the process is killed by SIGPIPE the next time that it
+// writes, and that isn't an error.  If it exits on its own before
+// then, unsuccessfully (say, because a revision is invalid), that
+// is.
+func (g *gitFastExport) wait() error {
+	g.once.Do(func() {
+		g.mu.Lock()
+		eof := g.eof
+		g.mu.Unlock()
+		if !eof {
+			g.stdout.Close()
+		}
+		err := g.cmd.Wait()
+		if err == nil {
+			return
+		}
+		if g.ctx.Err() != nil {
+			g.err = g.ctx.Err()
+			return
+		}
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			g.err = err
+			return
+		}
+		if !eof && !exitErr.Exited() {
+			// Stopped early, by the caller.
+			return
+		}
+		g.err = &FastExportError{
+			ExitCode: exitErr.ExitCode(),
+			Stderr:   g.stderr.String(),
+		}
+	})
+	return g.err
+}
+
+// StartGitFastExport starts "git fast-export" in the repository at
+// repoDir, and returns a Frontend that reads from it.
+//
+// The Frontend's Close method waits for the process to exit; if it
+// exits unsuccessfully, Close returns a *FastExportError.  If Close
+// is called before the whole stream has been read, the rest of it is
+// discarded, and the process is stopped the next time that it writes
+// to it.
+//
+// If ctx is cancelled, the process is killed.
+func StartGitFastExport(ctx context.Context, repoDir string, opts FastExportOptions) (*Frontend, error) {
+	git := opts.Git
+	if git == "" {
+		git = "git"
+	}
+
+	args := []string{"fast-export"}
+	if opts.DetectRenames {
+		args = append(args, "-M")
+	}
+	if opts.DetectCopies {
+		args = append(args, "-C")
+	}
+	if opts.ShowOriginalIDs {
+		args = append(args, "--show-original-ids")
+	}
+	if opts.SignedTags != "" {
+		args = append(args, "--signed-tags="+opts.SignedTags)
+	}
+	if opts.Reencode != "" {
+		args = append(args, "--reencode="+opts.Reencode)
+	}
+	if opts.ImportMarks != "" {
+		args = append(args, "--import-marks="+opts.ImportMarks)
+	}
+	if opts.ExportMarks != "" {
+		args = append(args, "--export-marks="+opts.ExportMarks)
+	}
+	if opts.ReferenceExcludedParents {
+		args = append(args, "--reference-excluded-parents")
+	}
+	if opts.FakeMissingTagger {
+		args = append(args, "--fake-missing-tagger")
+	}
+	args = append(args, opts.Args...)
+	if len(opts.Refs) == 0 {
+		args = append(args, "--all")
+	} else {
+		args = append(args, opts.Refs...)
+	}
+
+	g := &gitFastExport{
+		ctx: ctx,
+		cmd: exec.CommandContext(ctx, git, args...),
+	}
+	g.cmd.Dir = repoDir
+	g.cmd.Stderr = &g.stderr
+
+	var err error
+	g.stdout, err = g.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	f := NewFrontend(g, nil, nil)
+	f.git = g
+	return f, nil
+}
@@ -0,0 +1,164 @@
+// Tests for running git as a subprocess
+
+package libfastimport
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initGitRepo(t *testing.T) string {
+	t.Helper()
+	d := t.TempDir()
+	out, err := exec.Command("git", "init", "-q", "-b", "main", d).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	return d
+}
+
+func TestStartGitFastImport(t *testing.T) {
+	d := initGitRepo(t)
+	marks := filepath.Join(d, "marks")
+	ctx := context.Background()
+	committer := Ident{Name: "Robert Cowham", Email: "rcowham@perforce.com", Time: time.Unix(1644399073, 0).UTC()}
+
+	b, err := StartGitFastImport(ctx, d, FastImportOptions{ExportMarks: marks, Stats: true})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Do(CmdBlob{Mark: 1, Data: "contents\n"}))
+	assert.Nil(t, b.Do(CmdCommit{Ref: "refs/heads/main", Mark: 2, Committer: committer, Msg: "initial\n"}))
+	assert.Nil(t, b.Do(FileModify{Mode: 0100644, DataRef: ":1", Path: "a.txt"}))
+	assert.Nil(t, b.Do(CmdCommitEnd{}))
+	sha1, data, err := b.CatBlob(CmdCatBlob{DataRef: ":1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "contents\n", data)
+	commit, err := b.GetMark(CmdGetMark{Mark: 2})
+	assert.Nil(t, err)
+	assert.Nil(t, b.GitStats())
+	assert.Nil(t, b.Do(CmdDone{}))
+	assert.Nil(t, b.Close())
+
+	stats := b.GitStats()
+	if assert.NotNil(t, stats) {
+		assert.Equal(t, 1, stats.Counts["blobs"])
+		assert.Equal(t, 1, stats.Counts["commits"])
+		assert.Equal(t, 1, stats.Counts["Total branches"])
+	}
+	out, err := exec.Command("git", "-C", d, "rev-parse", "main", "main:a.txt").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, commit+"\n"+sha1+"\n", string(out))
+	exported, err := os.ReadFile(marks)
+	assert.Nil(t, err)
+	assert.Equal(t, ":1 "+sha1+"\n:2 "+commit+"\n", string(exported))
+
+	// A stream that git rejects.
+	b, err = StartGitFastImport(ctx, d, FastImportOptions{Quiet: true})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Do(CmdCommit{Ref: "refs/heads/main", Committer: committer, Msg: "bad\n", From: ":99"}))
+	assert.Nil(t, b.Do(CmdCommitEnd{}))
+	err = b.Close()
+	if ferr, ok := err.(*FastImportError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 128, ferr.ExitCode)
+		assert.Equal(t, "git fast-import: mark :99 not declared", ferr.Error())
+		assert.True(t, strings.Contains(ferr.CrashReport, "fast-import crash report"))
+	}
+	assert.Nil(t, b.GitStats())
+
+	_, err = StartGitFastImport(ctx, t.TempDir(), FastImportOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestStartGitFastExport(t *testing.T) {
+	d := initGitRepo(t)
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = d
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	ctx := context.Background()
+	writeFile := func(name, contents string) {
+		assert.Nil(t, os.WriteFile(filepath.Join(d, name), []byte(contents), 0644))
+	}
+	writeFile("a.txt", "contents\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	first := git("rev-parse", "HEAD")
+	git("mv", "a.txt", "b.txt")
+	git("commit", "-q", "-m", "renamed")
+	git("tag", "-a", "-m", "tag", "v1")
+	marks := filepath.Join(t.TempDir(), "marks")
+
+	f, err := StartGitFastExport(ctx, d, FastExportOptions{
+		Refs:                     []string{first + "..main", "v1"},
+		DetectRenames:            true,
+		ShowOriginalIDs:          true,
+		ReferenceExcludedParents: true,
+		ExportMarks:              marks,
+	})
+	assert.Nil(t, err)
+	var commits []CmdCommit
+	var renames []FileRename
+	var tags int
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		switch cmd := cmd.(type) {
+		case CmdCommit:
+			commits = append(commits, cmd)
+		case FileRename:
+			renames = append(renames, cmd)
+		case CmdTag:
+			tags++
+		}
+	}
+	assert.Nil(t, f.Close())
+	if assert.Equal(t, 1, len(commits)) {
+		assert.Equal(t, first, commits[0].From)
+		assert.Equal(t, git("rev-parse", "main"), commits[0].OriginalOID)
+	}
+	assert.Equal(t, []FileRename{{Src: "a.txt", Dst: "b.txt"}}, renames)
+	assert.Equal(t, 1, tags)
+	_, err = os.Stat(marks)
+	assert.Nil(t, err)
+
+	// Closing early isn't an error.
+	f, err = StartGitFastExport(ctx, d, FastExportOptions{})
+	assert.Nil(t, err)
+	_, err = f.ReadCmd()
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	f, err = StartGitFastExport(ctx, d, FastExportOptions{Refs: []string{"nonexistent"}})
+	assert.Nil(t, err)
+	_, err = f.ReadCmd()
+	assert.Equal(t, io.EOF, err)
+	err = f.Close()
+	if ferr, ok := err.(*FastExportError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 128, ferr.ExitCode)
+		assert.True(t, strings.HasPrefix(ferr.Error(), "git fast-export: "), ferr.Error())
+	}
+
+	// Closing without reading anything doesn't hide the error.
+	f, err = StartGitFastExport(ctx, d, FastExportOptions{Refs: []string{"nonexistent"}})
+	assert.Nil(t, err)
+	err = f.Close()
+	if ferr, ok := err.(*FastExportError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 128, ferr.ExitCode)
+		assert.Contains(t, ferr.Stderr, "nonexistent")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A PathPattern is a compiled gitattributes(5)-style path pattern.
+//
+// A pattern without a slash matches the final component of a path at
+// any depth ("*.psd"); a pattern with a slash is relative to the root
+// of the tree ("docs/*.txt", "/build").  "*" and "?" do not match a
+// slash; "**" matches across slashes when it is a whole path
+// component ("**/obj", "assets/**", "a/**/b").
+type PathPattern struct {
+	str string
+	re  *regexp.Regexp
+}
+
+// CompilePathPattern parses a pattern.
+func CompilePathPattern(pattern string) (*PathPattern, error) {
+	str := pattern
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return nil, errors.Errorf("empty path pattern: %q", str)
+	}
+
+	var re strings.Builder
+	if anchored {
+		re.WriteString("^")
+	} else {
+		re.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errors.Errorf("unterminated [ in path pattern: %q", str)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += 1 + end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "path pattern %q", str)
+	}
+	return &PathPattern{str: str, re: compiled}, nil
+}
+
+// Match reports whether the path matches the pattern.
+func (p *PathPattern) Match(path Path) bool {
+	return p.re.MatchString(string(path))
+}
+
+// String returns the pattern as it was originally written.
+func (p *PathPattern) String() string {
+	return p.str
+}
+
+// compilePathPatterns compiles each of the patterns.
+func compilePathPatterns(patterns []string) ([]*PathPattern, error) {
+	ret := make([]*PathPattern, 0, len(patterns))
+	for _, str := range patterns {
+		pat, err := CompilePathPattern(str)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, pat)
+	}
+	return ret, nil
+}
+
+// matchAny reports whether the path matches any of the patterns.
+func matchAny(patterns []*PathPattern, path Path) bool {
+	for _, pat := range patterns {
+		if pat.Match(path) {
+			return true
+		}
+	}
+	return false
+}
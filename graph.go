@@ -0,0 +1,390 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"container/heap"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A GraphNode is a commit in a Graph.
+type GraphNode struct {
+	Mark int    // the commit's mark, if it has one
+	OID  string // the commit's original-oid, or for an external commit, how it was referred to
+	Ref  string // the branch that the commit was made on; empty for an external commit
+	Pos  int    // the position (see Graph.Pos) of the command that created the node
+
+	// An External commit is one that was not defined in the
+	// stream, but was referred to by SHA-1, by a mark from an
+	// earlier import, or by the name of a branch that already
+	// existed.  Nothing is known of its parents.
+	External bool
+
+	Parents  []*GraphNode // the first parent first
+	Children []*GraphNode
+
+	index      int // in Graph.nodes
+	generation int // 1 + the greatest generation of the parents
+}
+
+type graphTipChange struct {
+	pos  int
+	ref  string
+	node *GraphNode // nil if the ref was reset to nothing
+}
+
+// A Graph is the directed acyclic graph of the commits in a stream,
+// built up as the commands of the stream are passed to it.
+//
+// Parents come from CmdCommit.From and CmdCommit.Merge, or if From is
+// omitted, from the tip of the commit's branch; branch tips are moved
+// by commits and by CmdReset.  CmdAlias and the marks of CmdTag are
+// followed.  A branch that the stream has not yet mentioned is taken
+// not to exist, so a commit that omits From on such a branch has no
+// parents.
+type Graph struct {
+	pos   int
+	nodes []*GraphNode
+
+	byRef map[string]*GraphNode // marks, OIDs, and external names
+	tips  map[string]*GraphNode
+	log   []graphTipChange
+}
+
+// NewGraph creates a new empty Graph.
+func NewGraph() *Graph {
+	return &Graph{
+		byRef: make(map[string]*GraphNode),
+		tips:  make(map[string]*GraphNode),
+	}
+}
+
+// BuildGraph reads every command from the Frontend, and returns the
+// Graph of them.
+func BuildGraph(f *Frontend) (*Graph, error) {
+	g := NewGraph()
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if err := g.Do(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Pos returns the number of commands that have been passed to the
+// Graph so far.
+func (g *Graph) Pos() int {
+	return g.pos
+}
+
+// lookup returns the node that a commit-ish currently refers to, or
+// nil.
+func (g *Graph) lookup(commitish string) *GraphNode {
+	commitish = strings.TrimSuffix(commitish, "^0")
+	if node, ok := g.tips[commitish]; ok {
+		return node
+	}
+	return g.byRef[commitish]
+}
+
+// Commit returns the commit that a commit-ish (a mark reference, a
+// SHA-1, or a branch name) currently refers to, or nil if it is
+// unknown.
+func (g *Graph) Commit(commitish string) *GraphNode {
+	return g.lookup(commitish)
+}
+
+// resolve is like lookup, but creates an external node for unknown
+// commit-ishes.
+func (g *Graph) resolve(commitish string) *GraphNode {
+	if node := g.lookup(commitish); node != nil {
+		return node
+	}
+	commitish = strings.TrimSuffix(commitish, "^0")
+	node := g.add(&GraphNode{
+		Mark:     parseMarkRef(commitish),
+		OID:      commitish,
+		External: true,
+	})
+	g.byRef[commitish] = node
+	return node
+}
+
+func (g *Graph) add(node *GraphNode) *GraphNode {
+	node.Pos = g.pos
+	node.index = len(g.nodes)
+	node.generation = 1
+	for _, parent := range node.Parents {
+		if parent.generation >= node.generation {
+			node.generation = parent.generation + 1
+		}
+	}
+	g.nodes = append(g.nodes, node)
+	return node
+}
+
+func (g *Graph) setTip(ref string, node *GraphNode) {
+	if node == nil {
+		delete(g.tips, ref)
+	} else {
+		g.tips[ref] = node
+	}
+	g.log = append(g.log, graphTipChange{pos: g.pos, ref: ref, node: node})
+}
+
+// Do adds the command to the Graph.
+func (g *Graph) Do(cmd Cmd) error {
+	switch c := cmd.(type) {
+	case CmdCommit:
+		node := &GraphNode{Mark: c.Mark, OID: c.OriginalOID, Ref: c.Ref}
+		if c.From != "" {
+			node.Parents = append(node.Parents, g.resolve(c.From))
+		} else if tip := g.tips[c.Ref]; tip != nil {
+			node.Parents = append(node.Parents, tip)
+		}
+		for _, merge := range c.Merge {
+			node.Parents = append(node.Parents, g.resolve(merge))
+		}
+		g.add(node)
+		for _, parent := range node.Parents {
+			parent.Children = append(parent.Children, node)
+		}
+		if c.Mark > 0 {
+			g.byRef[markRef(c.Mark)] = node
+		}
+		if c.OriginalOID != "" {
+			g.byRef[c.OriginalOID] = node
+		}
+		g.setTip(c.Ref, node)
+	case CmdReset:
+		if c.CommitIsh == "" {
+			g.setTip(c.RefName, nil)
+		} else {
+			g.setTip(c.RefName, g.resolve(c.CommitIsh))
+		}
+	case CmdTag:
+		node := g.resolve(c.CommitIsh)
+		if c.Mark > 0 {
+			g.byRef[markRef(c.Mark)] = node
+		}
+		g.setTip("refs/tags/"+c.RefName, node)
+	case CmdAlias:
+		if node := g.lookup(c.CommitIsh); node != nil {
+			g.byRef[markRef(c.Mark)] = node
+		}
+	}
+	g.pos++
+	return nil
+}
+
+// Commits returns every commit in the Graph, including external ones,
+// in topological order: every commit comes after all of its parents.
+// Commits from the stream are in stream order.
+func (g *Graph) Commits() []*GraphNode {
+	return append([]*GraphNode(nil), g.nodes...)
+}
+
+// Tips returns the commit that each ref currently points at.
+func (g *Graph) Tips() map[string]*GraphNode {
+	ret := make(map[string]*GraphNode, len(g.tips))
+	for ref, node := range g.tips {
+		ret[ref] = node
+	}
+	return ret
+}
+
+// TipsAt returns the commit that each ref pointed at when the Graph
+// was at the given position; that is, just before the command at
+// that position.
+func (g *Graph) TipsAt(pos int) map[string]*GraphNode {
+	ret := make(map[string]*GraphNode)
+	for _, change := range g.log {
+		if change.pos >= pos {
+			break
+		}
+		if change.node == nil {
+			delete(ret, change.ref)
+		} else {
+			ret[change.ref] = change.node
+		}
+	}
+	return ret
+}
+
+// walk returns the set of nodes reachable from start by following
+// next, not including start itself.
+func walk(start *GraphNode, next func(*GraphNode) []*GraphNode) map[*GraphNode]bool {
+	seen := make(map[*GraphNode]bool)
+	queue := []*GraphNode{start}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, n := range next(cur) {
+			if !seen[n] {
+				seen[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return seen
+}
+
+func sortedNodes(set map[*GraphNode]bool) []*GraphNode {
+	ret := make([]*GraphNode, 0, len(set))
+	for node := range set {
+		ret = append(ret, node)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].index < ret[j].index })
+	return ret
+}
+
+func parentsOf(n *GraphNode) []*GraphNode  { return n.Parents }
+func childrenOf(n *GraphNode) []*GraphNode { return n.Children }
+
+// Ancestors returns all of the ancestors of the commit (not including
+// the commit itself), in topological order.
+func (g *Graph) Ancestors(node *GraphNode) []*GraphNode {
+	return sortedNodes(walk(node, parentsOf))
+}
+
+// Descendants returns all of the descendants of the commit (not
+// including the commit itself), in topological order.
+func (g *Graph) Descendants(node *GraphNode) []*GraphNode {
+	return sortedNodes(walk(node, childrenOf))
+}
+
+// IsAncestor returns whether a is a proper ancestor of b.
+func (g *Graph) IsAncestor(a, b *GraphNode) bool {
+	if a == nil || b == nil || a.generation >= b.generation {
+		// Ancestors always have a lower generation.
+		return false
+	}
+	// Walk back from b, but not past a's generation: nothing
+	// there can be a descendant of a.
+	seen := map[*GraphNode]bool{b: true}
+	stack := []*GraphNode{b}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range cur.Parents {
+			if p == a {
+				return true
+			}
+			if !seen[p] && p.generation > a.generation {
+				seen[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+	return false
+}
+
+// generationQueue is a max-heap of nodes, by generation, and then by
+// index.
+type generationQueue []*GraphNode
+
+func (q generationQueue) Len() int { return len(q) }
+func (q generationQueue) Less(i, j int) bool {
+	if q[i].generation != q[j].generation {
+		return q[i].generation > q[j].generation
+	}
+	return q[i].index > q[j].index
+}
+func (q generationQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *generationQueue) Push(x interface{}) { *q = append(*q, x.(*GraphNode)) }
+func (q *generationQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// MergeBase returns the best common ancestors of a and b, as "git
+// merge-base --all" would: the commits that are ancestors of (or
+// are) both a and b, and that aren't an ancestor of another such
+// commit.  Usually there is just one.
+//
+// Like Git, it walks back from a and b in generation order, and
+// stops once everything left to walk is an ancestor of a common
+// ancestor that has already been found, so it doesn't need to visit
+// all of their history.
+func (g *Graph) MergeBase(a, b *GraphNode) []*GraphNode {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a == b {
+		return []*GraphNode{a}
+	}
+	const (
+		fromA = 1 << iota
+		fromB
+		stale // an ancestor of a common ancestor
+	)
+	flags := map[*GraphNode]int{a: fromA, b: fromB}
+	queue := &generationQueue{a, b}
+	heap.Init(queue)
+	var common []*GraphNode
+	for queue.Len() > 0 {
+		done := true
+		for _, node := range *queue {
+			if flags[node]&stale == 0 {
+				done = false
+				break
+			}
+		}
+		if done {
+			break
+		}
+		node := heap.Pop(queue).(*GraphNode)
+		f := flags[node]
+		if f&(fromA|fromB|stale) == fromA|fromB {
+			common = append(common, node)
+			f |= stale
+		}
+		for _, p := range node.Parents {
+			if flags[p]&f == f {
+				continue
+			}
+			flags[p] |= f
+			heap.Push(queue, p)
+		}
+	}
+
+	best := make(map[*GraphNode]bool, len(common))
+	for _, node := range common {
+		if flags[node]&stale == 0 {
+			best[node] = true
+		}
+	}
+	for node := range best {
+		for other := range best {
+			if g.IsAncestor(node, other) {
+				delete(best, node)
+				break
+			}
+		}
+	}
+	return sortedNodes(best)
+}
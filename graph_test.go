@@ -0,0 +1,105 @@
+// Tests for commit graph
+
+package libfastimport
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph(t *testing.T) {
+	commit := func(ref string, mark int, extra string) string {
+		return fmt.Sprintf("commit %s\nmark :%d\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n%d\n%s\n",
+			ref, mark, mark%10, extra)
+	}
+	const oid = "1234567890123456789012345678901234567890"
+	input := "reset refs/heads/main\nfrom " + oid + "\n" +
+		commit("refs/heads/main", 1, "") +
+		commit("refs/heads/main", 2, "") +
+		commit("refs/heads/side", 3, "from :1\n") +
+		"alias\nmark :10\nto :3\n\n" +
+		commit("refs/heads/main", 4, "from :2\nmerge :10\n") +
+		commit("refs/heads/side", 5, "") +
+		commit("refs/heads/other", 6, "from :99\n") +
+		"reset refs/heads/side\n"
+
+	g, err := BuildGraph(NewFrontend(strings.NewReader(input), nil, nil))
+	assert.Nil(t, err)
+
+	ext := g.Commit(oid)
+	c1, c2, c3, c4, c5, c6 := g.Commit(":1"), g.Commit(":2"), g.Commit(":3"), g.Commit(":4"), g.Commit(":5"), g.Commit(":6")
+	assert.True(t, ext.External)
+	assert.Equal(t, []*GraphNode{ext}, c1.Parents)
+	assert.Equal(t, []*GraphNode{c1}, c2.Parents)
+	assert.Equal(t, []*GraphNode{c2, c3}, c4.Parents)
+	assert.Equal(t, []*GraphNode{c3}, c5.Parents)
+	assert.Equal(t, c3, g.Commit(":10"))
+	assert.True(t, c6.Parents[0].External)
+	assert.Equal(t, 99, c6.Parents[0].Mark)
+
+	assert.Equal(t, []*GraphNode{ext, c1, c2, c3}, g.Ancestors(c4))
+	assert.Equal(t, []*GraphNode{c2, c3, c4, c5}, g.Descendants(c1))
+	assert.True(t, g.IsAncestor(c1, c5))
+	assert.False(t, g.IsAncestor(c2, c5))
+	assert.False(t, g.IsAncestor(c5, c1))
+	assert.Equal(t, []*GraphNode{c1}, g.MergeBase(c2, c5))
+	assert.Equal(t, []*GraphNode{c3}, g.MergeBase(c4, c5))
+	assert.Equal(t, []*GraphNode{c2}, g.MergeBase(c2, c4))
+	assert.Equal(t, 0, len(g.MergeBase(c4, c6)))
+
+	commits := g.Commits()
+	for i, node := range commits {
+		for _, parent := range node.Parents {
+			assert.Less(t, parent.index, i)
+		}
+	}
+
+	assert.Equal(t, map[string]*GraphNode{
+		"refs/heads/main":  c4,
+		"refs/heads/other": c6,
+	}, g.Tips())
+	assert.Equal(t, map[string]*GraphNode{
+		"refs/heads/main": c2,
+	}, g.TipsAt(c3.Pos))
+	assert.Equal(t, map[string]*GraphNode{
+		"refs/heads/main":  c4,
+		"refs/heads/side":  c5,
+		"refs/heads/other": c6,
+	}, g.TipsAt(g.Pos()-1))
+}
+
+func TestGraphMergeBase(t *testing.T) {
+	commit := func(ref string, mark int, extra string) string {
+		return fmt.Sprintf("commit %s\nmark :%d\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 2\n%d\n%s\n",
+			ref, mark, mark%10, extra)
+	}
+	// A criss-cross merge, with a long history behind it.
+	var input strings.Builder
+	const n = 10000
+	for i := 1; i <= n; i++ {
+		input.WriteString(commit("refs/heads/main", i, ""))
+	}
+	input.WriteString(commit("refs/heads/a", n+1, fmt.Sprintf("from :%d\n", n)) +
+		commit("refs/heads/b", n+2, fmt.Sprintf("from :%d\n", n)) +
+		commit("refs/heads/a", n+3, fmt.Sprintf("merge :%d\n", n+2)) +
+		commit("refs/heads/b", n+4, fmt.Sprintf("merge :%d\n", n+1)))
+
+	g, err := BuildGraph(NewFrontend(strings.NewReader(input.String()), nil, nil))
+	assert.Nil(t, err)
+
+	a1, b1 := g.Commit(fmt.Sprintf(":%d", n+1)), g.Commit(fmt.Sprintf(":%d", n+2))
+	a2, b2 := g.Commit(fmt.Sprintf(":%d", n+3)), g.Commit(fmt.Sprintf(":%d", n+4))
+	assert.Equal(t, []*GraphNode{a1, b1}, g.MergeBase(a2, b2))
+	assert.Equal(t, []*GraphNode{g.Commit(fmt.Sprintf(":%d", n))}, g.MergeBase(a1, b1))
+	assert.True(t, g.IsAncestor(g.Commit(":1"), b2))
+	assert.False(t, g.IsAncestor(a2, b2))
+	// This walks all of the history, unless the walk is cut short.
+	for i := 1; i < n; i++ {
+		if !g.IsAncestor(g.Commit(fmt.Sprintf(":%d", i)), g.Commit(fmt.Sprintf(":%d", i+1))) {
+			t.Errorf(":%d is not an ancestor of :%d", i, i+1)
+		}
+	}
+}
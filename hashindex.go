@@ -0,0 +1,243 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// hashIndex is a map from 20-byte keys (such as hashes) to values of
+// a fixed length, that keeps at most a fixed number of entries in
+// memory; the rest are spilled to sorted "run" files on disk.
+type hashIndex struct {
+	dir    string
+	maxMem int
+	valLen int
+
+	mem  map[[20]byte][]byte
+	runs []*os.File // oldest first
+}
+
+const hashIndexMaxRuns = 8
+
+func newHashIndex(dir string, maxMem, valLen int) *hashIndex {
+	if maxMem < 1 {
+		maxMem = 1 << 20
+	}
+	return &hashIndex{
+		dir:    dir,
+		maxMem: maxMem,
+		valLen: valLen,
+		mem:    make(map[[20]byte][]byte),
+	}
+}
+
+func (idx *hashIndex) recLen() int {
+	return 20 + idx.valLen
+}
+
+func (idx *hashIndex) Get(key [20]byte) ([]byte, bool, error) {
+	if val, ok := idx.mem[key]; ok {
+		return val, true, nil
+	}
+	// A later run overrides an earlier one.
+	for i := len(idx.runs) - 1; i >= 0; i-- {
+		val, ok, err := idx.search(idx.runs[i], key)
+		if err != nil || ok {
+			return val, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Put sets the value for key, replacing any value that it had.
+func (idx *hashIndex) Put(key [20]byte, val []byte) error {
+	if len(val) != idx.valLen {
+		return errors.Errorf("hashindex: value is %d bytes, not %d", len(val), idx.valLen)
+	}
+	idx.mem[key] = append([]byte(nil), val...)
+	if len(idx.mem) < idx.maxMem {
+		return nil
+	}
+	if err := idx.spill(); err != nil {
+		return err
+	}
+	if len(idx.runs) >= hashIndexMaxRuns {
+		return idx.compact()
+	}
+	return nil
+}
+
+func (idx *hashIndex) spill() error {
+	keys := make([][20]byte, 0, len(idx.mem))
+	for key := range idx.mem {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	run, err := os.CreateTemp(idx.dir, "libfastimport-hashindex-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(run)
+	rec := make([]byte, idx.recLen())
+	for _, key := range keys {
+		copy(rec[:20], key[:])
+		copy(rec[20:], idx.mem[key])
+		if _, err := w.Write(rec); err != nil {
+			run.Close()
+			os.Remove(run.Name())
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		run.Close()
+		os.Remove(run.Name())
+		return err
+	}
+	idx.runs = append(idx.runs, run)
+	idx.mem = make(map[[20]byte][]byte)
+	return nil
+}
+
+// compact merges all of the runs into a single run; where several runs
+// have the same key, the latest one wins.
+func (idx *hashIndex) compact() error {
+	out, err := os.CreateTemp(idx.dir, "libfastimport-hashindex-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+
+	type cursor struct {
+		r   *bufio.Reader
+		rec []byte
+		ok  bool
+	}
+	cursors := make([]*cursor, len(idx.runs))
+	advance := func(c *cursor) error {
+		_, err := io.ReadFull(c.r, c.rec)
+		switch err {
+		case nil:
+			c.ok = true
+		case io.EOF:
+			c.ok = false
+			err = nil
+		}
+		return err
+	}
+	for i, run := range idx.runs {
+		cursors[i] = &cursor{
+			r:   bufio.NewReader(io.NewSectionReader(run, 0, 1<<62)),
+			rec: make([]byte, idx.recLen()),
+		}
+		if err = advance(cursors[i]); err != nil {
+			break
+		}
+	}
+	for err == nil {
+		var least *cursor
+		for _, c := range cursors {
+			if c.ok && (least == nil || bytes.Compare(c.rec[:20], least.rec[:20]) <= 0) {
+				least = c
+			}
+		}
+		if least == nil {
+			break
+		}
+		if _, err = w.Write(least.rec); err != nil {
+			break
+		}
+		var key [20]byte
+		copy(key[:], least.rec[:20])
+		for _, c := range cursors {
+			for err == nil && c.ok && bytes.Equal(c.rec[:20], key[:]) {
+				err = advance(c)
+			}
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return errors.Wrap(err, "hashindex: compact")
+	}
+
+	for _, run := range idx.runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+	idx.runs = []*os.File{out}
+	return nil
+}
+
+func (idx *hashIndex) search(run *os.File, key [20]byte) ([]byte, bool, error) {
+	fi, err := run.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	recLen := idx.recLen()
+	n := int(fi.Size() / int64(recLen))
+	rec := make([]byte, recLen)
+	var rerr error
+	i := sort.Search(n, func(i int) bool {
+		if rerr != nil {
+			return true
+		}
+		if _, err := run.ReadAt(rec, int64(i)*int64(recLen)); err != nil {
+			rerr = err
+			return true
+		}
+		return bytes.Compare(rec[:20], key[:]) >= 0
+	})
+	if rerr != nil {
+		return nil, false, rerr
+	}
+	if i >= n {
+		return nil, false, nil
+	}
+	if _, err := run.ReadAt(rec, int64(i)*int64(recLen)); err != nil {
+		return nil, false, err
+	}
+	if !bytes.Equal(rec[:20], key[:]) {
+		return nil, false, nil
+	}
+	return rec[20:], true, nil
+}
+
+// Close removes any files that the index spilled to disk.
+func (idx *hashIndex) Close() error {
+	var err error
+	for _, run := range idx.runs {
+		if cerr := run.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if rerr := os.Remove(run.Name()); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	idx.runs = nil
+	idx.mem = make(map[[20]byte][]byte)
+	return err
+}
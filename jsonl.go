@@ -0,0 +1,516 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// jsonText is a string that is encoded in JSON as a plain string if
+// it is valid UTF-8, and as {"base64": "..."} if it isn't, so that
+// arbitrary bytes survive the trip.
+type jsonText string
+
+func (t jsonText) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(t)) {
+		return jsonMarshal(string(t))
+	}
+	return jsonMarshal(struct {
+		Base64 string `json:"base64"`
+	}{base64.StdEncoding.EncodeToString([]byte(t))})
+}
+
+func (t *jsonText) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*t = jsonText(str)
+		return nil
+	}
+	var obj struct {
+		Base64 *string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if obj.Base64 == nil {
+		return errors.Errorf("expected a string or {\"base64\": ...}: %s", data)
+	}
+	bs, err := base64.StdEncoding.DecodeString(*obj.Base64)
+	if err != nil {
+		return err
+	}
+	*t = jsonText(bs)
+	return nil
+}
+
+// jsonData is binary data, encoded in JSON as a base64 string.
+type jsonData string
+
+func (d jsonData) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(base64.StdEncoding.EncodeToString([]byte(d)))
+}
+
+func (d *jsonData) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	bs, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return err
+	}
+	*d = jsonData(bs)
+	return nil
+}
+
+// jsonMarshal is json.Marshal, but without escaping "<", ">", and
+// "&", which are common in commit messages.
+func jsonMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type jsonIdent struct {
+	Name  jsonText `json:"name,omitempty"`
+	Email jsonText `json:"email"`
+	Time  int64    `json:"time"` // seconds since the UNIX epoch
+	TZ    string   `json:"tz"`   // "+hhmm" or "-hhmm"
+}
+
+func toJSONIdent(ident Ident) *jsonIdent {
+	return &jsonIdent{
+		Name:  jsonText(ident.Name),
+		Email: jsonText(ident.Email),
+		Time:  ident.Time.Unix(),
+		TZ:    ident.Time.Format("-0700"),
+	}
+}
+
+func (j *jsonIdent) ident() (Ident, error) {
+	tzt, err := time.Parse("-0700", j.TZ)
+	if err != nil {
+		return Ident{}, err
+	}
+	return Ident{
+		Name:  string(j.Name),
+		Email: string(j.Email),
+		Time:  time.Unix(j.Time, 0).In(tzt.Location()),
+	}, nil
+}
+
+// jsonCmd is the JSON form of a Cmd.  The Type is the keyword that
+// introduces the command in a fast-import stream (see cmdName); the
+// other fields are named after the lines or arguments of the command.
+type jsonCmd struct {
+	Type string `json:"type"`
+
+	Ref         jsonText   `json:"ref,omitempty"`
+	Mark        int        `json:"mark,omitempty"`
+	OriginalOID jsonText   `json:"original_oid,omitempty"`
+	Author      *jsonIdent `json:"author,omitempty"`
+	Committer   *jsonIdent `json:"committer,omitempty"`
+	Tagger      *jsonIdent `json:"tagger,omitempty"`
+	Encoding    jsonText   `json:"encoding,omitempty"`
+	Message     *jsonText  `json:"message,omitempty"`
+	From        jsonText   `json:"from,omitempty"`
+	Merge       []jsonText `json:"merge,omitempty"`
+	To          jsonText   `json:"to,omitempty"`
+
+	Mode      string    `json:"mode,omitempty"`
+	Path      jsonText  `json:"path,omitempty"`
+	Src       jsonText  `json:"src,omitempty"`
+	Dst       jsonText  `json:"dst,omitempty"`
+	CommitIsh jsonText  `json:"commitish,omitempty"`
+	DataRef   jsonText  `json:"dataref,omitempty"`
+	Data      *jsonData `json:"data,omitempty"`
+
+	Feature  jsonText  `json:"feature,omitempty"`
+	Argument *jsonText `json:"argument,omitempty"`
+	Option   jsonText  `json:"option,omitempty"`
+	Text     *jsonText `json:"text,omitempty"` // of a comment or progress
+
+	Files []*jsonCmd `json:"files,omitempty"` // the file commands of a commit
+}
+
+func textPtr(str string) *jsonText {
+	t := jsonText(str)
+	return &t
+}
+
+func dataPtr(str string) *jsonData {
+	d := jsonData(str)
+	return &d
+}
+
+func toJSONCmd(cmd Cmd) (*jsonCmd, error) {
+	j := &jsonCmd{Type: cmdName(cmd)}
+	switch c := cmd.(type) {
+	case CmdBlob:
+		j.Mark = c.Mark
+		j.OriginalOID = jsonText(c.OriginalOID)
+		j.Data = dataPtr(c.Data)
+	case CmdCommit:
+		j.Ref = jsonText(c.Ref)
+		j.Mark = c.Mark
+		j.OriginalOID = jsonText(c.OriginalOID)
+		if c.Author != nil {
+			j.Author = toJSONIdent(*c.Author)
+		}
+		j.Committer = toJSONIdent(c.Committer)
+		j.Encoding = jsonText(c.Encoding)
+		j.Message = textPtr(c.Msg)
+		j.From = jsonText(c.From)
+		for _, merge := range c.Merge {
+			j.Merge = append(j.Merge, jsonText(merge))
+		}
+	case CmdTag:
+		j.Ref = jsonText(c.RefName)
+		j.Mark = c.Mark
+		j.From = jsonText(c.CommitIsh)
+		j.OriginalOID = jsonText(c.OriginalOID)
+		j.Tagger = toJSONIdent(c.Tagger)
+		j.Message = textPtr(c.Data)
+	case CmdReset:
+		j.Ref = jsonText(c.RefName)
+		j.From = jsonText(c.CommitIsh)
+	case CmdAlias:
+		j.Mark = c.Mark
+		j.To = jsonText(c.CommitIsh)
+	case CmdCheckpoint, CmdDone:
+	case CmdProgress:
+		j.Text = textPtr(c.Str)
+	case CmdFeature:
+		j.Feature = jsonText(c.Feature)
+		if c.Argument != "" {
+			j.Argument = textPtr(c.Argument)
+		}
+	case CmdOption:
+		j.Option = jsonText(c.Option)
+	case CmdComment:
+		j.Text = textPtr(c.Comment)
+	case CmdGetMark:
+		j.Mark = c.Mark
+	case CmdCatBlob:
+		j.DataRef = jsonText(c.DataRef)
+	case CmdLs:
+		j.DataRef = jsonText(c.DataRef)
+		j.Path = jsonText(c.Path)
+	case FileModify:
+		j.Mode = c.Mode.String()
+		j.Path = jsonText(c.Path)
+		j.DataRef = jsonText(c.DataRef)
+	case FileModifyInline:
+		j.Mode = c.Mode.String()
+		j.Path = jsonText(c.Path)
+		j.Data = dataPtr(c.Data)
+	case FileDelete:
+		j.Path = jsonText(c.Path)
+	case FileCopy:
+		j.Src, j.Dst = jsonText(c.Src), jsonText(c.Dst)
+	case FileRename:
+		j.Src, j.Dst = jsonText(c.Src), jsonText(c.Dst)
+	case FileDeleteAll:
+	case NoteModify:
+		j.CommitIsh = jsonText(c.CommitIsh)
+		j.DataRef = jsonText(c.DataRef)
+	case NoteModifyInline:
+		j.CommitIsh = jsonText(c.CommitIsh)
+		j.Data = dataPtr(c.Data)
+	default:
+		return nil, errors.Errorf("json: unsupported command: %T", cmd)
+	}
+	return j, nil
+}
+
+func (j *jsonCmd) cmd() (Cmd, error) {
+	ident := func(field string, ji *jsonIdent) (Ident, error) {
+		if ji == nil {
+			return Ident{}, errors.Errorf("json: %s: missing %s", j.Type, field)
+		}
+		return ji.ident()
+	}
+	text := func(t *jsonText) string {
+		if t == nil {
+			return ""
+		}
+		return string(*t)
+	}
+	data := func() (string, error) {
+		if j.Data == nil {
+			return "", errors.Errorf("json: %s: missing data", j.Type)
+		}
+		return string(*j.Data), nil
+	}
+	mode := func() (Mode, error) {
+		m, err := strconv.ParseUint(j.Mode, 8, 18)
+		if err != nil {
+			return 0, errors.Wrapf(err, "json: %s: mode", j.Type)
+		}
+		return Mode(m), nil
+	}
+
+	switch j.Type {
+	case "blob":
+		d, err := data()
+		return CmdBlob{Mark: j.Mark, OriginalOID: string(j.OriginalOID), Data: d}, err
+	case "commit":
+		c := CmdCommit{
+			Ref:         string(j.Ref),
+			Mark:        j.Mark,
+			OriginalOID: string(j.OriginalOID),
+			Encoding:    string(j.Encoding),
+			Msg:         text(j.Message),
+			From:        string(j.From),
+		}
+		if j.Author != nil {
+			author, err := j.Author.ident()
+			if err != nil {
+				return nil, err
+			}
+			c.Author = &author
+		}
+		var err error
+		if c.Committer, err = ident("committer", j.Committer); err != nil {
+			return nil, err
+		}
+		for _, merge := range j.Merge {
+			c.Merge = append(c.Merge, string(merge))
+		}
+		return c, nil
+	case "tag":
+		tagger, err := ident("tagger", j.Tagger)
+		return CmdTag{
+			RefName:     string(j.Ref),
+			Mark:        j.Mark,
+			CommitIsh:   string(j.From),
+			OriginalOID: string(j.OriginalOID),
+			Tagger:      tagger,
+			Data:        text(j.Message),
+		}, err
+	case "reset":
+		return CmdReset{RefName: string(j.Ref), CommitIsh: string(j.From)}, nil
+	case "alias":
+		return CmdAlias{Mark: j.Mark, CommitIsh: string(j.To)}, nil
+	case "checkpoint":
+		return CmdCheckpoint{}, nil
+	case "progress":
+		return CmdProgress{Str: text(j.Text)}, nil
+	case "feature":
+		return CmdFeature{Feature: string(j.Feature), Argument: text(j.Argument)}, nil
+	case "option":
+		return CmdOption{Option: string(j.Option)}, nil
+	case "done":
+		return CmdDone{}, nil
+	case "comment":
+		return CmdComment{Comment: text(j.Text)}, nil
+	case "get-mark":
+		return CmdGetMark{Mark: j.Mark}, nil
+	case "cat-blob":
+		return CmdCatBlob{DataRef: string(j.DataRef)}, nil
+	case "ls":
+		return CmdLs{DataRef: string(j.DataRef), Path: Path(j.Path)}, nil
+	case "M":
+		m, err := mode()
+		if err != nil {
+			return nil, err
+		}
+		if j.Data != nil {
+			return FileModifyInline{Mode: m, Path: Path(j.Path), Data: string(*j.Data)}, nil
+		}
+		return FileModify{Mode: m, Path: Path(j.Path), DataRef: string(j.DataRef)}, nil
+	case "D":
+		return FileDelete{Path: Path(j.Path)}, nil
+	case "C":
+		return FileCopy{Src: Path(j.Src), Dst: Path(j.Dst)}, nil
+	case "R":
+		return FileRename{Src: Path(j.Src), Dst: Path(j.Dst)}, nil
+	case "deleteall":
+		return FileDeleteAll{}, nil
+	case "N":
+		if j.Data != nil {
+			return NoteModifyInline{CommitIsh: string(j.CommitIsh), Data: string(*j.Data)}, nil
+		}
+		return NoteModify{CommitIsh: string(j.CommitIsh), DataRef: string(j.DataRef)}, nil
+	default:
+		return nil, errors.Errorf("json: unknown command type: %q", j.Type)
+	}
+}
+
+// A JSONWriter is a CmdWriter that writes commands as JSON Lines: one
+// JSON object per line, per command.  The commands within a commit
+// (file commands, and any comments, "ls", etc.) are not written on
+// their own lines, but as the "files" array of the commit.
+//
+// Strings that are not valid UTF-8 are written as
+// {"base64": "..."}, and blob and file data is always base64, so the
+// commands are encoded losslessly; a JSONReader reads them back.
+//
+// How the commands were written is not recorded, so writing them
+// back out with a Backend gives the Backend's canonical form of the
+// stream: data is counted ("data <n>", unless Backend.SetDelimitText
+// says otherwise), optional blank lines are only written at the end
+// of each commit, and paths are only quoted where they must be.
+// Encoding the canonical form gives the same JSON Lines.
+type JSONWriter struct {
+	w      io.Writer
+	commit *jsonCmd
+}
+
+// NewJSONWriter creates a new JSONWriter that writes to w.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: w}
+}
+
+func (jw *JSONWriter) write(j *jsonCmd) error {
+	line, err := jsonMarshal(j)
+	if err != nil {
+		return err
+	}
+	_, err = jw.w.Write(append(line, '\n'))
+	return err
+}
+
+// Do writes the command.
+func (jw *JSONWriter) Do(cmd Cmd) error {
+	if jw.commit != nil {
+		if _, isEnd := cmd.(CmdCommitEnd); isEnd {
+			commit := jw.commit
+			jw.commit = nil
+			return jw.write(commit)
+		}
+		if cmdIs(cmd, cmdClassInCommit) {
+			j, err := toJSONCmd(cmd)
+			if err != nil {
+				return err
+			}
+			jw.commit.Files = append(jw.commit.Files, j)
+			return nil
+		}
+		return errors.Errorf("json: %T in the middle of a commit", cmd)
+	}
+	if _, isEnd := cmd.(CmdCommitEnd); isEnd {
+		return errors.New("json: commit-end outside of a commit")
+	}
+	j, err := toJSONCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if j.Type == "commit" {
+		jw.commit = j
+		return nil
+	}
+	return jw.write(j)
+}
+
+// A JSONReader reads commands written by a JSONWriter.
+type JSONReader struct {
+	dec   *json.Decoder
+	queue []Cmd
+}
+
+// NewJSONReader creates a new JSONReader that reads from r.
+func NewJSONReader(r io.Reader) *JSONReader {
+	return &JSONReader{dec: json.NewDecoder(r)}
+}
+
+// ReadCmd reads a command.  Like Frontend.ReadCmd, it follows each
+// CmdCommit with its file commands and a CmdCommitEnd, and returns
+// io.EOF at the end of the input.
+func (jr *JSONReader) ReadCmd() (Cmd, error) {
+	if len(jr.queue) == 0 {
+		var j jsonCmd
+		if err := jr.dec.Decode(&j); err != nil {
+			return nil, err
+		}
+		cmd, err := j.cmd()
+		if err != nil {
+			return nil, err
+		}
+		if j.Type != "commit" {
+			if len(j.Files) > 0 {
+				return nil, errors.Errorf("json: %s: unexpected files", j.Type)
+			}
+			return cmd, nil
+		}
+		jr.queue = append(jr.queue, cmd)
+		for _, jf := range j.Files {
+			file, err := jf.cmd()
+			if err != nil {
+				return nil, err
+			}
+			if !cmdIs(file, cmdClassInCommit) {
+				return nil, errors.Errorf("json: commit: %s is not allowed in a commit", jf.Type)
+			}
+			jr.queue = append(jr.queue, file)
+		}
+		jr.queue = append(jr.queue, CmdCommitEnd{})
+	}
+	cmd := jr.queue[0]
+	jr.queue = jr.queue[1:]
+	return cmd, nil
+}
+
+// EncodeJSON reads every command from the Frontend, and writes it to
+// w as JSON Lines.
+func EncodeJSON(f *Frontend, w io.Writer) error {
+	jw := NewJSONWriter(w)
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := jw.Do(cmd); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeJSON reads every command from JSON Lines written by a
+// JSONWriter, and passes it to the CmdWriter (such as a Backend).
+func DecodeJSON(r io.Reader, b CmdWriter) error {
+	jr := NewJSONReader(r)
+	for {
+		cmd, err := jr.ReadCmd()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := b.Do(cmd); err != nil {
+			return err
+		}
+	}
+}
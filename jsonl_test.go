@@ -0,0 +1,101 @@
+// Tests for JSON Lines encoding
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	input := `feature done
+option git quiet
+# a comment
+blob
+mark :1
+original-oid 1111111111111111111111111111111111111111
+data <<EOF
+a
+EOF
+` + "blob\nmark :2\ndata 3\n\x00\xff\n\n" + `reset refs/heads/main
+commit refs/heads/main
+mark :3
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0530
+committer <rcowham@perforce.com> 1644399073 -0800
+encoding ISO-8859-1
+` + "data 6\ncaf\xe9!\n" + `M 100644 :1 a.txt
+M 100755 :2 "b c.sh"
+M 100644 inline x
+data 2
+x
+D old
+C a.txt copy.txt
+R "b c.sh" d.sh
+ls "a.txt"
+N :1 :3
+N inline :3
+data 5
+note
+
+commit refs/heads/side
+mark :4
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data <<EOF
+merge
+EOF
+from :3
+merge :3
+deleteall
+
+tag v1
+mark :5
+from :4
+tagger Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 4
+tag
+alias
+mark :6
+to :4
+
+get-mark :4
+cat-blob :1
+ls :4 d.sh
+progress half way
+checkpoint
+done
+`
+	// The commands survive the trip, but not how they were written.
+	canonical := strings.NewReplacer(
+		// Data is written counted.
+		"data <<EOF\na\nEOF\n", "data 2\na\n",
+		"data <<EOF\nmerge\nEOF\n", "data 6\nmerge\n",
+		// Optional blank lines are only written at the end
+		// of a commit.
+		"\x00\xff\n\n", "\x00\xff\n",
+		"to :4\n\n", "to :4\n",
+	).Replace(input)
+
+	var jsonl bytes.Buffer
+	assert.Nil(t, EncodeJSON(NewFrontend(strings.NewReader(input), nil, nil), &jsonl))
+	lines := strings.Split(strings.TrimSuffix(jsonl.String(), "\n"), "\n")
+	assert.Equal(t, 16, len(lines))
+	assert.Equal(t, `{"type":"blob","mark":2,"data":"AP8K"}`, lines[4])
+	assert.Contains(t, lines[6], `"message":{"base64":"Y2Fm6SEK"}`)
+	assert.Contains(t, lines[6], `"author":{"name":"Robert Cowham","email":"rcowham@perforce.com","time":1644399073,"tz":"+0530"}`)
+	assert.Contains(t, lines[6], `{"type":"M","mode":"100644","path":"x","data":"eAo="}`)
+
+	var outbuf bytes.Buffer
+	bw := bufio.NewWriter(&outbuf)
+	assert.Nil(t, DecodeJSON(strings.NewReader(jsonl.String()), NewBackend(&MyWriteCloser{bw}, nil, nil)))
+	bw.Flush()
+	assert.Equal(t, canonical, outbuf.String())
+
+	// The canonical form encodes to the same JSON Lines.
+	var jsonl2 bytes.Buffer
+	assert.Nil(t, EncodeJSON(NewFrontend(strings.NewReader(canonical), nil, nil), &jsonl2))
+	assert.Equal(t, jsonl.String(), jsonl2.String())
+}
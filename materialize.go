@@ -0,0 +1,394 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaterializeOptions are the options for a Materializer.
+type MaterializeOptions struct {
+	// Resolve, if set, returns the content of a blob that a
+	// FileModify refers to but that was not defined in the
+	// stream: a SHA-1, or a mark that was loaded from an external
+	// marks file.  Without it, such files can't be written.
+	Resolve func(dataref string) (string, error)
+
+	// If ArchiveDir is set, an archive of the tree of every
+	// commit (and, if ArchiveTags is set, every annotated tag) is
+	// written to it as the commit (or tag) is passed to Do.  It
+	// is named for the commit's original-oid, or else its mark
+	// ("mark-<idnum>"), or else its position in the stream
+	// ("commit-<n>"); or for the tag ("tag-<name>").
+	ArchiveDir string
+	// ArchiveFormat is "tar" (the default) or "zip".
+	ArchiveFormat string
+	ArchiveTags   bool
+}
+
+// A Materializer replays the commands passed to it, so that the tree
+// of any commit (or tag, or branch) in the stream may be written out,
+// without importing the stream into git.
+//
+// The content of every blob is kept in memory.
+//
+// Regular and executable files are written with mode 0644 and 0755;
+// symbolic links (ModeSym) are written as symbolic links; and
+// gitlinks (ModeGit) are written as empty directories, as git does
+// for a submodule that isn't checked out.
+type Materializer struct {
+	opts MaterializeOptions
+
+	replay  *treeReplay
+	content map[string]string    // blob ID => content
+	times   map[string]time.Time // like replay.commits and replay.tips
+	commits int
+}
+
+// NewMaterializer creates a new Materializer.
+func NewMaterializer(opts MaterializeOptions) *Materializer {
+	if opts.ArchiveFormat == "" {
+		opts.ArchiveFormat = "tar"
+	}
+	return &Materializer{
+		opts:    opts,
+		replay:  newTreeReplay(),
+		content: make(map[string]string),
+		times:   make(map[string]time.Time),
+	}
+}
+
+// Do applies the command; and writes an archive, if the options say
+// to.
+func (m *Materializer) Do(cmd Cmd) error {
+	var commit *CmdCommit
+	switch c := cmd.(type) {
+	case CmdBlob:
+		m.content[BlobSHA1(c.Data)] = c.Data
+	case FileModifyInline:
+		m.content[BlobSHA1(c.Data)] = c.Data
+	case CmdCommitEnd:
+		commit = m.replay.commit
+	case CmdReset:
+		if c.CommitIsh != "" {
+			m.times[c.RefName] = m.times[c.CommitIsh]
+		}
+	case CmdTag:
+		return m.tag(c)
+	}
+	m.replay.Do(cmd)
+	if commit == nil {
+		return nil
+	}
+
+	m.commits++
+	when := commit.Committer.Time
+	m.times[commit.Ref] = when
+	name := "commit-" + strconv.Itoa(m.commits)
+	if commit.Mark > 0 {
+		m.times[markRef(commit.Mark)] = when
+		name = "mark-" + strconv.Itoa(commit.Mark)
+	}
+	if commit.OriginalOID != "" {
+		m.times[commit.OriginalOID] = when
+		if isPlainFileName(commit.OriginalOID) {
+			name = commit.OriginalOID
+		}
+	}
+	if m.opts.ArchiveDir == "" {
+		return nil
+	}
+	return m.archive(name, m.replay.cur, when)
+}
+
+func (m *Materializer) tag(c CmdTag) error {
+	t := m.replay.commitTree(c.CommitIsh)
+	when := m.times[strings.TrimSuffix(c.CommitIsh, "^0")]
+	ref := "refs/tags/" + c.RefName
+	m.replay.tips[ref] = t
+	m.times[ref] = when
+	if c.Mark > 0 {
+		m.replay.commits[markRef(c.Mark)] = t
+		m.times[markRef(c.Mark)] = when
+	}
+	if m.opts.ArchiveDir == "" || !m.opts.ArchiveTags {
+		return nil
+	}
+	return m.archive("tag-"+strings.NewReplacer("/", "_", "\\", "_").Replace(c.RefName), t, when)
+}
+
+// isPlainFileName returns whether name can be used as the name of a
+// file in a directory, without naming something outside of it.
+func isPlainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// checkPath returns an error if a path from the stream isn't safe to
+// write out: if it is absolute, or has an empty, ".", "..", or ".git"
+// component.
+func checkPath(path Path) error {
+	for _, elem := range strings.Split(string(path), "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.EqualFold(elem, ".git") {
+			return errors.Errorf("bad path in stream: %q", path)
+		}
+	}
+	return nil
+}
+
+func (m *Materializer) archive(name string, t *tree, when time.Time) error {
+	file, err := os.Create(filepath.Join(m.opts.ArchiveDir, name+"."+m.opts.ArchiveFormat))
+	if err != nil {
+		return err
+	}
+	switch m.opts.ArchiveFormat {
+	case "tar":
+		err = m.writeTar(t, when, file)
+	case "zip":
+		err = m.writeZip(t, when, file)
+	default:
+		err = errors.Errorf("unknown archive format: %q", m.opts.ArchiveFormat)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lookup returns the tree of a commit-ish: a mark reference, an
+// original-oid, a ref, or a branch or tag name.
+func (m *Materializer) lookup(commitish string) (*tree, time.Time, error) {
+	commitish = strings.TrimSuffix(commitish, "^0")
+	for _, name := range []string{commitish, "refs/heads/" + commitish, "refs/tags/" + commitish} {
+		if t, ok := m.replay.tips[name]; ok {
+			return t, m.times[name], nil
+		}
+		if t, ok := m.replay.commits[name]; ok {
+			return t, m.times[name], nil
+		}
+	}
+	return nil, time.Time{}, errors.Errorf("unknown commit: %q", commitish)
+}
+
+func (m *Materializer) blob(e treeEntry) (string, error) {
+	if data, ok := m.content[e.ID]; ok {
+		return data, nil
+	}
+	if m.opts.Resolve == nil {
+		return "", errors.Errorf("content of blob %q is not known", e.ID)
+	}
+	return m.opts.Resolve(e.ID)
+}
+
+func fileMode(mode Mode) os.FileMode {
+	if mode == ModeExe {
+		return 0755
+	}
+	return 0644
+}
+
+// WriteDir writes the tree of the commit-ish to dir, which is created
+// if it doesn't exist.  Files already in dir are replaced, but not
+// removed.  Symbolic links in dir are never followed.
+//
+// It is an error for the tree to have a path that is absolute, or
+// that has an empty, ".", "..", or ".git" component; WriteTar and
+// WriteZip also refuse such paths.
+func (m *Materializer) WriteDir(commitish string, dir string) error {
+	t, _, err := m.lookup(commitish)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return t.walk(func(path Path, e treeEntry) error {
+		if err := checkPath(path); err != nil {
+			return err
+		}
+		elems := strings.Split(string(path), "/")
+		name := dir
+		for _, elem := range elems[:len(elems)-1] {
+			name = filepath.Join(name, elem)
+			if err := mkdirNoFollow(name); err != nil {
+				return err
+			}
+		}
+		name = filepath.Join(name, elems[len(elems)-1])
+		if e.Mode == ModeGit {
+			return mkdirNoFollow(name)
+		}
+		data, err := m.blob(e)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if e.Mode == ModeSym {
+			return os.Symlink(data, name)
+		}
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileMode(e.Mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(file, data)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// mkdirNoFollow creates the directory name, if it doesn't exist.
+// Anything else in its place, including a symbolic link (which may
+// have been written by an earlier WriteDir), is removed first, so
+// that nothing is written outside of the directory being written to.
+func mkdirNoFollow(name string) error {
+	info, err := os.Lstat(name)
+	switch {
+	case err == nil && info.IsDir():
+		return nil
+	case err == nil:
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	return os.Mkdir(name, 0755)
+}
+
+// WriteTar writes the tree of the commit-ish to w as a tar archive.
+// The modification time of every file is the time of the commit.
+func (m *Materializer) WriteTar(commitish string, w io.Writer) error {
+	t, when, err := m.lookup(commitish)
+	if err != nil {
+		return err
+	}
+	return m.writeTar(t, when, w)
+}
+
+func (m *Materializer) writeTar(t *tree, when time.Time, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := t.walk(func(path Path, e treeEntry) error {
+		if err := checkPath(path); err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    string(path),
+			ModTime: when,
+			Format:  tar.FormatPAX,
+		}
+		var data string
+		switch e.Mode {
+		case ModeGit:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0755
+			return tw.WriteHeader(hdr)
+		case ModeSym:
+			target, err := m.blob(e)
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+			hdr.Mode = 0777
+			return tw.WriteHeader(hdr)
+		default:
+			var err error
+			if data, err = m.blob(e); err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = int64(fileMode(e.Mode))
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.WriteString(tw, data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// WriteZip writes the tree of the commit-ish to w as a zip archive.
+// The modification time of every file is the time of the commit.
+func (m *Materializer) WriteZip(commitish string, w io.Writer) error {
+	t, when, err := m.lookup(commitish)
+	if err != nil {
+		return err
+	}
+	return m.writeZip(t, when, w)
+}
+
+func (m *Materializer) writeZip(t *tree, when time.Time, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := t.walk(func(path Path, e treeEntry) error {
+		if err := checkPath(path); err != nil {
+			return err
+		}
+		hdr := &zip.FileHeader{
+			Name:     string(path),
+			Method:   zip.Deflate,
+			Modified: when,
+		}
+		var data string
+		switch e.Mode {
+		case ModeGit:
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+			hdr.SetMode(os.ModeDir | 0755)
+		case ModeSym:
+			// As with Info-ZIP, the content of a symbolic
+			// link is its target.
+			var err error
+			if data, err = m.blob(e); err != nil {
+				return err
+			}
+			hdr.SetMode(os.ModeSymlink | 0777)
+		default:
+			var err error
+			if data, err = m.blob(e); err != nil {
+				return err
+			}
+			hdr.SetMode(fileMode(e.Mode))
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
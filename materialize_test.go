@@ -0,0 +1,249 @@
+// Tests for Materializer
+
+package libfastimport
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const materializeInput = `blob
+mark :1
+data 2
+a
+blob
+mark :2
+data 10
+#!/bin/sh
+blob
+mark :3
+data 5
+a.txt
+commit refs/heads/main
+mark :4
+original-oid 1111111111111111111111111111111111111111
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 8
+initial
+M 100644 :1 a.txt
+M 100755 :2 bin/run.sh
+M 120000 :3 link
+M 160000 0123456789012345678901234567890123456789 sub
+M 100644 2222222222222222222222222222222222222222 external.txt
+
+commit refs/heads/main
+mark :5
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+second
+from :4
+R bin/run.sh run.sh
+D external.txt
+M 100644 inline dir/b.txt
+data 2
+b
+
+tag v1
+from :4
+tagger Robert Cowham <rcowham@perforce.com> 1644399075 +0000
+data 4
+tag
+`
+
+func materialize(t *testing.T, opts MaterializeOptions) *Materializer {
+	t.Helper()
+	m := NewMaterializer(opts)
+	NewFrontend(strings.NewReader(materializeInput), nil, nil).All()(func(cmd Cmd, err error) bool {
+		assert.Nil(t, err)
+		assert.Nil(t, m.Do(cmd))
+		return true
+	})
+	return m
+}
+
+// listDir lists the files in dir, as "path mode [content]".
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || name == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, name)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(name)
+			files = append(files, rel+" -> "+target)
+		case info.IsDir():
+			files = append(files, rel+"/")
+		default:
+			data, _ := os.ReadFile(name)
+			files = append(files, rel+" "+info.Mode().String()+" "+string(data))
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	return files
+}
+
+func TestMaterializeDir(t *testing.T) {
+	resolve := func(dataref string) (string, error) { return "external\n", nil }
+	m := materialize(t, MaterializeOptions{Resolve: resolve})
+
+	dir := t.TempDir()
+	assert.Nil(t, m.WriteDir("1111111111111111111111111111111111111111", dir))
+	assert.Equal(t, []string{
+		"a.txt -rw-r--r-- a\n",
+		"bin/",
+		"bin/run.sh -rwxr-xr-x #!/bin/sh\n",
+		"external.txt -rw-r--r-- external\n",
+		"link -> a.txt",
+		"sub/",
+	}, listDir(t, dir))
+
+	dir = t.TempDir()
+	assert.Nil(t, m.WriteDir("main", dir))
+	assert.Equal(t, []string{
+		"a.txt -rw-r--r-- a\n",
+		"dir/",
+		"dir/b.txt -rw-r--r-- b\n",
+		"link -> a.txt",
+		"run.sh -rwxr-xr-x #!/bin/sh\n",
+		"sub/",
+	}, listDir(t, dir))
+
+	assert.NotNil(t, m.WriteDir("nonexistent", t.TempDir()))
+	// Without Resolve, the content of external.txt isn't known.
+	m = materialize(t, MaterializeOptions{})
+	assert.NotNil(t, m.WriteDir(":4", t.TempDir()))
+	assert.Nil(t, m.WriteDir(":5", t.TempDir()))
+}
+
+func readTar(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		data, _ := io.ReadAll(tr)
+		files = append(files, hdr.Name+" "+os.FileMode(hdr.Mode).String()+" "+hdr.Linkname+string(data)+" "+hdr.ModTime.UTC().Format("15:04:05"))
+	}
+	return files
+}
+
+func readZip(t *testing.T, data []byte) []string {
+	t.Helper()
+	var files []string
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		assert.Nil(t, err)
+		content, _ := io.ReadAll(r)
+		r.Close()
+		files = append(files, f.Name+" "+f.Mode().String()+" "+string(content))
+	}
+	return files
+}
+
+func TestMaterializeArchives(t *testing.T) {
+	resolve := func(dataref string) (string, error) { return "external\n", nil }
+	m := materialize(t, MaterializeOptions{Resolve: resolve})
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, m.WriteTar("v1", buf))
+	assert.Nil(t, m.WriteTar("refs/tags/v1", new(bytes.Buffer)))
+	buf.Reset()
+	assert.Nil(t, m.WriteTar(":5", buf))
+	assert.Equal(t, []string{
+		"a.txt -rw-r--r-- a\n 09:31:14",
+		"dir/b.txt -rw-r--r-- b\n 09:31:14",
+		"link -rwxrwxrwx a.txt 09:31:14",
+		"run.sh -rwxr-xr-x #!/bin/sh\n 09:31:14",
+		"sub/ -rwxr-xr-x  09:31:14",
+	}, readTar(t, buf))
+
+	buf.Reset()
+	assert.Nil(t, m.WriteZip(":5", buf))
+	assert.Equal(t, []string{
+		"a.txt -rw-r--r-- a\n",
+		"dir/b.txt -rw-r--r-- b\n",
+		"link Lrwxrwxrwx a.txt",
+		"run.sh -rwxr-xr-x #!/bin/sh\n",
+		"sub/ drwxr-xr-x ",
+	}, readZip(t, buf.Bytes()))
+
+	// An archive for every commit and tag.
+	dir := t.TempDir()
+	materialize(t, MaterializeOptions{Resolve: resolve, ArchiveDir: dir, ArchiveFormat: "zip", ArchiveTags: true})
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"1111111111111111111111111111111111111111.zip", "mark-5.zip", "tag-v1.zip"}, names)
+	data, err := os.ReadFile(filepath.Join(dir, "tag-v1.zip"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(readZip(t, data)))
+}
+
+func TestMaterializeHostilePaths(t *testing.T) {
+	commit := func(mark int, path string) string {
+		return "commit refs/heads/main\nmark :" + strconv.Itoa(mark) + "\n" +
+			"committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 0\n" +
+			"M 100644 inline " + path + "\ndata 5\nevil\n\n"
+	}
+	m := NewMaterializer(MaterializeOptions{})
+	for i, path := range []string{"../evil", "/evil", "a/../../evil", "a/.git/config", ".GIT/hooks/post-checkout", "a//b"} {
+		NewFrontend(strings.NewReader("reset refs/heads/main\n"+commit(i+1, path)), nil, nil).All()(func(cmd Cmd, err error) bool {
+			assert.Nil(t, err)
+			assert.Nil(t, m.Do(cmd))
+			return true
+		})
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "out")
+		assert.NotNil(t, m.WriteDir("main", dir), "%q", path)
+		assert.Equal(t, []string{"out/"}, listDir(t, parent), "%q", path)
+		assert.NotNil(t, m.WriteTar("main", io.Discard), "%q", path)
+		assert.NotNil(t, m.WriteZip("main", io.Discard), "%q", path)
+	}
+
+	// A symbolic link written by one commit isn't followed when a
+	// later one has a directory in its place.
+	outside := t.TempDir()
+	m = NewMaterializer(MaterializeOptions{})
+	input := "blob\nmark :1\ndata " + strconv.Itoa(len(outside)) + "\n" + outside + "\n" +
+		"commit refs/heads/main\nmark :2\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 0\n" +
+		"M 120000 :1 d\n\n" +
+		"commit refs/heads/main\nmark :3\ncommitter Robert Cowham <rcowham@perforce.com> 1644399073 +0000\ndata 0\n" +
+		"D d\nM 100644 inline d/x\ndata 5\nevil\n\n"
+	NewFrontend(strings.NewReader(input), nil, nil).All()(func(cmd Cmd, err error) bool {
+		assert.Nil(t, err)
+		assert.Nil(t, m.Do(cmd))
+		return true
+	})
+	dir := t.TempDir()
+	assert.Nil(t, m.WriteDir(":2", dir))
+	assert.Equal(t, []string{"d -> " + outside}, listDir(t, dir))
+	assert.Nil(t, m.WriteDir(":3", dir))
+	assert.Equal(t, []string{"d/", "d/x -rw-r--r-- evil\n"}, listDir(t, dir))
+	assert.Equal(t, []string(nil), listDir(t, outside))
+}
@@ -78,11 +78,22 @@ type parser struct {
 
 	inCommit bool
 
-	buf_line *string
-	buf_err  error
-
-	ret_cmd chan Cmd
-	ret_err error
+	buf_line    *string
+	buf_skipped string
+	buf_err     error
+
+	// If preserve is set, the original text of each command is
+	// recorded in to raw as it is read.  lastLine is the line
+	// that was read last.
+	preserve bool
+	raw      *Preserved
+	lastLine string
+
+	// Commands that have been parsed, but not yet returned; a
+	// command may be preceded by comments found while parsing it.
+	queue    []Cmd
+	queuePos int
+	err      error
 }
 
 func newParser(fir *textproto.FIReader) *parser {
@@ -93,55 +104,90 @@ func newParser(fir *textproto.FIReader) *parser {
 		parser_comment = parser_compile(parser_commentCmds)
 	}
 
-	ret := &parser{
-		fir:     fir,
-		ret_cmd: make(chan Cmd),
+	return &parser{
+		fir: fir,
 	}
-	go func() {
-		ret.ret_err = ret.parse()
-		close(ret.ret_cmd)
-	}()
-	return ret
 }
 
 func (p *parser) ReadCmd() (Cmd, error) {
-	cmd, ok := <-p.ret_cmd
-	if !ok {
-		return nil, p.ret_err
+	for p.queuePos == len(p.queue) {
+		if p.err != nil {
+			return nil, p.err
+		}
+		p.queue = p.queue[:0]
+		p.queuePos = 0
+		p.err = p.parse()
 	}
+	cmd := p.queue[p.queuePos]
+	p.queue[p.queuePos] = nil
+	p.queuePos++
 	return cmd, nil
 }
 
+func (p *parser) emit(cmd Cmd) {
+	p.queue = append(p.queue, cmd)
+}
+
+// parse parses the next command, adding it (and anything before it)
+// to the queue.
 func (p *parser) parse() error {
-	for {
-		line, err := p.PeekLine()
-		if err != nil {
-			if err == io.EOF && p.inCommit {
-				p.ret_cmd <- CmdCommitEnd{}
+	if p.preserve {
+		p.raw = &Preserved{}
+	}
+	line, err := p.PeekLine()
+	if err != nil {
+		if err == io.EOF && p.inCommit {
+			p.inCommit = false
+			var end Cmd = CmdCommitEnd{}
+			if p.preserve {
+				// Blank lines at the end of the stream.
+				p.record(line)
+				end = preserve(end, p.raw)
 			}
-			return err
-		}
-		subparser := parser_regular(line)
-		if subparser == nil {
-			return UnsupportedCommand(line)
+			p.emit(end)
 		}
-		cmd, err := subparser(p)
-		if err != nil {
-			return err
+		return err
+	}
+	subparser := parser_regular(line)
+	if subparser == nil {
+		return UnsupportedCommand(line)
+	}
+	cmd, err := subparser(p)
+	if err != nil {
+		if err == io.EOF {
+			// The stream ended in the middle of the
+			// command.
+			err = io.ErrUnexpectedEOF
 		}
+		return err
+	}
+	if p.preserve {
+		cmd = preserve(cmd, p.raw)
+	}
 
-		switch {
-		case !cmdIs(cmd, cmdClassInCommit):
-			if p.inCommit {
-				p.ret_cmd <- CmdCommitEnd{}
+	switch {
+	case !cmdIs(cmd, cmdClassInCommit):
+		if p.inCommit {
+			var end Cmd = CmdCommitEnd{}
+			if p.preserve {
+				end = preserve(end, &Preserved{})
 			}
-			_, p.inCommit = cmd.(CmdCommit)
-		case !p.inCommit && !cmdIs(cmd, cmdClassCommand):
-			return errors.Errorf("Got in-commit-only command outside of a commit: %[1]T(%#[1]v)", cmd)
+			p.emit(end)
 		}
+		_, p.inCommit = cmd.(CmdCommit)
+	case !p.inCommit && !cmdIs(cmd, cmdClassCommand):
+		return errors.Errorf("Got in-commit-only command outside of a commit: %[1]T(%#[1]v)", cmd)
+	}
+
+	p.emit(cmd)
 
-		p.ret_cmd <- cmd
+	if _, isDone := cmd.(CmdDone); isDone {
+		// Nothing after "done" is part of the stream, and it
+		// mustn't be read, as the input may be shared (as
+		// with a remote helper).
+		return io.EOF
 	}
+	return nil
 }
 
 func (p *parser) PeekLine() (string, error) {
@@ -149,17 +195,30 @@ func (p *parser) PeekLine() (string, error) {
 		var line string
 		line, p.buf_err = p.fir.ReadLine()
 		p.buf_line = &line
+		p.buf_skipped = p.fir.Skipped()
 		if p.buf_err != nil {
 			return *p.buf_line, p.buf_err
 		}
 		subparser := parser_comment(line)
 		if subparser != nil {
+			outer := p.raw
+			if p.preserve {
+				p.raw = &Preserved{}
+			}
 			var cmd Cmd
 			cmd, p.buf_err = subparser(p)
 			if p.buf_err != nil {
 				return "", p.buf_err
 			}
-			p.ret_cmd <- cmd
+			if p.preserve {
+				cmd = preserve(cmd, p.raw)
+				if _, isComment := cmd.(CmdComment); isComment && len(outer.parts) > 0 {
+					p.raw.isNested = true
+					outer.parts = append(outer.parts, rawPart{nested: p.raw})
+				}
+				p.raw = outer
+			}
+			p.emit(cmd)
 		}
 	}
 	return *p.buf_line, p.buf_err
@@ -167,7 +226,25 @@ func (p *parser) PeekLine() (string, error) {
 
 func (p *parser) ReadLine() (string, error) {
 	line, err := p.PeekLine()
+	if p.preserve && err == nil {
+		p.record(line)
+	}
 	p.buf_line = nil
 	p.buf_err = nil
 	return line, err
 }
+
+// record records a line (and the blank lines skipped before it) in
+// the original text of the command being read.
+func (p *parser) record(line string) {
+	if p.raw.empty() && strings.HasPrefix(p.buf_skipped, "\n") &&
+		strings.HasPrefix(p.lastLine, "data ") && !strings.HasPrefix(p.lastLine, "data <<") &&
+		!strings.HasSuffix(p.lastLine[strings.IndexByte(p.lastLine, '\n')+1:], "\n") {
+		// The command before ended with counted data that
+		// didn't end with LF, so this LF is the optional LF
+		// after it.
+		p.raw.afterData = true
+	}
+	p.raw.record(p.buf_skipped, line)
+	p.lastLine = line
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"io"
+	"reflect"
+	"strings"
+)
+
+// rawPart is a piece of the original text of a command: either text,
+// or a comment that was nested within the command.
+type rawPart struct {
+	text   string
+	nested *Preserved
+}
+
+// Preserved is the original text of a command, as it was read by a
+// Frontend in preservation mode (see Frontend.SetPreserve), along
+// with a copy of the command as it was parsed.
+//
+// When a command is written to a Backend, if it still has the value
+// that it was parsed with, the Backend writes its original text
+// rather than formatting it afresh; so the syntax choices of the
+// original stream (delimited versus counted data, the exact text of
+// idents, blank lines, comments in the middle of a command, ...)
+// survive a round-trip.
+//
+// Every command type has a Preserved field for this.  Adding it was
+// an API change: a command written as a struct literal without field
+// names (such as CmdReset{"refs/heads/main", ":1", ""}) no longer
+// compiles, and must name its fields.
+type Preserved struct {
+	orig   Cmd    // the command as parsed, with a nil Preserved
+	prefix string // blank lines before the command
+	parts  []rawPart
+
+	// If afterData is set, the first LF of prefix is really the
+	// optional LF after the counted data that ended the command
+	// before; it is not written if that command was written
+	// afresh, as FIWriter.WriteData will have written it.
+	afterData bool
+
+	// A comment in the middle of another command is read (and
+	// so returned by Frontend.ReadCmd) before that command, but
+	// is written as part of it.
+	isNested bool
+}
+
+// Raw returns the original text of the command, including any blank
+// lines before it.
+func (p *Preserved) Raw() string {
+	var ret strings.Builder
+	p.writeTo(&ret, false, nil)
+	return ret.String()
+}
+
+// empty returns whether the command has no text at all.
+func (p *Preserved) empty() bool {
+	return p.prefix == "" && len(p.parts) == 0
+}
+
+// writeTo writes the original text of the command to w; afresh says
+// whether the command before it was written afresh.  Nested comments
+// are only written if nested says to.
+func (p *Preserved) writeTo(w io.Writer, afresh bool, nested func(*Preserved) bool) error {
+	prefix := p.prefix
+	if afresh && p.afterData {
+		prefix = prefix[1:]
+	}
+	if _, err := io.WriteString(w, prefix); err != nil {
+		return err
+	}
+	for _, part := range p.parts {
+		var err error
+		switch {
+		case part.nested == nil:
+			_, err = io.WriteString(w, part.text)
+		case nested == nil || nested(part.nested):
+			err = part.nested.writeTo(w, false, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record adds a line that was read to the original text.
+func (p *Preserved) record(skipped, line string) {
+	if len(p.parts) == 0 && p.prefix == "" {
+		p.prefix = skipped
+	} else if skipped != "" {
+		p.parts = append(p.parts, rawPart{text: skipped})
+	}
+	p.parts = append(p.parts, rawPart{text: line})
+}
+
+// cmdPreserved returns the Preserved of a command, or nil.  Unlike
+// cmdWithPreserved, it is used for every command that a Backend
+// writes, so it doesn't use reflection.
+func cmdPreserved(cmd Cmd) *Preserved {
+	switch c := cmd.(type) {
+	case CmdCommit:
+		return c.Preserved
+	case CmdCommitEnd:
+		return c.Preserved
+	case CmdTag:
+		return c.Preserved
+	case CmdReset:
+		return c.Preserved
+	case CmdBlob:
+		return c.Preserved
+	case CmdAlias:
+		return c.Preserved
+	case CmdCheckpoint:
+		return c.Preserved
+	case CmdProgress:
+		return c.Preserved
+	case CmdFeature:
+		return c.Preserved
+	case CmdOption:
+		return c.Preserved
+	case CmdDone:
+		return c.Preserved
+	case CmdComment:
+		return c.Preserved
+	case CmdGetMark:
+		return c.Preserved
+	case CmdCatBlob:
+		return c.Preserved
+	case CmdLs:
+		return c.Preserved
+	case FileModify:
+		return c.Preserved
+	case FileModifyInline:
+		return c.Preserved
+	case FileDelete:
+		return c.Preserved
+	case FileCopy:
+		return c.Preserved
+	case FileRename:
+		return c.Preserved
+	case FileDeleteAll:
+		return c.Preserved
+	case NoteModify:
+		return c.Preserved
+	case NoteModifyInline:
+		return c.Preserved
+	}
+	return nil
+}
+
+// cmdWithPreserved returns a copy of the command with its Preserved
+// set to p.
+func cmdWithPreserved(cmd Cmd, p *Preserved) Cmd {
+	v := reflect.New(reflect.TypeOf(cmd)).Elem()
+	v.Set(reflect.ValueOf(cmd))
+	f := v.FieldByName("Preserved")
+	if !f.IsValid() {
+		return cmd
+	}
+	f.Set(reflect.ValueOf(p))
+	return v.Interface().(Cmd)
+}
+
+// preserve attaches p to the command, recording a copy of the command
+// that won't be affected by changes to the original.
+func preserve(cmd Cmd, p *Preserved) Cmd {
+	orig := cmdWithPreserved(cmd, nil)
+	if c, ok := orig.(CmdCommit); ok {
+		if c.Author != nil {
+			author := *c.Author
+			c.Author = &author
+		}
+		c.Merge = append([]string(nil), c.Merge...)
+		orig = c
+	}
+	p.orig = orig
+	return cmdWithPreserved(cmd, p)
+}
+
+// unmodified returns whether the command still has the value that it
+// was parsed with.
+func (p *Preserved) unmodified(cmd Cmd) bool {
+	cur := cmdWithPreserved(cmd, nil)
+	if c, ok := cur.(CmdCommit); ok && len(c.Merge) == 0 {
+		// nil and empty are the same.
+		c.Merge = []string{}
+		cur = c
+	}
+	orig := p.orig
+	if c, ok := orig.(CmdCommit); ok && len(c.Merge) == 0 {
+		c.Merge = []string{}
+		orig = c
+	}
+	return reflect.DeepEqual(cur, orig)
+}
@@ -0,0 +1,151 @@
+// Tests for preservation mode
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTrip reads every command from input in preservation mode,
+// passes it through edit, and writes it to a Backend.
+func roundTrip(t *testing.T, input string, edit func(Cmd) Cmd) string {
+	t.Helper()
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	frontend := NewFrontend(strings.NewReader(input), nil, nil)
+	frontend.SetPreserve(true)
+	for {
+		cmd, err := frontend.ReadCmd()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("ERROR: Failed to read cmd: %v\n", err)
+			}
+			break
+		}
+		if err := backend.Do(edit(cmd)); err != nil {
+			t.Errorf("ERROR: Failed to write cmd: %v\n", err)
+		}
+	}
+	bw.Flush()
+	return outbuf.String()
+}
+
+const preserveInput = `# leading comment
+
+blob
+mark :1
+data <<EOF
+hello
+EOF
+
+blob
+mark :2
+data 3
+abc
+commit refs/heads/main
+mark :3
+# comment in the header
+author Robert Cowham <rcowham@perforce.com> 01644399073 -0000
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data <<END
+message
+END
+M 644 :1 a.txt
+M 100644 inline "b.txt"
+data 3
+xyz
+
+M 100644 :2 c.txt
+
+
+tag v1
+from :3
+tagger Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 4
+tag
+commit refs/heads/main
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 6
+second
+# comment after the header
+D a.txt
+
+
+`
+
+func TestPreserve(t *testing.T) {
+	same := func(cmd Cmd) Cmd { return cmd }
+	assert.Equal(t, preserveInput, roundTrip(t, preserveInput, same))
+
+	// Modified commands are written afresh, keeping any blank
+	// lines before them; the rest are untouched.
+	output := roundTrip(t, preserveInput, func(cmd Cmd) Cmd {
+		switch c := cmd.(type) {
+		case FileModify:
+			if c.Path == "c.txt" {
+				c.Path = "d.txt"
+			}
+			return c
+		case CmdTag:
+			c.Data = "TAG\n"
+			return c
+		case FileModifyInline:
+			// Unmodified, but recreated.
+			return FileModifyInline{Mode: c.Mode, Path: c.Path, Data: c.Data}
+		}
+		return cmd
+	})
+	// The LF that ends the inline data (which doesn't end with
+	// LF itself) is written by FIWriter.WriteData, in place of
+	// the original optional LF.
+	expected := strings.Replace(preserveInput, "M 100644 inline \"b.txt\"\n", "M 100644 inline b.txt\n", 1)
+	expected = strings.Replace(expected, "M 100644 :2 c.txt\n", "M 100644 :2 d.txt\n", 1)
+	expected = strings.Replace(expected, "data 4\ntag\n", "data 4\nTAG\n", 1)
+	assert.Equal(t, expected, output)
+}
+
+func TestPreserveOptionalLF(t *testing.T) {
+	input := "blob\nmark :1\ndata 0\n\nblob\nmark :2\ndata 3\nabc\n\n\nprogress x\n"
+	// Both blobs are recreated, so their optional LFs are written
+	// by FIWriter.WriteData; the blank line after the second is
+	// kept.
+	output := roundTrip(t, input, func(cmd Cmd) Cmd {
+		if c, ok := cmd.(CmdBlob); ok {
+			return CmdBlob{Mark: c.Mark, Data: c.Data}
+		}
+		return cmd
+	})
+	assert.Equal(t, input, output)
+}
+
+func TestPreserveNestedAtEnd(t *testing.T) {
+	// The comment is in the "reset" command, which is dropped, so
+	// it is still waiting for it when the Backend is closed;
+	// Close writes it on its own.
+	input := "progress x\nreset refs/heads/main\n# in the reset\nfrom :1\n"
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	frontend := NewFrontend(strings.NewReader(input), nil, nil)
+	frontend.SetPreserve(true)
+	for {
+		cmd, err := frontend.ReadCmd()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		if _, isReset := cmd.(CmdReset); !isReset {
+			assert.NoError(t, backend.Do(cmd))
+		}
+	}
+	assert.NoError(t, backend.Close())
+	bw.Flush()
+	assert.Equal(t, "progress x\n# in the reset\n", outbuf.String())
+}
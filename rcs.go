@@ -0,0 +1,441 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rcsDelta is a revision in an RCS file.
+type rcsDelta struct {
+	rev      string
+	date     time.Time
+	author   string
+	state    string
+	branches []string // the first revision of each branch from here
+	next     string   // the previous revision on the trunk, or the next on a branch
+	commitID string   // set by CVS 1.12
+
+	log  string
+	text string // the full text of the head revision; a diff for others
+}
+
+// rcsFile is a parsed RCS file.
+type rcsFile struct {
+	head    string
+	symbols []rcsSymbol // in order
+	expand  string      // keyword expansion mode; "kv" if empty
+	deltas  map[string]*rcsDelta
+}
+
+type rcsSymbol struct {
+	name, rev string
+}
+
+// rcsLexer splits an RCS file into tokens: words (revision numbers,
+// identifiers, and keywords), ":", ";", and "@"-quoted strings.
+type rcsLexer struct {
+	data string
+	pos  int
+}
+
+// next returns the next token; it returns io.EOF at the end of the
+// data.
+func (l *rcsLexer) next() (tok string, isString bool, err error) {
+	for l.pos < len(l.data) && strings.IndexByte(" \t\n\r\v\f", l.data[l.pos]) >= 0 {
+		l.pos++
+	}
+	if l.pos == len(l.data) {
+		return "", false, io.EOF
+	}
+	switch l.data[l.pos] {
+	case ';', ':':
+		l.pos++
+		return l.data[l.pos-1 : l.pos], false, nil
+	case '@':
+		l.pos++
+		var b strings.Builder
+		for {
+			at := strings.IndexByte(l.data[l.pos:], '@')
+			if at < 0 {
+				return "", false, errors.New("rcs: unterminated string")
+			}
+			b.WriteString(l.data[l.pos : l.pos+at])
+			l.pos += at + 1
+			if l.pos < len(l.data) && l.data[l.pos] == '@' {
+				// "@@" is a literal "@".
+				b.WriteByte('@')
+				l.pos++
+				continue
+			}
+			return b.String(), true, nil
+		}
+	}
+	start := l.pos
+	for l.pos < len(l.data) && strings.IndexByte(" \t\n\r\v\f;:@", l.data[l.pos]) < 0 {
+		l.pos++
+	}
+	return l.data[start:l.pos], false, nil
+}
+
+// peek returns the next token, without consuming it.
+func (l *rcsLexer) peek() (string, error) {
+	pos := l.pos
+	tok, _, err := l.next()
+	l.pos = pos
+	return tok, err
+}
+
+// phrase reads the values of a phrase, up to its ";".
+func (l *rcsLexer) phrase() ([]string, error) {
+	var values []string
+	for {
+		tok, isString, err := l.next()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if tok == ";" && !isString {
+			return values, nil
+		}
+		values = append(values, tok)
+	}
+}
+
+// str reads a string.
+func (l *rcsLexer) str() (string, error) {
+	tok, isString, err := l.next()
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if !isString {
+		return "", errors.Errorf("rcs: expected a string, got %q", tok)
+	}
+	return tok, nil
+}
+
+func isRCSNum(tok string) bool {
+	return tok != "" && tok[0] >= '0' && tok[0] <= '9'
+}
+
+// parseRCSDate parses a date such as "2022.02.09.09.31.13"; years
+// before 2000 have 2 digits.
+func parseRCSDate(str string) (time.Time, error) {
+	parts := strings.Split(str, ".")
+	if len(parts) != 6 {
+		return time.Time{}, errors.Errorf("rcs: bad date %q", str)
+	}
+	var nums [6]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, errors.Errorf("rcs: bad date %q", str)
+		}
+		nums[i] = n
+	}
+	if nums[0] < 100 {
+		nums[0] += 1900
+	}
+	return time.Date(nums[0], time.Month(nums[1]), nums[2], nums[3], nums[4], nums[5], 0, time.UTC), nil
+}
+
+// parseRCS parses the content of an RCS file.
+func parseRCS(data string) (*rcsFile, error) {
+	l := &rcsLexer{data: data}
+	f := &rcsFile{deltas: make(map[string]*rcsDelta)}
+
+	// The admin section.
+	for {
+		tok, err := l.peek()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if isRCSNum(tok) || tok == "desc" {
+			break
+		}
+		l.next()
+		values, err := l.phrase()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "head":
+			if len(values) > 0 {
+				f.head = values[0]
+			}
+		case "symbols":
+			for i := 0; i+2 < len(values); i += 3 {
+				if values[i+1] != ":" {
+					return nil, errors.Errorf("rcs: bad symbols: %q", values)
+				}
+				f.symbols = append(f.symbols, rcsSymbol{name: values[i], rev: values[i+2]})
+			}
+		case "expand":
+			if len(values) > 0 {
+				f.expand = values[0]
+			}
+		}
+	}
+
+	// The delta section.
+	for {
+		rev, _, err := l.next()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if rev == "desc" {
+			break
+		}
+		d := &rcsDelta{rev: rev}
+		f.deltas[rev] = d
+		for {
+			tok, err := l.peek()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if isRCSNum(tok) || tok == "desc" {
+				break
+			}
+			l.next()
+			values, err := l.phrase()
+			if err != nil {
+				return nil, err
+			}
+			value := ""
+			if len(values) > 0 {
+				value = values[0]
+			}
+			switch tok {
+			case "date":
+				if d.date, err = parseRCSDate(value); err != nil {
+					return nil, err
+				}
+			case "author":
+				d.author = value
+			case "state":
+				d.state = value
+			case "branches":
+				d.branches = values
+			case "next":
+				d.next = value
+			case "commitid":
+				d.commitID = value
+			}
+		}
+	}
+	if _, err := l.str(); err != nil {
+		return nil, err
+	}
+
+	// The deltatext section.
+	for {
+		rev, _, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		d, ok := f.deltas[rev]
+		if !ok {
+			return nil, errors.Errorf("rcs: text of unknown revision %s", rev)
+		}
+		for {
+			tok, err := l.peek()
+			if err == io.EOF || isRCSNum(tok) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			l.next()
+			switch tok {
+			case "log":
+				d.log, err = l.str()
+			case "text":
+				d.text, err = l.str()
+			default:
+				_, err = l.phrase()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// splitLines splits text into lines, each with its "\n" (except
+// perhaps the last).
+func splitLines(text string) []string {
+	lines := make([]string, 0, strings.Count(text, "\n")+1)
+	for text != "" {
+		nl := strings.IndexByte(text, '\n')
+		if nl < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:nl+1])
+		text = text[nl+1:]
+	}
+	return lines
+}
+
+// applyRCSDiff applies an RCS diff (as from "diff -n") to lines: "dL
+// N" deletes N lines from line L, and "aL N" adds the N lines that
+// follow after line L, where lines are numbered from 1 in the
+// original.
+func applyRCSDiff(lines []string, diff string) ([]string, error) {
+	cmds := splitLines(diff)
+	out := make([]string, 0, len(lines))
+	pos := 0 // lines consumed
+	for i := 0; i < len(cmds); i++ {
+		cmd := strings.TrimSuffix(cmds[i], "\n")
+		var op byte
+		var line, count int
+		if _, err := fmt.Sscanf(cmd, "%c%d %d", &op, &line, &count); err != nil {
+			return nil, errors.Errorf("rcs: bad diff command %q", cmd)
+		}
+		if count < 0 {
+			return nil, errors.Errorf("rcs: bad diff command %q", cmd)
+		}
+		switch op {
+		case 'd':
+			if line < 1 || line-1 < pos || line-1+count > len(lines) {
+				return nil, errors.Errorf("rcs: diff command out of range: %q", cmd)
+			}
+			out = append(out, lines[pos:line-1]...)
+			pos = line - 1 + count
+		case 'a':
+			if line < pos || line > len(lines) || i+1+count > len(cmds) {
+				return nil, errors.Errorf("rcs: diff command out of range: %q", cmd)
+			}
+			out = append(out, lines[pos:line]...)
+			pos = line
+			out = append(out, cmds[i+1:i+1+count]...)
+			i += count
+		default:
+			return nil, errors.Errorf("rcs: bad diff command %q", cmd)
+		}
+	}
+	return append(out, lines[pos:]...), nil
+}
+
+// walk calls fn with the text of each revision, as stored.  A
+// revision is always visited before its branches.
+func (f *rcsFile) walk(fn func(d *rcsDelta, text string) error) error {
+	if f.head == "" {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var visit func(rev string, lines []string) error
+	visit = func(rev string, lines []string) error {
+		for rev != "" {
+			d, ok := f.deltas[rev]
+			if !ok {
+				return errors.Errorf("rcs: unknown revision %s", rev)
+			}
+			if seen[rev] {
+				return errors.Errorf("rcs: revision %s is in a loop", rev)
+			}
+			seen[rev] = true
+			if lines == nil {
+				// The head revision.
+				lines = splitLines(d.text)
+			}
+			if err := fn(d, strings.Join(lines, "")); err != nil {
+				return err
+			}
+			for _, branch := range d.branches {
+				b, ok := f.deltas[branch]
+				if !ok {
+					return errors.Errorf("rcs: unknown revision %s", branch)
+				}
+				blines, err := applyRCSDiff(lines, b.text)
+				if err != nil {
+					return errors.Wrap(err, branch)
+				}
+				if err := visit(branch, blines); err != nil {
+					return err
+				}
+			}
+			rev = d.next
+			if rev != "" {
+				nd, ok := f.deltas[rev]
+				if !ok {
+					return errors.Errorf("rcs: unknown revision %s", rev)
+				}
+				var err error
+				if lines, err = applyRCSDiff(lines, nd.text); err != nil {
+					return errors.Wrap(err, rev)
+				}
+			}
+		}
+		return nil
+	}
+	return visit(f.head, nil)
+}
+
+// rcsBranch returns the branch that a revision is on: "" for the
+// trunk, else "1.2.2" for "1.2.2.1".
+func rcsBranch(rev string) string {
+	if strings.Count(rev, ".") < 3 {
+		return ""
+	}
+	return rev[:strings.LastIndexByte(rev, '.')]
+}
+
+// rcsSymbolBranch returns the branch that a symbol is for, or "" if
+// it is for a revision: CVS has branch "1.2.2" as "1.2.0.2".
+func rcsSymbolBranch(rev string) string {
+	parts := strings.Split(rev, ".")
+	switch {
+	case len(parts)%2 == 1:
+		return rev
+	case len(parts) >= 4 && parts[len(parts)-2] == "0":
+		return strings.Join(append(parts[:len(parts)-2:len(parts)-2], parts[len(parts)-1]), ".")
+	}
+	return ""
+}
+
+var rcsKeywordRE = regexp.MustCompile(`\$(Author|CVSHeader|Date|Header|Id|Locker|Log|Name|RCSfile|Revision|Source|State)(?::[^$\n]*)?\$`)
+
+// expandKeywords expands the keywords (such as "$Id$") in text, as
+// checking it out in mode would: "kv" (or "kkv") as "$Id: value $",
+// "k" as "$Id$", "v" as "value", and "o" (or "b") as stored.  value
+// returns the value of a keyword.
+func expandKeywords(text, mode string, value func(keyword string) string) string {
+	switch mode {
+	case "o", "b":
+		return text
+	}
+	return rcsKeywordRE.ReplaceAllStringFunc(text, func(match string) string {
+		keyword := rcsKeywordRE.FindStringSubmatch(match)[1]
+		switch mode {
+		case "k":
+			return "$" + keyword + "$"
+		case "v":
+			return value(keyword)
+		}
+		return "$" + keyword + ": " + value(keyword) + " $"
+	})
+}
@@ -0,0 +1,316 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package remotehelper implements the protocol that git uses to talk
+// to remote helpers (see gitremote-helpers(7)), so that a helper only
+// has to implement the parts that are specific to its remote.
+//
+// Fetching is done with the "import" capability: the helper writes a
+// fast-import stream to a libfastimport.Backend.  Pushing is done
+// with the "export" capability: the helper reads the fast-export
+// stream that git sends from a libfastimport.Frontend.
+package remotehelper
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	libfastimport "github.com/rcowham/go-libgitfastimport"
+)
+
+// ErrUnsupported may be returned by Optioner.Option for an option
+// that the helper doesn't know.
+var ErrUnsupported = errors.New("unsupported")
+
+// A Ref is a ref in the remote, as listed by Helper.List.
+type Ref struct {
+	Name string
+	// OID is the SHA-1 that the ref points to; or empty, if it
+	// isn't known (for helpers that "import").
+	OID string
+	// Symref, if set, is the name of the ref that this ref is a
+	// symbolic reference to; OID is then ignored.
+	Symref string
+	// Attrs are any attributes of the ref, such as "unchanged".
+	Attrs []string
+}
+
+// An ExportResult is the outcome of pushing one ref, as returned by
+// Exporter.Export.
+type ExportResult struct {
+	Ref string
+	Err error // nil if the ref was updated
+}
+
+// A Helper is a remote helper.  It must also implement Importer,
+// Exporter, or both; the "import" and "export" capabilities are
+// advertised accordingly.
+type Helper interface {
+	// List returns the refs in the remote.  forPush is set if git
+	// is about to push.
+	List(forPush bool) ([]Ref, error)
+}
+
+// An Importer is a Helper that can fetch.
+type Importer interface {
+	// Import writes to b the commits of the given refs, as well
+	// as anything that they need.  The stream is ended (with
+	// "done") for it, when Import returns.
+	Import(refs []string, b *libfastimport.Backend) error
+}
+
+// An Exporter is a Helper that can push.
+type Exporter interface {
+	// Export reads from f the commands that git sends, and
+	// returns the outcome for each ref that was pushed.  Anything
+	// that it doesn't read is skipped.
+	Export(f *libfastimport.Frontend) ([]ExportResult, error)
+}
+
+// An Optioner is a Helper that accepts options, such as "verbosity"
+// or "dry-run".
+type Optioner interface {
+	// Option sets the named option.  It should return
+	// ErrUnsupported for options that it doesn't know.
+	Option(name, value string) error
+}
+
+// Options are the capabilities of a helper beyond those implied by
+// the interfaces it implements.
+type Options struct {
+	// Refspecs are the "refspec" capabilities; where the refs
+	// that are imported are written to, such as
+	// "refs/heads/*:refs/myvcs/origin/heads/*".
+	Refspecs []string
+	// ImportMarks and ExportMarks are the files that git's
+	// fast-import and fast-export should keep marks in.
+	ImportMarks string
+	ExportMarks string
+	// Capabilities are any other capabilities to advertise, such
+	// as "signed-tags".
+	Capabilities []string
+}
+
+type nopCloser struct {
+	io.Writer
+	closed bool
+}
+
+func (c *nopCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+type session struct {
+	h    Helper
+	opts Options
+	in   *bufio.Reader
+	out  *bufio.Writer
+}
+
+// Run talks to git, which writes commands to in and reads responses
+// from out, until git is finished.  Normally in and out are os.Stdin
+// and os.Stdout.
+func Run(h Helper, opts Options, in io.Reader, out io.Writer) error {
+	s := &session{
+		h:    h,
+		opts: opts,
+		in:   bufio.NewReader(in),
+		out:  bufio.NewWriter(out),
+	}
+	for {
+		line, err := s.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cmd := line
+		arg := ""
+		if sp := strings.IndexByte(line, ' '); sp >= 0 {
+			cmd, arg = line[:sp], line[sp+1:]
+		}
+		switch cmd {
+		case "capabilities":
+			err = s.capabilities()
+		case "list":
+			err = s.list(arg == "for-push")
+		case "option":
+			err = s.option(arg)
+		case "import":
+			err = s.doImport(arg)
+		case "export":
+			err = s.export()
+		default:
+			err = errors.Errorf("unknown command: %q", line)
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.out.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) readLine() (string, error) {
+	line, err := s.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+func (s *session) writeLine(line string) {
+	s.out.WriteString(line + "\n")
+}
+
+func (s *session) capabilities() error {
+	if _, ok := s.h.(Importer); ok {
+		s.writeLine("import")
+	}
+	if _, ok := s.h.(Exporter); ok {
+		s.writeLine("export")
+	}
+	if _, ok := s.h.(Optioner); ok {
+		s.writeLine("option")
+	}
+	for _, refspec := range s.opts.Refspecs {
+		s.writeLine("refspec " + refspec)
+	}
+	if s.opts.ImportMarks != "" {
+		s.writeLine("*import-marks " + s.opts.ImportMarks)
+	}
+	if s.opts.ExportMarks != "" {
+		s.writeLine("*export-marks " + s.opts.ExportMarks)
+	}
+	for _, capability := range s.opts.Capabilities {
+		s.writeLine(capability)
+	}
+	s.writeLine("")
+	return nil
+}
+
+func (s *session) list(forPush bool) error {
+	refs, err := s.h.List(forPush)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		value := ref.OID
+		switch {
+		case ref.Symref != "":
+			value = "@" + ref.Symref
+		case value == "":
+			value = "?"
+		}
+		line := value + " " + ref.Name
+		if len(ref.Attrs) > 0 {
+			line += " " + strings.Join(ref.Attrs, " ")
+		}
+		s.writeLine(line)
+	}
+	s.writeLine("")
+	return nil
+}
+
+func (s *session) option(arg string) error {
+	sp := strings.IndexByte(arg, ' ')
+	if sp < 0 {
+		return errors.Errorf("malformed option: %q", arg)
+	}
+	o, ok := s.h.(Optioner)
+	if !ok {
+		s.writeLine("unsupported")
+		return nil
+	}
+	switch err := o.Option(arg[:sp], arg[sp+1:]); err {
+	case nil:
+		s.writeLine("ok")
+	case ErrUnsupported:
+		s.writeLine("unsupported")
+	default:
+		s.writeLine("error " + err.Error())
+	}
+	return nil
+}
+
+// doImport handles a batch of "import" commands, which is ended by a
+// blank line.
+func (s *session) doImport(ref string) error {
+	imp, ok := s.h.(Importer)
+	if !ok {
+		return errors.New("import is not supported")
+	}
+	refs := []string{ref}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		if !strings.HasPrefix(line, "import ") {
+			return errors.Errorf("unexpected command in import batch: %q", line)
+		}
+		refs = append(refs, strings.TrimPrefix(line, "import "))
+	}
+
+	w := &nopCloser{Writer: s.out}
+	b := libfastimport.NewBackend(w, nil, nil)
+	if err := imp.Import(refs, b); err != nil {
+		return err
+	}
+	if !w.closed {
+		return b.Do(libfastimport.CmdDone{})
+	}
+	return b.Close()
+}
+
+// export handles an "export" command, which is followed by a
+// fast-export stream that is ended by "done".
+func (s *session) export() error {
+	exp, ok := s.h.(Exporter)
+	if !ok {
+		return errors.New("export is not supported")
+	}
+	f := libfastimport.NewFrontend(s.in, io.Discard, nil)
+	results, err := exp.Export(f)
+	if err != nil {
+		return err
+	}
+	// Skip whatever wasn't read, up to the "done".
+	for {
+		if _, err := f.ReadCmd(); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			s.writeLine("ok " + result.Ref)
+		} else {
+			s.writeLine("error " + result.Ref + " " + result.Err.Error())
+		}
+	}
+	s.writeLine("")
+	return nil
+}
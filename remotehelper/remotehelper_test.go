@@ -0,0 +1,163 @@
+// Tests for the remote helper protocol
+
+package remotehelper
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	libfastimport "github.com/rcowham/go-libgitfastimport"
+	"github.com/stretchr/testify/assert"
+)
+
+type testHelper struct {
+	options  map[string]string
+	imported []string
+	exported []libfastimport.Cmd
+}
+
+func (h *testHelper) List(forPush bool) ([]Ref, error) {
+	if forPush {
+		return []Ref{{Name: "refs/heads/main"}}, nil
+	}
+	return []Ref{
+		{Name: "HEAD", Symref: "refs/heads/main"},
+		{Name: "refs/heads/main"},
+		{Name: "refs/heads/old", OID: "0123456789012345678901234567890123456789", Attrs: []string{"unchanged"}},
+	}, nil
+}
+
+func (h *testHelper) Option(name, value string) error {
+	switch name {
+	case "verbosity":
+		h.options[name] = value
+		return nil
+	case "depth":
+		return errors.New("depth must be a number")
+	}
+	return ErrUnsupported
+}
+
+func (h *testHelper) Import(refs []string, b *libfastimport.Backend) error {
+	h.imported = append(h.imported, refs...)
+	for _, cmd := range []libfastimport.Cmd{
+		libfastimport.CmdBlob{Mark: 1, Data: "contents\n"},
+		libfastimport.CmdCommit{
+			Ref:       "refs/heads/main",
+			Mark:      2,
+			Committer: libfastimport.Ident{Name: "Robert Cowham", Email: "rcowham@perforce.com", Time: time.Unix(1644399073, 0).UTC()},
+			Msg:       "initial\n",
+		},
+		libfastimport.FileModify{Mode: 0100644, DataRef: ":1", Path: "a.txt"},
+		libfastimport.CmdCommitEnd{},
+	} {
+		if err := b.Do(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *testHelper) Export(f *libfastimport.Frontend) ([]ExportResult, error) {
+	// Only read the blob, to check that the rest is skipped.
+	cmd, err := f.ReadCmd()
+	if err != nil {
+		return nil, err
+	}
+	h.exported = append(h.exported, cmd)
+	return []ExportResult{
+		{Ref: "refs/heads/main"},
+		{Ref: "refs/heads/other", Err: errors.New("non-fast-forward")},
+	}, nil
+}
+
+// drive runs the helper with the given input from git, and returns
+// its responses.
+func drive(t *testing.T, h Helper, opts Options, input string) string {
+	t.Helper()
+	out := new(bytes.Buffer)
+	if err := Run(h, opts, strings.NewReader(input), out); err != nil {
+		t.Errorf("ERROR: Run failed: %v\n", err)
+	}
+	return out.String()
+}
+
+func TestRun(t *testing.T) {
+	h := &testHelper{options: make(map[string]string)}
+	opts := Options{
+		Refspecs:    []string{"refs/heads/*:refs/test/origin/heads/*"},
+		ExportMarks: "/tmp/marks",
+	}
+
+	output := drive(t, h, opts, `capabilities
+option verbosity 2
+option depth x
+option progress true
+list
+import refs/heads/main
+import HEAD
+
+export
+blob
+mark :1
+data 4
+new
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399074 +0000
+data 7
+change
+M 100644 :1 a.txt
+
+done
+list for-push
+
+list
+`)
+	assert.Equal(t, `import
+export
+option
+refspec refs/heads/*:refs/test/origin/heads/*
+*export-marks /tmp/marks
+
+ok
+error depth must be a number
+unsupported
+@refs/heads/main HEAD
+? refs/heads/main
+0123456789012345678901234567890123456789 refs/heads/old unchanged
+
+blob
+mark :1
+data 9
+contents
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 8
+initial
+M 100644 :1 a.txt
+
+done
+ok refs/heads/main
+error refs/heads/other non-fast-forward
+
+? refs/heads/main
+
+`, output)
+	assert.Equal(t, map[string]string{"verbosity": "2"}, h.options)
+	assert.Equal(t, []string{"refs/heads/main", "HEAD"}, h.imported)
+	assert.Equal(t, []libfastimport.Cmd{libfastimport.CmdBlob{Mark: 1, Data: "new\n"}}, h.exported)
+
+	// A helper that can only list.
+	err := Run(listOnly{}, Options{}, strings.NewReader("capabilities\nimport refs/heads/main\n\n"), io.Discard)
+	assert.NotNil(t, err)
+}
+
+type listOnly struct{}
+
+func (listOnly) List(forPush bool) ([]Ref, error) { return nil, nil }
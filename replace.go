@@ -0,0 +1,104 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultReplacement is what text matched by a ReplaceRule with no
+// explicit replacement is replaced with.
+const DefaultReplacement = "***REMOVED***"
+
+// A ReplaceRule replaces every match of Regexp with Replacement, which
+// may refer to submatches as in regexp.Regexp.ReplaceAllString.
+type ReplaceRule struct {
+	Regexp      *regexp.Regexp
+	Replacement string
+}
+
+// ReplaceRules is an ordered list of ReplaceRules; each rule is
+// applied to the result of the one before it.
+type ReplaceRules []ReplaceRule
+
+// ParseReplaceRules parses a list of rules, one per line, in the
+// same format as git-filter-repo's --replace-text:
+//
+//	literal text==>replacement
+//	literal:literal text==>replacement
+//	regex:pattern==>replacement with $1 submatches
+//	text to replace with ***REMOVED***
+//
+// Blank lines and lines starting with "#" are ignored.  The last
+// "==>" on a line separates the pattern from the replacement.
+func ParseReplaceRules(r io.Reader) (ReplaceRules, error) {
+	var ret ReplaceRules
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseReplaceRule(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineno)
+		}
+		ret = append(ret, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ParseReplaceRule parses a single line of the format accepted by
+// ParseReplaceRules.
+func ParseReplaceRule(line string) (ReplaceRule, error) {
+	pattern, replacement := line, DefaultReplacement
+	if i := strings.LastIndex(line, "==>"); i >= 0 {
+		pattern, replacement = line[:i], line[i+len("==>"):]
+	}
+	switch {
+	case strings.HasPrefix(pattern, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "regex:"))
+		if err != nil {
+			return ReplaceRule{}, err
+		}
+		return ReplaceRule{Regexp: re, Replacement: replacement}, nil
+	default:
+		pattern = strings.TrimPrefix(pattern, "literal:")
+		if pattern == "" {
+			return ReplaceRule{}, errors.New("empty pattern")
+		}
+		return ReplaceRule{
+			Regexp:      regexp.MustCompile(regexp.QuoteMeta(pattern)),
+			Replacement: strings.Replace(replacement, "$", "$$", -1),
+		}, nil
+	}
+}
+
+// Replace applies the rules to str.
+func (rules ReplaceRules) Replace(str string) string {
+	for _, rule := range rules {
+		str = rule.Regexp.ReplaceAllString(str, rule.Replacement)
+	}
+	return str
+}
@@ -0,0 +1,487 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Snapshot is one version of a tree, to be imported as a commit by
+// ImportSnapshots: a directory, or an archive.  An archive is a zip
+// file (".zip"), or a tar file (".tar"), which may be compressed
+// with gzip (".tar.gz", ".tgz") or bzip2 (".tar.bz2", ".tbz2").
+type Snapshot struct {
+	Path string
+
+	Ref       string // the branch to commit to; optional
+	Author    Ident
+	Committer Ident  // optional; the author if not set
+	Message   string // optional; "Imported from <name>" if not set
+	Tag       string // optional; the name of an annotated tag to create
+}
+
+// manifestEntry is an entry in a manifest; see ReadManifest.
+type manifestEntry struct {
+	Path      string `json:"path"`
+	Ref       string `json:"ref"`
+	Author    string `json:"author"`
+	Committer string `json:"committer"`
+	Date      string `json:"date"`
+	Message   string `json:"message"`
+	Tag       string `json:"tag"`
+}
+
+// parseNameEmail parses "Name <email>".
+func parseNameEmail(str string) (Ident, error) {
+	lt := strings.IndexByte(str, '<')
+	if lt < 0 || !strings.HasSuffix(str, ">") {
+		return Ident{}, errors.Errorf("expected \"Name <email>\": %q", str)
+	}
+	return Ident{
+		Name:  strings.TrimSpace(str[:lt]),
+		Email: str[lt+1 : len(str)-1],
+	}, nil
+}
+
+// ReadManifest reads a list of snapshots from a JSON manifest, which
+// is an array of objects like:
+//
+//	{
+//		"path": "releases/foo-1.0.tar.gz",
+//		"ref": "refs/heads/main",
+//		"author": "A U Thor <author@example.com>",
+//		"committer": "C O Mitter <committer@example.com>",
+//		"date": "2006-01-02T15:04:05-07:00",
+//		"message": "foo 1.0\n",
+//		"tag": "v1.0"
+//	}
+//
+// Only "path" and "author" are required.  The date (in RFC 3339
+// format) is that of both the author and the committer; if it isn't
+// given, the time that the newest file in the snapshot was modified
+// is used.  Relative paths are relative to dir.
+func ReadManifest(r io.Reader, dir string) ([]Snapshot, error) {
+	var entries []manifestEntry
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&entries); err != nil {
+		return nil, errors.Wrap(err, "manifest")
+	}
+	snapshots := make([]Snapshot, 0, len(entries))
+	for i, e := range entries {
+		if e.Path == "" {
+			return nil, errors.Errorf("manifest: entry %d has no path", i)
+		}
+		s := Snapshot{
+			Path:    e.Path,
+			Ref:     e.Ref,
+			Message: e.Message,
+			Tag:     e.Tag,
+		}
+		if !filepath.IsAbs(s.Path) {
+			s.Path = filepath.Join(dir, s.Path)
+		}
+		var when time.Time
+		if e.Date != "" {
+			var err error
+			if when, err = time.Parse(time.RFC3339, e.Date); err != nil {
+				return nil, errors.Wrapf(err, "manifest: entry %d", i)
+			}
+		}
+		var err error
+		if s.Author, err = parseNameEmail(e.Author); err != nil {
+			return nil, errors.Wrapf(err, "manifest: entry %d: author", i)
+		}
+		s.Author.Time = when
+		if e.Committer != "" {
+			if s.Committer, err = parseNameEmail(e.Committer); err != nil {
+				return nil, errors.Wrapf(err, "manifest: entry %d: committer", i)
+			}
+			s.Committer.Time = when
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+
+// SnapshotOptions are the options for ImportSnapshots.
+type SnapshotOptions struct {
+	// Ref is the branch to commit to, for snapshots that don't
+	// say; "refs/heads/main" if empty.
+	Ref string
+	// From is the commit-ish that the first commit on each branch
+	// is based on; optional.
+	From string
+	// StripTopDir sets whether a single top-level directory that
+	// every file in a snapshot is in (such as "foo-1.0/") is
+	// removed from their paths.
+	StripTopDir bool
+	// FirstMark is the first mark to use; 1 if < 1.
+	FirstMark int
+}
+
+// snapshotFile is a file in a snapshot.
+type snapshotFile struct {
+	mode Mode
+	mark int
+}
+
+// snapshotImporter holds the state of ImportSnapshots.
+type snapshotImporter struct {
+	b        *Backend
+	opts     SnapshotOptions
+	nextMark int
+	blobs    map[string]int // SHA-1 => mark
+	tips     map[string]int // ref => mark of last commit
+	trees    map[string]map[Path]snapshotFile
+}
+
+// ImportSnapshots writes to the Backend a commit for each snapshot,
+// in order, which changes the files of the previous commit on the
+// same branch to those of the snapshot.  Blobs are only written for
+// content that hasn't been seen before.  Empty directories are not
+// imported, as git does not track them.
+//
+// It returns the mark of each commit.
+func ImportSnapshots(b *Backend, snapshots []Snapshot, opts SnapshotOptions) ([]int, error) {
+	if opts.Ref == "" {
+		opts.Ref = "refs/heads/main"
+	}
+	if opts.FirstMark < 1 {
+		opts.FirstMark = 1
+	}
+	imp := &snapshotImporter{
+		b:        b,
+		opts:     opts,
+		nextMark: opts.FirstMark,
+		blobs:    make(map[string]int),
+		tips:     make(map[string]int),
+		trees:    make(map[string]map[Path]snapshotFile),
+	}
+	marks := make([]int, 0, len(snapshots))
+	for _, s := range snapshots {
+		mark, err := imp.importSnapshot(s)
+		if err != nil {
+			return marks, errors.Wrap(err, s.Path)
+		}
+		marks = append(marks, mark)
+	}
+	return marks, nil
+}
+
+func (imp *snapshotImporter) mark() int {
+	mark := imp.nextMark
+	imp.nextMark++
+	return mark
+}
+
+// add is called for each file in a snapshot.
+func (imp *snapshotImporter) add(files map[Path]snapshotFile, name string, mode Mode, data string) error {
+	name = path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "./"))
+	if checkPath(Path(name)) != nil {
+		return errors.Errorf("bad path in snapshot: %q", name)
+	}
+	sha1 := BlobSHA1(data)
+	mark, ok := imp.blobs[sha1]
+	if !ok {
+		mark = imp.mark()
+		if err := imp.b.Do(CmdBlob{Mark: mark, Data: data}); err != nil {
+			return err
+		}
+		imp.blobs[sha1] = mark
+	}
+	files[Path(name)] = snapshotFile{mode: mode, mark: mark}
+	return nil
+}
+
+func (imp *snapshotImporter) importSnapshot(s Snapshot) (int, error) {
+	files := make(map[Path]snapshotFile)
+	newest, err := imp.read(s.Path, files)
+	if err != nil {
+		return 0, err
+	}
+	if imp.opts.StripTopDir {
+		files = stripTopDir(files)
+	}
+
+	ref := s.Ref
+	if ref == "" {
+		ref = imp.opts.Ref
+	}
+	author := s.Author
+	if author.Time.IsZero() {
+		author.Time = newest
+	}
+	committer := s.Committer
+	if committer.Name == "" && committer.Email == "" {
+		committer = author
+	} else if committer.Time.IsZero() {
+		committer.Time = newest
+	}
+	msg := s.Message
+	if msg == "" {
+		msg = "Imported from " + filepath.Base(s.Path) + "\n"
+	}
+
+	commit := Commit{
+		Header: CmdCommit{
+			Ref:       ref,
+			Mark:      imp.mark(),
+			Author:    &author,
+			Committer: committer,
+			Msg:       msg,
+		},
+	}
+	if tip, ok := imp.tips[ref]; ok {
+		commit.Header.From = markRef(tip)
+	} else {
+		commit.Header.From = imp.opts.From
+	}
+
+	prev := imp.trees[ref]
+	paths := make([]string, 0, len(files)+len(prev))
+	for p := range prev {
+		if _, ok := files[p]; !ok {
+			paths = append(paths, string(p))
+		}
+	}
+	for p := range files {
+		paths = append(paths, string(p))
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		f, ok := files[Path(p)]
+		switch {
+		case !ok:
+			commit.Files = append(commit.Files, FileDelete{Path: Path(p)})
+		case f != prev[Path(p)]:
+			commit.Files = append(commit.Files, FileModify{Mode: f.mode, DataRef: markRef(f.mark), Path: Path(p)})
+		}
+	}
+
+	if err := imp.b.WriteCommit(commit); err != nil {
+		return 0, err
+	}
+	imp.trees[ref] = files
+	imp.tips[ref] = commit.Header.Mark
+
+	if s.Tag != "" {
+		err := imp.b.Do(CmdTag{
+			RefName:   s.Tag,
+			CommitIsh: markRef(commit.Header.Mark),
+			Tagger:    committer,
+			Data:      msg,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return commit.Header.Mark, nil
+}
+
+// stripTopDir removes the top-level directory that every file is
+// in, if there is one.
+func stripTopDir(files map[Path]snapshotFile) map[Path]snapshotFile {
+	var top string
+	for p := range files {
+		dir, rest := splitPath(p)
+		if rest == "" || (top != "" && dir != top) {
+			return files
+		}
+		top = dir
+	}
+	stripped := make(map[Path]snapshotFile, len(files))
+	for p, f := range files {
+		stripped[p[len(top)+1:]] = f
+	}
+	return stripped
+}
+
+// read adds each file in the snapshot at name, and returns the time
+// that the newest one was modified.
+func (imp *snapshotImporter) read(name string, files map[Path]snapshotFile) (time.Time, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if info.IsDir() {
+		return imp.readDir(name, files)
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return imp.readZip(name, files)
+	case strings.HasSuffix(lower, ".tar"),
+		strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"),
+		strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"):
+		return imp.readTar(name, files)
+	}
+	return time.Time{}, errors.New("not a directory, or a known type of archive")
+}
+
+func modeOf(mode os.FileMode) Mode {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return ModeSym
+	case mode&0111 != 0:
+		return ModeExe
+	}
+	return ModeFil
+}
+
+func (imp *snapshotImporter) readDir(dir string, files map[Path]snapshotFile) (time.Time, error) {
+	var newest time.Time
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		var data string
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			data, err = os.Readlink(name)
+		case info.Mode().IsRegular():
+			var bs []byte
+			bs, err = os.ReadFile(name)
+			data = string(bs)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return imp.add(files, rel, modeOf(info.Mode()), data)
+	})
+	return newest, err
+}
+
+func (imp *snapshotImporter) readTar(name string, files map[Path]snapshotFile) (time.Time, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, "gz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(lower, "bz2"):
+		r = bzip2.NewReader(file)
+	}
+
+	var newest time.Time
+	content := make(map[string]string) // for hard links
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		var data string
+		mode := modeOf(os.FileMode(hdr.Mode))
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			bs, err := io.ReadAll(tr)
+			if err != nil {
+				return time.Time{}, err
+			}
+			data = string(bs)
+			content[hdr.Name] = data
+		case tar.TypeSymlink:
+			data = hdr.Linkname
+			mode = ModeSym
+		case tar.TypeLink:
+			var ok bool
+			if data, ok = content[hdr.Linkname]; !ok {
+				return time.Time{}, errors.Errorf("hard link to unknown file: %q", hdr.Linkname)
+			}
+		default:
+			// Directories, devices, and the like.
+			continue
+		}
+		if hdr.ModTime.After(newest) {
+			newest = hdr.ModTime
+		}
+		if err := imp.add(files, hdr.Name, mode, data); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return newest, nil
+}
+
+func (imp *snapshotImporter) readZip(name string, files map[Path]snapshotFile) (time.Time, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer zr.Close()
+	var newest time.Time
+	for _, f := range zr.File {
+		if f.Mode().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return time.Time{}, err
+		}
+		bs, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if f.Modified.After(newest) {
+			newest = f.Modified
+		}
+		if err := imp.add(files, f.Name, modeOf(f.Mode()), string(bs)); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return newest, nil
+}
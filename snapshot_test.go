@@ -0,0 +1,170 @@
+// Tests for importing snapshots
+
+package libfastimport
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportSnapshots(t *testing.T) {
+	d := t.TempDir()
+	when := time.Date(2022, 2, 9, 9, 31, 13, 0, time.UTC)
+
+	// Version 1 is a directory.
+	v1 := filepath.Join(d, "foo-1.0")
+	assert.Nil(t, os.MkdirAll(filepath.Join(v1, "src"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(v1, "README"), []byte("foo\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(v1, "src", "main.c"), []byte("int main;\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(v1, "configure"), []byte("#!/bin/sh\n"), 0755))
+	assert.Nil(t, os.Mkdir(filepath.Join(v1, "empty"), 0755))
+
+	// Version 2 is a tarball, with everything in "foo-2.0/".
+	var tarbuf bytes.Buffer
+	gz := gzip.NewWriter(&tarbuf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name, data string
+		mode       int64
+		typ        byte
+	}{
+		{"foo-2.0/", "", 0755, tar.TypeDir},
+		{"foo-2.0/README", "foo\n", 0644, tar.TypeReg},
+		{"foo-2.0/src/main.c", "int main() {}\n", 0644, tar.TypeReg},
+		{"foo-2.0/configure", "#!/bin/sh\n", 0644, tar.TypeReg},
+		{"foo-2.0/README.txt", "README", 0777, tar.TypeSymlink},
+	} {
+		hdr := &tar.Header{Name: f.name, Mode: f.mode, Typeflag: f.typ, ModTime: when, Size: int64(len(f.data))}
+		if f.typ == tar.TypeSymlink {
+			hdr.Linkname, hdr.Size = f.data, 0
+		}
+		assert.Nil(t, tw.WriteHeader(hdr))
+		if f.typ == tar.TypeReg {
+			tw.Write([]byte(f.data))
+		}
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	assert.Nil(t, os.WriteFile(filepath.Join(d, "foo-2.0.tar.gz"), tarbuf.Bytes(), 0644))
+
+	// Version 3 is a zip file.
+	var zipbuf bytes.Buffer
+	zw := zip.NewWriter(&zipbuf)
+	for name, data := range map[string]string{"foo-3.0/README": "foo\n", "foo-3.0/src/main.c": "int main;\n"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Modified: when})
+		assert.Nil(t, err)
+		w.Write([]byte(data))
+	}
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, os.WriteFile(filepath.Join(d, "foo-3.0.zip"), zipbuf.Bytes(), 0644))
+
+	manifest := `[
+	{"path": "foo-1.0", "author": "Robert Cowham <rcowham@perforce.com>", "date": "2022-02-09T09:31:13Z", "message": "foo 1.0\n", "tag": "v1.0"},
+	{"path": "foo-2.0.tar.gz", "author": "Robert Cowham <rcowham@perforce.com>", "committer": "Someone Else <else@example.com>"},
+	{"path": "foo-3.0.zip", "author": "Robert Cowham <rcowham@perforce.com>", "date": "2022-02-09T10:31:13+01:00", "ref": "refs/heads/other"}
+]`
+	snapshots, err := ReadManifest(strings.NewReader(manifest), d)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(d, "foo-1.0"), snapshots[0].Path)
+
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	marks, err := ImportSnapshots(backend, snapshots, SnapshotOptions{StripTopDir: true, From: "refs/heads/main^0"})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4, 7, 8}, marks)
+	bw.Flush()
+	assert.Equal(t, `blob
+mark :1
+data 4
+foo
+blob
+mark :2
+data 10
+#!/bin/sh
+blob
+mark :3
+data 10
+int main;
+commit refs/heads/main
+mark :4
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 8
+foo 1.0
+from refs/heads/main^0
+M 100644 :1 README
+M 100755 :2 configure
+M 100644 :3 src/main.c
+
+tag v1.0
+from :4
+tagger Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 8
+foo 1.0
+blob
+mark :5
+data 14
+int main() {}
+blob
+mark :6
+data 6
+README
+commit refs/heads/main
+mark :7
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+committer Someone Else <else@example.com> 1644399073 +0000
+data 29
+Imported from foo-2.0.tar.gz
+from :4
+M 120000 :6 README.txt
+M 100644 :2 configure
+M 100644 :5 src/main.c
+
+commit refs/heads/other
+mark :8
+author Robert Cowham <rcowham@perforce.com> 1644399073 +0100
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0100
+data 26
+Imported from foo-3.0.zip
+from refs/heads/main^0
+M 100644 :1 README
+M 100644 :3 src/main.c
+
+`, outbuf.String())
+
+	_, err = ReadManifest(strings.NewReader(`[{"path": "x", "author": "nobody"}]`), d)
+	assert.NotNil(t, err)
+	_, err = ImportSnapshots(backend, []Snapshot{{Path: filepath.Join(d, "missing")}}, SnapshotOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestImportSnapshotsBadPath(t *testing.T) {
+	d := t.TempDir()
+	for _, name := range []string{"a/../..", "../a", "/a", "a/.git/config", ".GIT/hooks/post-checkout"} {
+		var tarbuf bytes.Buffer
+		tw := tar.NewWriter(&tarbuf)
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: 2}))
+		_, err := tw.Write([]byte("x\n"))
+		assert.Nil(t, err)
+		assert.Nil(t, tw.Close())
+		file := filepath.Join(d, "bad.tar")
+		assert.Nil(t, os.WriteFile(file, tarbuf.Bytes(), 0644))
+
+		backend := NewBackend(&MyWriteCloser{bufio.NewWriter(new(bytes.Buffer))}, nil, nil)
+		_, err = ImportSnapshots(backend, []Snapshot{{Path: file}}, SnapshotOptions{})
+		if assert.NotNil(t, err, name) {
+			assert.Contains(t, err.Error(), "bad path in snapshot", name)
+		}
+	}
+}
@@ -0,0 +1,598 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SVNLayout says where the trunk, branches and tags are in a
+// Subversion repository.  Each is a directory relative to the root of
+// the repository, or "" if there is none.
+type SVNLayout struct {
+	Trunk    string
+	Branches string
+	Tags     string
+}
+
+// StandardSVNLayout is the usual trunk/branches/tags layout.
+var StandardSVNLayout = SVNLayout{Trunk: "trunk", Branches: "branches", Tags: "tags"}
+
+// SVNOptions are the options for ConvertSVN.
+type SVNOptions struct {
+	// Layout maps the repository to git refs: the trunk to
+	// TrunkRef, each directory in Branches to refs/heads/<name>,
+	// and each directory in Tags to an annotated tag <name>.
+	// Files outside of these are not converted.  If Layout is the
+	// zero value, the whole repository is converted to TrunkRef.
+	Layout SVNLayout
+	// TrunkRef is "refs/heads/main" if empty.
+	TrunkRef string
+	// Authors maps Subversion user names to git identities (the
+	// time of which is ignored); see ReadAuthors.  A user that
+	// isn't in Authors is "<user> <<user>@<repository UUID>>",
+	// unless RequireAuthors is set, in which case it is an error.
+	Authors        map[string]Ident
+	RequireAuthors bool
+	// OriginalOIDs sets whether the original-oid of each commit is
+	// set to "r<revision>".
+	OriginalOIDs bool
+	// FirstMark is the first mark to use; 1 if < 1.
+	FirstMark int
+}
+
+// SVNCommit is a commit written by ConvertSVN.
+type SVNCommit struct {
+	Rev  int
+	Ref  string
+	Mark int
+}
+
+// SVNResult is the result of ConvertSVN.
+type SVNResult struct {
+	UUID    string
+	Commits []SVNCommit // in order
+	Skipped []string    // files that are outside of the layout
+}
+
+// svnTip is the state of a ref as of a revision.
+type svnTip struct {
+	rev  int
+	mark int   // 0 if the ref has no commit
+	tree *tree // the files of the commit
+}
+
+// svnCopy is a copy of a file or directory in a ref, from another
+// path and revision.
+type svnCopy struct {
+	ref      string
+	src, dst Path // relative to the root of the ref
+	rev      int
+	entry    treeEntry
+}
+
+// svnRefChange is what a revision does to a ref.
+type svnRefChange struct {
+	root   Path
+	from   *svnCopy // if the ref was created by copying another
+	copies []svnCopy
+}
+
+// svnConverter holds the state of ConvertSVN.
+type svnConverter struct {
+	b        *Backend
+	opts     SVNOptions
+	result   *SVNResult
+	nextMark int
+
+	content map[string]string   // ID => content, as Subversion has it
+	blobs   map[string]int      // git blob SHA-1 => mark
+	revs    []svnTip            // the whole repository as of each revision
+	repo    *tree               // the whole repository
+	history map[string][]svnTip // ref => its states, in order
+	roots   map[string]Path     // ref => its root
+	live    map[string]bool     // whether the root of a ref exists
+	skipped map[string]bool
+
+	rev      int
+	revProps map[string]string
+	touched  []string
+	changes  map[string]*svnRefChange
+}
+
+// ConvertSVN converts a Subversion dump file (as written by "svnadmin
+// dump", in format version 2, or 3 with deltas) to git, writing it to
+// the Backend.  A commit is written for each revision that changes a
+// branch or tag, on each that it changes, with files of the
+// svn:special property as symlinks and those of svn:executable as
+// executable.  A copy within a branch is written as a FileCopy, where
+// the source is unchanged, and a branch or tag that is a copy of
+// another starts from the commit that it was copied from.  Empty
+// directories, and other properties, are not converted, and a branch
+// that is deleted keeps its last commit.
+//
+// Every version of every file is kept in memory.
+func ConvertSVN(b *Backend, r io.Reader, opts SVNOptions) (*SVNResult, error) {
+	if opts.TrunkRef == "" {
+		opts.TrunkRef = "refs/heads/main"
+	}
+	if opts.FirstMark < 1 {
+		opts.FirstMark = 1
+	}
+	c := &svnConverter{
+		b:        b,
+		opts:     opts,
+		result:   &SVNResult{},
+		nextMark: opts.FirstMark,
+		content:  map[string]string{BlobSHA1(""): ""},
+		blobs:    make(map[string]int),
+		history:  make(map[string][]svnTip),
+		roots:    make(map[string]Path),
+		live:     make(map[string]bool),
+		skipped:  make(map[string]bool),
+		changes:  make(map[string]*svnRefChange),
+		rev:      -1,
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	for {
+		rec, err := readSVNRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c.result, err
+		}
+		switch {
+		case rec.header("SVN-fs-dump-format-version") != "":
+			version, err := rec.intHeader("SVN-fs-dump-format-version")
+			if err != nil {
+				return c.result, err
+			}
+			if version < 1 || version > 3 {
+				return c.result, errors.Errorf("svn dump: unsupported format version %d", version)
+			}
+		case rec.header("UUID") != "":
+			c.result.UUID = rec.header("UUID")
+		case rec.header("Revision-number") != "":
+			if err := c.endRevision(); err != nil {
+				return c.result, err
+			}
+			if c.rev, err = rec.intHeader("Revision-number"); err != nil {
+				return c.result, err
+			}
+			c.revProps = rec.props
+		case rec.header("Node-path") != "" || rec.header("Node-action") != "":
+			if c.rev < 0 {
+				return c.result, errors.New("svn dump: node before the first revision")
+			}
+			if err := c.node(rec); err != nil {
+				return c.result, errors.Wrapf(err, "r%d: %s", c.rev, rec.header("Node-path"))
+			}
+		}
+	}
+	if err := c.endRevision(); err != nil {
+		return c.result, err
+	}
+	return c.result, nil
+}
+
+func (c *svnConverter) mark() int {
+	mark := c.nextMark
+	c.nextMark++
+	return mark
+}
+
+// refOf returns the ref that a path is in, and the root of the ref.
+func (c *svnConverter) refOf(path Path) (string, Path, bool) {
+	layout := c.opts.Layout
+	if layout == (SVNLayout{}) {
+		return c.opts.TrunkRef, "", true
+	}
+	under := func(dir string) (string, bool) {
+		if dir == "" || !strings.HasPrefix(string(path), dir+"/") {
+			return "", false
+		}
+		name, _ := splitPath(path[len(dir)+1:])
+		return name, true
+	}
+	if layout.Trunk != "" && (string(path) == layout.Trunk || strings.HasPrefix(string(path), layout.Trunk+"/")) {
+		return c.opts.TrunkRef, Path(layout.Trunk), true
+	}
+	if name, ok := under(layout.Branches); ok {
+		return "refs/heads/" + name, Path(layout.Branches + "/" + name), true
+	}
+	if name, ok := under(layout.Tags); ok {
+		return "refs/tags/" + name, Path(layout.Tags + "/" + name), true
+	}
+	return "", "", false
+}
+
+// relPath returns path relative to root.
+func relPath(root, path Path) Path {
+	if root == "" {
+		return path
+	}
+	return Path(strings.TrimPrefix(strings.TrimPrefix(string(path), string(root)), "/"))
+}
+
+// treeAt returns the whole repository as of a revision.
+func (c *svnConverter) treeAt(rev int) *tree {
+	i := sort.Search(len(c.revs), func(i int) bool { return c.revs[i].rev > rev })
+	if i == 0 {
+		return nil
+	}
+	return c.revs[i-1].tree
+}
+
+// tipAt returns the state of a ref as of a revision.
+func (c *svnConverter) tipAt(ref string, rev int) svnTip {
+	history := c.history[ref]
+	i := sort.Search(len(history), func(i int) bool { return history[i].rev > rev })
+	if i == 0 {
+		return svnTip{}
+	}
+	return history[i-1]
+}
+
+func (c *svnConverter) touch(ref string, root Path) *svnRefChange {
+	ch, ok := c.changes[ref]
+	if !ok {
+		ch = &svnRefChange{root: root}
+		c.changes[ref] = ch
+		c.touched = append(c.touched, ref)
+		c.roots[ref] = root
+	}
+	return ch
+}
+
+// node applies a node record to the repository.
+func (c *svnConverter) node(rec *svnRecord) error {
+	path := Path(strings.Trim(rec.header("Node-path"), "/"))
+	kind := rec.header("Node-kind")
+	action := rec.header("Node-action")
+	ref, root, inRef := c.refOf(path)
+	if inRef {
+		c.touch(ref, root)
+	}
+
+	switch action {
+	case "delete":
+		c.repo = c.repo.remove(path)
+		// Deleting a directory (such as "branches") that refs are
+		// in changes those refs too.
+		for _, other := range c.refs() {
+			if path == "" || strings.HasPrefix(string(c.roots[other])+"/", string(path)+"/") {
+				c.touch(other, c.roots[other])
+				c.live[other] = false
+			}
+		}
+		return nil
+	case "replace":
+		c.repo = c.repo.remove(path)
+	case "add", "change":
+	default:
+		return errors.Errorf("unknown Node-action %q", action)
+	}
+	if inRef && path == root {
+		c.live[ref] = true
+	}
+
+	var base treeEntry
+	if rec.header("Node-copyfrom-path") != "" {
+		srcPath := Path(strings.Trim(rec.header("Node-copyfrom-path"), "/"))
+		srcRev, err := rec.intHeader("Node-copyfrom-rev")
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if base, ok = c.treeAt(srcRev).get(srcPath); !ok {
+			if kind != "dir" {
+				return errors.Errorf("copy source not found: %s@%d", srcPath, srcRev)
+			}
+		}
+		if srcRef, srcRoot, ok := c.refOf(srcPath); ok && inRef {
+			cp := svnCopy{ref: srcRef, src: relPath(srcRoot, srcPath), dst: relPath(root, path), rev: srcRev, entry: base}
+			switch {
+			case path == root && srcPath == srcRoot:
+				c.changes[ref].from = &cp
+			case srcRef == ref:
+				c.changes[ref].copies = append(c.changes[ref].copies, cp)
+			}
+		}
+	} else if action == "change" {
+		base, _ = c.repo.get(path)
+	}
+	if kind == "" && base.Dir != nil {
+		kind = "dir"
+	}
+
+	if kind == "dir" {
+		if base.Dir != nil {
+			c.repo = c.repo.set(path, base)
+		}
+		return nil
+	}
+	if !inRef && !c.skipped[string(path)] {
+		c.skipped[string(path)] = true
+		c.result.Skipped = append(c.result.Skipped, string(path))
+	}
+
+	content := c.content[base.ID]
+	mode := base.Mode
+	if mode == 0 || base.Dir != nil {
+		mode = ModeFil
+	}
+	if rec.hasText {
+		if rec.header("Text-delta") == "true" {
+			var err error
+			if content, err = applySVNDiff(content, rec.text); err != nil {
+				return err
+			}
+		} else {
+			content = rec.text
+		}
+		if want := rec.header("Text-content-md5"); want != "" {
+			if sum := md5.Sum([]byte(content)); hex.EncodeToString(sum[:]) != want {
+				return errors.Errorf("checksum mismatch: got %x, expected %s", sum, want)
+			}
+		}
+	}
+	if rec.hasProps {
+		mode = svnMode(mode, rec)
+	}
+	id := BlobSHA1(content)
+	c.content[id] = content
+	c.repo = c.repo.set(path, treeEntry{Mode: mode, ID: id})
+	return nil
+}
+
+// svnMode returns the mode of a file, given its previous mode and
+// the properties of a node record.
+func svnMode(mode Mode, rec *svnRecord) Mode {
+	exe, special := mode == ModeExe, mode == ModeSym
+	if rec.header("Prop-delta") != "true" {
+		exe, special = false, false
+	}
+	_, exe2 := rec.props["svn:executable"]
+	_, special2 := rec.props["svn:special"]
+	exe, special = exe || exe2, special || special2
+	for _, name := range rec.deletedProps {
+		switch name {
+		case "svn:executable":
+			exe = false
+		case "svn:special":
+			special = false
+		}
+	}
+	switch {
+	case special:
+		return ModeSym
+	case exe:
+		return ModeExe
+	}
+	return ModeFil
+}
+
+// refs returns the refs that have been seen, in order.
+func (c *svnConverter) refs() []string {
+	refs := make([]string, 0, len(c.roots))
+	for ref := range c.roots {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// endRevision writes the commits for the revision that has been read.
+func (c *svnConverter) endRevision() error {
+	if c.rev < 0 {
+		return nil
+	}
+	c.revs = append(c.revs, svnTip{rev: c.rev, tree: c.repo})
+	for _, ref := range c.touched {
+		if err := c.commit(ref, c.changes[ref]); err != nil {
+			return errors.Wrapf(err, "r%d: %s", c.rev, ref)
+		}
+	}
+	c.touched = nil
+	c.changes = make(map[string]*svnRefChange)
+	return nil
+}
+
+// commit writes the commit (or reset, or tag) for what the revision
+// did to a ref.
+func (c *svnConverter) commit(ref string, ch *svnRefChange) error {
+	tip := c.tipAt(ref, c.rev)
+	if ch.root != "" && !c.live[ref] {
+		// The ref has been deleted.
+		if tip.mark != 0 {
+			c.history[ref] = append(c.history[ref], svnTip{rev: c.rev})
+		}
+		return nil
+	}
+	parent, base := tip.mark, tip.tree
+	if ch.from != nil {
+		from := c.tipAt(ch.from.ref, ch.from.rev)
+		parent, base = from.mark, from.tree
+	}
+
+	// Copies from files that are unchanged are FileCopy commands;
+	// the changes from the resulting tree are FileModify and
+	// FileDelete commands.
+	cur := base
+	var files []FileChange
+	var dsts []Path
+	for _, cp := range ch.copies {
+		e, ok := cur.get(cp.src)
+		if !ok || e != cp.entry || cp.src == "" || cp.dst == "" || underAny(cp.src, dsts) {
+			continue
+		}
+		files = append(files, FileCopy{Src: cp.src, Dst: cp.dst})
+		cur = cur.set(cp.dst, e)
+		dsts = append(dsts, cp.dst)
+	}
+	e, _ := c.repo.get(ch.root)
+	var err error
+	diffTrees(cur, e.Dir, func(path Path, a, b treeEntry) {
+		if err != nil {
+			return
+		}
+		if b.Mode == 0 {
+			files = append(files, FileDelete{Path: path})
+			return
+		}
+		var dataref string
+		if dataref, err = c.blobRef(b); err == nil {
+			files = append(files, FileModify{Mode: b.Mode, DataRef: dataref, Path: path})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	ident, err := c.ident()
+	if err != nil {
+		return err
+	}
+	tag := ""
+	if strings.HasPrefix(ref, "refs/tags/") {
+		tag = strings.TrimPrefix(ref, "refs/tags/")
+	}
+	if len(files) == 0 {
+		// A copy of another ref, without changes, is a new
+		// branch (or tag) at the same commit.
+		if ch.from == nil || parent == 0 {
+			return nil
+		}
+		c.history[ref] = append(c.history[ref], svnTip{rev: c.rev, mark: parent, tree: base})
+		if tag != "" {
+			return c.tag(tag, parent, ident)
+		}
+		return c.b.Do(CmdReset{RefName: ref, CommitIsh: markRef(parent)})
+	}
+
+	if parent == 0 && len(c.history[ref]) > 0 {
+		// Start the ref again, rather than from its last commit.
+		if err := c.b.Do(CmdReset{RefName: ref}); err != nil {
+			return err
+		}
+	}
+	mark := c.mark()
+	commit := Commit{
+		Header: CmdCommit{
+			Ref:       ref,
+			Mark:      mark,
+			Author:    &ident,
+			Committer: ident,
+			Msg:       c.revProps["svn:log"],
+		},
+		Files: files,
+	}
+	if parent != 0 {
+		commit.Header.From = markRef(parent)
+	}
+	if c.opts.OriginalOIDs {
+		commit.Header.OriginalOID = fmt.Sprintf("r%d", c.rev)
+	}
+	if err := c.b.WriteCommit(commit); err != nil {
+		return err
+	}
+	c.history[ref] = append(c.history[ref], svnTip{rev: c.rev, mark: mark, tree: e.Dir})
+	c.result.Commits = append(c.result.Commits, SVNCommit{Rev: c.rev, Ref: ref, Mark: mark})
+	if tag != "" {
+		return c.tag(tag, mark, ident)
+	}
+	return nil
+}
+
+// underAny returns whether path is any of dirs, or in any of them.
+func underAny(path Path, dirs []Path) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(string(path), string(dir)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *svnConverter) tag(name string, mark int, tagger Ident) error {
+	tag := CmdTag{
+		RefName:   name,
+		CommitIsh: markRef(mark),
+		Tagger:    tagger,
+		Data:      c.revProps["svn:log"],
+	}
+	if c.opts.OriginalOIDs {
+		tag.OriginalOID = fmt.Sprintf("r%d", c.rev)
+	}
+	return c.b.Do(tag)
+}
+
+// ident returns the author of the revision.
+func (c *svnConverter) ident() (Ident, error) {
+	when := time.Unix(0, 0).UTC()
+	if date := c.revProps["svn:date"]; date != "" {
+		var err error
+		if when, err = time.Parse(time.RFC3339Nano, date); err != nil {
+			return Ident{}, errors.Wrap(err, "svn:date")
+		}
+	}
+	user := c.revProps["svn:author"]
+	if user == "" {
+		user = "(no author)"
+	}
+	ident, ok := c.opts.Authors[user]
+	if !ok {
+		if c.opts.RequireAuthors {
+			return Ident{}, errors.Errorf("unknown author %q", user)
+		}
+		ident = Ident{Name: user, Email: user + "@" + c.result.UUID}
+	}
+	ident.Time = when
+	return ident, nil
+}
+
+// blobRef returns the mark of the blob of a file, writing the blob if
+// it hasn't been written before.  Subversion has the content of a
+// symlink as "link <target>".
+func (c *svnConverter) blobRef(e treeEntry) (string, error) {
+	data := c.content[e.ID]
+	if e.Mode == ModeSym {
+		data = strings.TrimPrefix(data, "link ")
+	}
+	sha1 := BlobSHA1(data)
+	mark, ok := c.blobs[sha1]
+	if !ok {
+		mark = c.mark()
+		if err := c.b.Do(CmdBlob{Mark: mark, Data: data}); err != nil {
+			return "", err
+		}
+		c.blobs[sha1] = mark
+	}
+	return markRef(mark), nil
+}
@@ -0,0 +1,273 @@
+// Tests for converting Subversion dump files
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// svnRec formats a record of a dump file, with props (if not empty)
+// and text (if given).
+func svnRec(headers string, props string, text ...string) string {
+	var b strings.Builder
+	b.WriteString(headers)
+	if props != "" {
+		fmt.Fprintf(&b, "Prop-content-length: %d\n", len(props))
+	}
+	if len(text) > 0 {
+		fmt.Fprintf(&b, "Text-content-length: %d\n", len(text[0]))
+	}
+	if props != "" || len(text) > 0 {
+		n := len(props)
+		if len(text) > 0 {
+			n += len(text[0])
+		}
+		fmt.Fprintf(&b, "Content-length: %d\n", n)
+	}
+	b.WriteString("\n")
+	b.WriteString(props)
+	if len(text) > 0 {
+		b.WriteString(text[0])
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// svnProps formats a properties block of key, value pairs.
+func svnProps(deleted []string, pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i < len(pairs); i += 2 {
+		fmt.Fprintf(&b, "K %d\n%s\nV %d\n%s\n", len(pairs[i]), pairs[i], len(pairs[i+1]), pairs[i+1])
+	}
+	for _, key := range deleted {
+		fmt.Fprintf(&b, "D %d\n%s\n", len(key), key)
+	}
+	b.WriteString("PROPS-END\n")
+	return b.String()
+}
+
+func svnRev(rev int, author, log string) string {
+	date := fmt.Sprintf("2022-02-09T09:31:%02d.123456Z", 13+rev)
+	return svnRec(fmt.Sprintf("Revision-number: %d\n", rev),
+		svnProps(nil, "svn:author", author, "svn:date", date, "svn:log", log))
+}
+
+func TestApplySVNDiff(t *testing.T) {
+	// Copy from the source, then new data.
+	got, err := applySVNDiff("hello\n", "SVN\x00\x00\x06\x0c\x03\x07\x05\x00\x87 world\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world\n", got)
+
+	// An overlapping copy from the target.
+	got, err = applySVNDiff("", "SVN\x00\x00\x00\x08\x03\x02\x82\x46\x00ab")
+	assert.Nil(t, err)
+	assert.Equal(t, "abababab", got)
+
+	// Version 1, with uncompressed instructions and compressed
+	// new data.
+	newData := strings.Repeat("x", 200)
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte(newData))
+	zw.Close()
+	section := "\x81\x48" + z.String() // 200 as a varint
+	delta := fmt.Sprintf("SVN\x01\x00\x00\x81\x48\x04%c", len(section)) + "\x03\x80\x81\x48" + section
+	got, err = applySVNDiff("", delta)
+	assert.Nil(t, err)
+	assert.Equal(t, newData, got)
+
+	for _, delta := range []string{
+		"",
+		"SVN\x02",
+		"SVN\x00\x00\x06\x0c\x03\x07\x05\x00\x87 world",   // truncated
+		"SVN\x00\x00\x07\x0c\x03\x07\x05\x00\x87 world\n", // source view too long
+		"SVN\x00\x00\x06\x0d\x03\x07\x05\x00\x87 world\n", // target view too long
+	} {
+		_, err := applySVNDiff("hello\n", delta)
+		assert.NotNil(t, err, "%q", delta)
+	}
+}
+
+func TestConvertSVN(t *testing.T) {
+	dump := "SVN-fs-dump-format-version: 3\n\nUUID: 0123-4567\n\n" +
+		svnRec("Revision-number: 0\n", svnProps(nil, "svn:date", "2022-02-09T09:31:13.000000Z")) +
+		svnRev(1, "alice", "layout\n") +
+		svnRec("Node-path: trunk\nNode-kind: dir\nNode-action: add\n", "") +
+		svnRec("Node-path: branches\nNode-kind: dir\nNode-action: add\n", "") +
+		svnRec("Node-path: tags\nNode-kind: dir\nNode-action: add\n", "") +
+		svnRev(2, "alice", "initial\n") +
+		svnRec("Node-path: trunk/hello.txt\nNode-kind: file\nNode-action: add\n", svnProps(nil), "hello\n") +
+		svnRec("Node-path: trunk/run.sh\nNode-kind: file\nNode-action: add\n", svnProps(nil, "svn:executable", "*"), "#!/bin/sh\n") +
+		svnRec("Node-path: trunk/link\nNode-kind: file\nNode-action: add\n", svnProps(nil, "svn:special", "*"), "link hello.txt") +
+		svnRec("Node-path: README\nNode-kind: file\nNode-action: add\n", "", "outside\n") +
+		svnRev(3, "alice", "change\n") +
+		svnRec("Node-path: trunk/hello.txt\nNode-kind: file\nNode-action: change\nText-delta: true\nText-content-md5: 6f5902ac237024bdd0c176cb93063dc4\n", "",
+			"SVN\x00\x00\x06\x0c\x03\x07\x05\x00\x87 world\n") +
+		svnRec("Node-path: trunk/copy.txt\nNode-kind: file\nNode-action: add\nNode-copyfrom-rev: 2\nNode-copyfrom-path: trunk/hello.txt\n", "") +
+		svnRec("Node-path: trunk/run.sh\nNode-kind: file\nNode-action: change\nProp-delta: true\n", svnProps([]string{"svn:executable"})) +
+		svnRev(4, "alice", "branch\n") +
+		svnRec("Node-path: branches/dev\nNode-kind: dir\nNode-action: add\nNode-copyfrom-rev: 3\nNode-copyfrom-path: trunk\n", "") +
+		svnRev(5, "bob", "on dev\n") +
+		svnRec("Node-path: branches/dev/hello.txt\nNode-kind: file\nNode-action: change\n", "", "dev\n") +
+		svnRev(6, "alice", "tag v1\n") +
+		svnRec("Node-path: tags/v1\nNode-kind: dir\nNode-action: add\nNode-copyfrom-rev: 3\nNode-copyfrom-path: trunk\n", "") +
+		svnRev(7, "alice", "delete\n") +
+		svnRec("Node-path: trunk/copy.txt\nNode-action: delete\n", "") +
+		svnRec("Node-path: trunk/link\nNode-action: delete\n", "") +
+		svnRev(8, "alice", "delete dev\n") +
+		svnRec("Node-path: branches/dev\nNode-action: delete\n", "")
+
+	authors, err := ReadAuthors(strings.NewReader("# Authors\nalice = Alice Liddell <alice@example.com>\n\n"))
+	assert.Nil(t, err)
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	backend := NewBackend(&MyWriteCloser{bw}, nil, nil)
+	result, err := ConvertSVN(backend, strings.NewReader(dump), SVNOptions{
+		Layout:       StandardSVNLayout,
+		Authors:      authors,
+		OriginalOIDs: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "0123-4567", result.UUID)
+	assert.Equal(t, []SVNCommit{
+		{Rev: 2, Ref: "refs/heads/main", Mark: 4},
+		{Rev: 3, Ref: "refs/heads/main", Mark: 6},
+		{Rev: 5, Ref: "refs/heads/dev", Mark: 8},
+		{Rev: 7, Ref: "refs/heads/main", Mark: 9},
+	}, result.Commits)
+	assert.Equal(t, []string{"README"}, result.Skipped)
+	bw.Flush()
+	assert.Equal(t, `blob
+mark :1
+data 6
+hello
+blob
+mark :2
+data 9
+hello.txt
+blob
+mark :3
+data 10
+#!/bin/sh
+commit refs/heads/main
+mark :4
+original-oid r2
+author Alice Liddell <alice@example.com> 1644399075 +0000
+committer Alice Liddell <alice@example.com> 1644399075 +0000
+data 8
+initial
+M 100644 :1 hello.txt
+M 120000 :2 link
+M 100755 :3 run.sh
+
+blob
+mark :5
+data 12
+hello world
+commit refs/heads/main
+mark :6
+original-oid r3
+author Alice Liddell <alice@example.com> 1644399076 +0000
+committer Alice Liddell <alice@example.com> 1644399076 +0000
+data 7
+change
+from :4
+C hello.txt copy.txt
+M 100644 :5 hello.txt
+M 100644 :3 run.sh
+
+reset refs/heads/dev
+from :6
+blob
+mark :7
+data 4
+dev
+commit refs/heads/dev
+mark :8
+original-oid r5
+author bob <bob@0123-4567> 1644399078 +0000
+committer bob <bob@0123-4567> 1644399078 +0000
+data 7
+on dev
+from :6
+M 100644 :7 hello.txt
+
+tag v1
+from :6
+original-oid r6
+tagger Alice Liddell <alice@example.com> 1644399079 +0000
+data 7
+tag v1
+commit refs/heads/main
+mark :9
+original-oid r7
+author Alice Liddell <alice@example.com> 1644399080 +0000
+committer Alice Liddell <alice@example.com> 1644399080 +0000
+data 7
+delete
+from :6
+D copy.txt
+D link
+
+`, outbuf.String())
+
+	// Errors.
+	_, err = ConvertSVN(backend, strings.NewReader(dump), SVNOptions{Layout: StandardSVNLayout, RequireAuthors: true})
+	assert.NotNil(t, err)
+	_, err = ConvertSVN(backend, strings.NewReader("SVN-fs-dump-format-version: 4\n\n"), SVNOptions{})
+	assert.NotNil(t, err)
+	bad := strings.Replace(dump, "6f5902ac", "00000000", 1)
+	_, err = ConvertSVN(backend, strings.NewReader(bad), SVNOptions{Layout: StandardSVNLayout})
+	assert.NotNil(t, err)
+}
+
+func TestConvertSVNNoLayout(t *testing.T) {
+	dump := "SVN-fs-dump-format-version: 2\n\n" +
+		svnRev(1, "alice", "add\n") +
+		svnRec("Node-path: dir\nNode-kind: dir\nNode-action: add\n", svnProps(nil, "svn:ignore", "*.o\n")) +
+		svnRec("Node-path: dir/a.txt\nNode-kind: file\nNode-action: add\n", svnProps(nil), "a\n") +
+		svnRev(2, "alice", "copy\n") +
+		svnRec("Node-path: other\nNode-kind: dir\nNode-action: add\nNode-copyfrom-rev: 1\nNode-copyfrom-path: dir\n", "") +
+		svnRec("Node-path: dir/a.txt\nNode-kind: file\nNode-action: replace\n", svnProps(nil), "b\n")
+
+	outbuf := new(bytes.Buffer)
+	bw := bufio.NewWriter(outbuf)
+	result, err := ConvertSVN(NewBackend(&MyWriteCloser{bw}, nil, nil), strings.NewReader(dump), SVNOptions{FirstMark: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, []SVNCommit{{Rev: 1, Ref: "refs/heads/main", Mark: 11}, {Rev: 2, Ref: "refs/heads/main", Mark: 13}}, result.Commits)
+	bw.Flush()
+	assert.Equal(t, `blob
+mark :10
+data 2
+a
+commit refs/heads/main
+mark :11
+author alice <alice@> 1644399074 +0000
+committer alice <alice@> 1644399074 +0000
+data 4
+add
+M 100644 :10 dir/a.txt
+
+blob
+mark :12
+data 2
+b
+commit refs/heads/main
+mark :13
+author alice <alice@> 1644399075 +0000
+committer alice <alice@> 1644399075 +0000
+data 5
+copy
+from :11
+C dir other
+M 100644 :12 dir/a.txt
+
+`, outbuf.String())
+}
@@ -0,0 +1,325 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// svnRecord is a record in a Subversion dump file: a block of
+// headers, then optionally properties and text.
+type svnRecord struct {
+	headers map[string]string
+
+	hasProps     bool
+	props        map[string]string
+	deletedProps []string // with Prop-delta (format version 3)
+
+	hasText bool
+	text    string
+}
+
+func (rec *svnRecord) header(name string) string {
+	return rec.headers[name]
+}
+
+func (rec *svnRecord) intHeader(name string) (int, error) {
+	str, ok := rec.headers[name]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, errors.Errorf("svn dump: bad %s: %q", name, str)
+	}
+	return n, nil
+}
+
+// readSVNRecord reads the next record; it returns io.EOF at the end
+// of the dump.
+func readSVNRecord(r *bufio.Reader) (*svnRecord, error) {
+	rec := &svnRecord{headers: make(map[string]string)}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && len(rec.headers) == 0 {
+				return nil, io.EOF
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(rec.headers) == 0 {
+				// Blank lines between records.
+				continue
+			}
+			break
+		}
+		colon := strings.Index(line, ": ")
+		if colon < 0 {
+			return nil, errors.Errorf("svn dump: bad header line: %q", line)
+		}
+		rec.headers[line[:colon]] = line[colon+2:]
+	}
+
+	propLen, err := rec.intHeader("Prop-content-length")
+	if err != nil {
+		return nil, err
+	}
+	textLen, err := rec.intHeader("Text-content-length")
+	if err != nil {
+		return nil, err
+	}
+	contentLen, err := rec.intHeader("Content-length")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := rec.headers["Prop-content-length"]; ok {
+		buf := make([]byte, propLen)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		rec.hasProps = true
+		if rec.props, rec.deletedProps, err = parseSVNProps(string(buf)); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := rec.headers["Text-content-length"]; ok {
+		buf := make([]byte, textLen)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		rec.hasText = true
+		rec.text = string(buf)
+	}
+	if extra := contentLen - propLen - textLen; extra > 0 {
+		if _, err := r.Discard(extra); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return rec, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseSVNProps parses a properties block:
+//
+//	K <len>
+//	<key>
+//	V <len>
+//	<value>
+//	D <len>
+//	<deleted key>
+//	PROPS-END
+func parseSVNProps(block string) (props map[string]string, deleted []string, err error) {
+	props = make(map[string]string)
+	// readField reads "<prefix> <len>\n<len bytes>\n".
+	readField := func(prefix byte) (string, error) {
+		nl := strings.IndexByte(block, '\n')
+		if nl < 2 || block[0] != prefix || block[1] != ' ' {
+			return "", errors.Errorf("svn dump: bad property block at %q", block)
+		}
+		n, err := strconv.Atoi(block[2:nl])
+		if err != nil || nl+1+n+1 > len(block) || block[nl+1+n] != '\n' {
+			return "", errors.Errorf("svn dump: bad property length at %q", block)
+		}
+		field := block[nl+1 : nl+1+n]
+		block = block[nl+1+n+1:]
+		return field, nil
+	}
+	for {
+		switch {
+		case strings.HasPrefix(block, "PROPS-END\n") || block == "PROPS-END":
+			return props, deleted, nil
+		case strings.HasPrefix(block, "K "):
+			key, err := readField('K')
+			if err != nil {
+				return nil, nil, err
+			}
+			value, err := readField('V')
+			if err != nil {
+				return nil, nil, err
+			}
+			props[key] = value
+		case strings.HasPrefix(block, "D "):
+			key, err := readField('D')
+			if err != nil {
+				return nil, nil, err
+			}
+			deleted = append(deleted, key)
+		default:
+			return nil, nil, errors.Errorf("svn dump: bad property block at %q", block)
+		}
+	}
+}
+
+// svndiff ////////////////////////////////////////////////////////////////////
+
+// svndiffReader reads the parts of an svndiff delta.
+type svndiffReader struct {
+	data string
+	err  error
+}
+
+func (d *svndiffReader) varint() int {
+	n := 0
+	for {
+		if d.err != nil {
+			return 0
+		}
+		if d.data == "" {
+			d.err = errors.New("svndiff: truncated")
+			return 0
+		}
+		b := d.data[0]
+		d.data = d.data[1:]
+		n = n<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			return n
+		}
+	}
+}
+
+func (d *svndiffReader) bytes(n int) string {
+	if d.err != nil {
+		return ""
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errors.New("svndiff: truncated")
+		return ""
+	}
+	ret := d.data[:n]
+	d.data = d.data[n:]
+	return ret
+}
+
+// section reads a section of a window; in svndiff1, sections may be
+// compressed with zlib.
+func (d *svndiffReader) section(n int, version byte) string {
+	data := d.bytes(n)
+	if version == 0 || d.err != nil {
+		return data
+	}
+	sub := &svndiffReader{data: data}
+	size := sub.varint()
+	if sub.err != nil {
+		d.err = sub.err
+		return ""
+	}
+	if len(sub.data) == size {
+		return sub.data
+	}
+	zr, err := zlib.NewReader(strings.NewReader(sub.data))
+	if err != nil {
+		d.err = errors.Wrap(err, "svndiff")
+		return ""
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		d.err = errors.Wrap(err, "svndiff")
+		return ""
+	}
+	return string(buf)
+}
+
+// applySVNDiff applies an svndiff (version 0 or 1) delta to source.
+func applySVNDiff(source string, delta string) (string, error) {
+	if len(delta) < 4 || delta[:3] != "SVN" {
+		return "", errors.New("svndiff: bad header")
+	}
+	version := delta[3]
+	if version > 1 {
+		return "", errors.Errorf("svndiff: unsupported version %d", version)
+	}
+	d := &svndiffReader{data: delta[4:]}
+	var out bytes.Buffer
+	for d.data != "" && d.err == nil {
+		sviewOffset := d.varint()
+		sviewLen := d.varint()
+		tviewLen := d.varint()
+		insLen := d.varint()
+		newLen := d.varint()
+		ins := d.section(insLen, version)
+		newData := d.section(newLen, version)
+		if d.err != nil {
+			break
+		}
+		if sviewOffset+sviewLen > len(source) {
+			return "", errors.New("svndiff: source view out of range")
+		}
+		sview := source[sviewOffset : sviewOffset+sviewLen]
+
+		tview := make([]byte, 0, tviewLen)
+		insReader := &svndiffReader{data: ins}
+		for insReader.data != "" && insReader.err == nil {
+			b := insReader.bytes(1)[0]
+			n := int(b & 0x3f)
+			if n == 0 {
+				n = insReader.varint()
+			}
+			switch b >> 6 {
+			case 0: // copy from the source view
+				offset := insReader.varint()
+				if offset+n > len(sview) {
+					return "", errors.New("svndiff: source copy out of range")
+				}
+				tview = append(tview, sview[offset:offset+n]...)
+			case 1: // copy from the target view, which may overlap
+				offset := insReader.varint()
+				if offset >= len(tview) {
+					return "", errors.New("svndiff: target copy out of range")
+				}
+				for i := 0; i < n; i++ {
+					tview = append(tview, tview[offset+i])
+				}
+			case 2: // copy from the new data
+				if n > len(newData) {
+					return "", errors.New("svndiff: new data out of range")
+				}
+				tview = append(tview, newData[:n]...)
+				newData = newData[n:]
+			default:
+				return "", errors.New("svndiff: bad instruction")
+			}
+		}
+		if insReader.err != nil {
+			return "", insReader.err
+		}
+		if len(tview) != tviewLen {
+			return "", errors.New("svndiff: target view has the wrong length")
+		}
+		out.Write(tview)
+	}
+	if d.err != nil {
+		return "", d.err
+	}
+	return out.String(), nil
+}
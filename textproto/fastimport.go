@@ -27,11 +27,17 @@ import (
 type FIReader struct {
 	r *bufio.Reader
 
-	line *string
-	err  error
+	line    *string
+	err     error
+	skipped string
+
+	// scratch is reused for reading large data.
+	scratch []byte
 }
 
-// NewFIReader creates a new FIReader parser.
+// NewFIReader creates a new FIReader parser.  If r is a *bufio.Reader
+// then it is used as-is, so nothing is read from r that isn't part of
+// a line that is returned, beyond what is already buffered.
 func NewFIReader(r io.Reader) *FIReader {
 	return &FIReader{
 		r: bufio.NewReader(r),
@@ -41,8 +47,12 @@ func NewFIReader(r io.Reader) *FIReader {
 // ReadLine reads a "line" from the stream; with special handling for
 // the "data" command, which isn't really a single line, but rather
 // contains arbitrary binary data.
+//
+// Blank lines before the line are skipped; see Skipped.
 func (fir *FIReader) ReadLine() (line string, err error) {
+	fir.skipped = ""
 	for len(line) <= 1 {
+		fir.skipped += line
 		line, err = fir.r.ReadString('\n')
 		if err != nil {
 			return
@@ -50,19 +60,11 @@ func (fir *FIReader) ReadLine() (line string, err error) {
 	}
 
 	if strings.HasPrefix(line, "data ") {
+		var b strings.Builder
 		if line[5:7] == "<<" {
 			// Delimited format
 			delim := line[7 : len(line)-1]
-			suffix := "\n" + delim + "\n"
-
-			for !strings.HasSuffix(line, suffix) {
-				var _line string
-				_line, err = fir.r.ReadString('\n')
-				line += _line
-				if err != nil {
-					return
-				}
-			}
+			err = fir.readDelimited(&b, line, "\n"+delim+"\n")
 		} else {
 			// Exact byte count format
 			var size int
@@ -70,17 +72,88 @@ func (fir *FIReader) ReadLine() (line string, err error) {
 			if err != nil {
 				return
 			}
-			data := make([]byte, size)
-			_, err = io.ReadFull(fir.r, data)
-			line += string(data)
+			b.Grow(len(line) + size)
+			b.WriteString(line)
+			err = fir.readCounted(&b, size)
 		}
+		line = b.String()
 	}
 	return
 }
 
+// readDelimited appends lines to b, which has the "data" line in it,
+// until it ends with suffix.
+func (fir *FIReader) readDelimited(b *strings.Builder, head string, suffix string) error {
+	b.WriteString(head)
+	for !strings.HasSuffix(b.String(), suffix) {
+		chunk, err := fir.r.ReadSlice('\n')
+		b.Write(chunk)
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCounted appends exactly size bytes to b.  Once what is buffered
+// has been used up, large reads bypass the bufio.Reader's small
+// buffer, and are read in bigger chunks into a scratch buffer
+// instead.  Either way, each byte is copied twice: once into a
+// buffer, and then into b.
+func (fir *FIReader) readCounted(b *strings.Builder, size int) error {
+	for size > 0 {
+		if n := fir.r.Buffered(); n > 0 {
+			if n > size {
+				n = size
+			}
+			chunk, _ := fir.r.Peek(n)
+			b.Write(chunk)
+			fir.r.Discard(n)
+			size -= n
+			continue
+		}
+		if size < fir.r.Size() {
+			// Fill the buffer.
+			if _, err := fir.r.Peek(1); err != nil {
+				return unexpectedEOF(err)
+			}
+			continue
+		}
+		if fir.scratch == nil {
+			fir.scratch = make([]byte, 64*1024)
+		}
+		buf := fir.scratch
+		if len(buf) > size {
+			buf = buf[:size]
+		}
+		n, err := fir.r.Read(buf)
+		b.Write(buf[:n])
+		size -= n
+		if err != nil && size > 0 {
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Skipped returns the blank lines that the most recent call to
+// ReadLine skipped before the line that it returned.
+func (fir *FIReader) Skipped() string {
+	return fir.skipped
+}
+
 // FIWriter is a low-level marshaller of a fast-import stream.
 type FIWriter struct {
 	w io.Writer
+
+	delimitText bool
 }
 
 // NewFIWriter creates a new FIWriter marshaller.
@@ -90,6 +163,15 @@ func NewFIWriter(w io.Writer) *FIWriter {
 	}
 }
 
+// SetDelimitText sets whether WriteData writes text in the delimited
+// format ("data <<EOF"), which is easier for humans to read, rather
+// than the exact byte count format.  Data is text if it is not empty,
+// contains no NUL bytes, and ends with LF (the delimited format
+// cannot express data that doesn't).
+func (fiw *FIWriter) SetDelimitText(delimitText bool) {
+	fiw.delimitText = delimitText
+}
+
 // WriteLine writes an ordinary line to the stream; arguments are
 // handled similarly to fmt.Println.
 func (fiw *FIWriter) WriteLine(a ...interface{}) error {
@@ -98,14 +180,42 @@ func (fiw *FIWriter) WriteLine(a ...interface{}) error {
 }
 
 // WriteData writes a 'data' command to the stream.
+//
+// In the exact byte count format, if the data does not end with LF,
+// then an LF is written after it.  That LF is not part of the data;
+// it is the optional LF that the format allows after the data, so
+// that the next command starts on a line of its own.
 func (fiw *FIWriter) WriteData(data string) error {
+	if fiw.delimitText && isText(data) {
+		delim := Delimiter(data)
+		_, err := io.WriteString(fiw.w, "data <<"+delim+"\n"+data+delim+"\n")
+		return err
+	}
 	err := fiw.WriteLine("data", len(data))
 	if err != nil {
 		return err
 	}
 	_, err = io.WriteString(fiw.w, data)
-	if err == nil && data[len(data)-1] != '\n' {
+	if err == nil && !strings.HasSuffix(data, "\n") {
 		_, err = io.WriteString(fiw.w, "\n")
 	}
 	return err
 }
+
+func isText(data string) bool {
+	return strings.HasSuffix(data, "\n") && strings.IndexByte(data, 0) < 0
+}
+
+// Delimiter returns a delimiter for writing data in the delimited
+// format: one that does not occur as a line of its own in data.
+func Delimiter(data string) string {
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		lines[line] = true
+	}
+	delim := "EOF"
+	for i := 1; lines[delim]; i++ {
+		delim = "EOF" + strconv.Itoa(i)
+	}
+	return delim
+}
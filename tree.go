@@ -0,0 +1,310 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"sort"
+	"strings"
+)
+
+// treeEntry is an entry in a tree: either a subdirectory, or a file
+// (or symlink, or gitlink) identified by its mode and an ID for its
+// content.
+type treeEntry struct {
+	Mode Mode
+	ID   string // blob SHA-1 (or other dataref) of a file
+	Dir  *tree  // non-nil for a subdirectory
+}
+
+// tree is an immutable directory tree.  Modifying a tree returns a new
+// tree that shares every unmodified subdirectory with the old one, so
+// that the tree of every commit in a stream may be kept cheaply.  A
+// nil *tree is empty.
+type tree struct {
+	entries map[string]treeEntry
+}
+
+func splitPath(path Path) (string, Path) {
+	if i := strings.IndexByte(string(path), '/'); i >= 0 {
+		return string(path[:i]), path[i+1:]
+	}
+	return string(path), ""
+}
+
+func (t *tree) clone() *tree {
+	ret := &tree{entries: make(map[string]treeEntry)}
+	if t != nil {
+		for name, e := range t.entries {
+			ret.entries[name] = e
+		}
+	}
+	return ret
+}
+
+func (t *tree) empty() bool {
+	return t == nil || len(t.entries) == 0
+}
+
+// get returns the entry at path.
+func (t *tree) get(path Path) (treeEntry, bool) {
+	if path == "" {
+		return treeEntry{Mode: ModeDir, Dir: t}, true
+	}
+	for t != nil {
+		name, rest := splitPath(path)
+		e, ok := t.entries[name]
+		if !ok {
+			return treeEntry{}, false
+		}
+		if rest == "" {
+			return e, true
+		}
+		t, path = e.Dir, rest
+	}
+	return treeEntry{}, false
+}
+
+// set returns a tree with the entry at path set to e, creating (or
+// replacing files with) directories as necessary.
+func (t *tree) set(path Path, e treeEntry) *tree {
+	if path == "" {
+		if e.Dir == nil {
+			return t
+		}
+		return e.Dir
+	}
+	name, rest := splitPath(path)
+	ret := t.clone()
+	if rest == "" {
+		ret.entries[name] = e
+	} else {
+		ret.entries[name] = treeEntry{Mode: ModeDir, Dir: ret.entries[name].Dir.set(rest, e)}
+	}
+	return ret
+}
+
+// remove returns a tree without the entry at path; directories that
+// become empty are removed too, as git would.  If there is no entry at
+// path, t itself is returned.
+func (t *tree) remove(path Path) *tree {
+	if path == "" {
+		return nil
+	}
+	if t == nil {
+		return nil
+	}
+	name, rest := splitPath(path)
+	e, ok := t.entries[name]
+	if !ok {
+		return t
+	}
+	ret := t.clone()
+	if rest == "" {
+		delete(ret.entries, name)
+	} else {
+		if e.Dir == nil {
+			return t
+		}
+		sub := e.Dir.remove(rest)
+		if sub == e.Dir {
+			return t
+		}
+		if sub.empty() {
+			delete(ret.entries, name)
+		} else {
+			ret.entries[name] = treeEntry{Mode: ModeDir, Dir: sub}
+		}
+	}
+	return ret
+}
+
+func (t *tree) names() []string {
+	if t == nil {
+		return nil
+	}
+	names := make([]string, 0, len(t.entries))
+	for name := range t.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// walk calls fn for every file in the tree, in order of path.
+func (t *tree) walk(fn func(path Path, e treeEntry) error) error {
+	return t.walkIn("", fn)
+}
+
+// walkIn is walk for a subdirectory.  Its prefix is "" for the root,
+// and the subdirectory's path followed by "/" otherwise, so that a
+// directory named "" (from a path such as "/a" or "a//b") isn't lost;
+// likewise for diffTreesIn.
+func (t *tree) walkIn(prefix Path, fn func(path Path, e treeEntry) error) error {
+	for _, name := range t.names() {
+		e := t.entries[name]
+		path := prefix + Path(name)
+		var err error
+		if e.Dir != nil {
+			err = e.Dir.walkIn(path+"/", fn)
+		} else {
+			err = fn(path, e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffTrees calls fn for every path at which a file differs between
+// a and b, in order of path.  An absent file is a zero treeEntry.
+func diffTrees(a, b *tree, fn func(path Path, a, b treeEntry)) {
+	diffTreesIn("", a, b, fn)
+}
+
+func diffTreesIn(prefix Path, a, b *tree, fn func(path Path, a, b treeEntry)) {
+	if a == b {
+		return
+	}
+	names := a.names()
+	for _, name := range b.names() {
+		if _, ok := a.get(Path(name)); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var ea, eb treeEntry
+		if a != nil {
+			ea = a.entries[name]
+		}
+		if b != nil {
+			eb = b.entries[name]
+		}
+		path := prefix + Path(name)
+		switch {
+		case ea.Dir != nil || eb.Dir != nil:
+			// A file replaced by a directory (or vice versa) is
+			// reported as the file being removed (or added),
+			// along with everything in the directory.
+			if ea.Dir == nil && ea.Mode != 0 {
+				fn(path, ea, treeEntry{})
+			}
+			diffTreesIn(path+"/", ea.Dir, eb.Dir, fn)
+			if eb.Dir == nil && eb.Mode != 0 {
+				fn(path, treeEntry{}, eb)
+			}
+		case ea != eb:
+			fn(path, ea, eb)
+		}
+	}
+}
+
+// treeReplay reconstructs the tree of each commit in a stream by
+// applying the file commands of each commit to the tree of its first
+// parent.
+//
+// Files are identified by the SHA-1 of their content where it is
+// known, and by their dataref otherwise.  Notes are not part of the
+// tree.  The tree of a parent that was not defined in the stream is
+// not known, and is taken to be empty.
+type treeReplay struct {
+	blobs   map[int]string   // blob mark => ID
+	commits map[string]*tree // commit mark or original-oid => tree
+	tips    map[string]*tree // branch => tree
+
+	commit *CmdCommit // the commit in progress
+	cur    *tree      // its tree
+}
+
+func newTreeReplay() *treeReplay {
+	return &treeReplay{
+		blobs:   make(map[int]string),
+		commits: make(map[string]*tree),
+		tips:    make(map[string]*tree),
+	}
+}
+
+// commitTree returns the tree of a commit-ish.
+func (r *treeReplay) commitTree(commitish string) *tree {
+	commitish = strings.TrimSuffix(commitish, "^0")
+	if t, ok := r.tips[commitish]; ok {
+		return t
+	}
+	return r.commits[commitish]
+}
+
+func (r *treeReplay) blobID(dataref string) string {
+	if id, ok := r.blobs[parseMarkRef(dataref)]; ok {
+		return id
+	}
+	return dataref
+}
+
+// Do applies the command.  Once a CmdCommitEnd has been applied, the
+// tree of the commit is available as r.cur until the next command.
+func (r *treeReplay) Do(cmd Cmd) {
+	switch c := cmd.(type) {
+	case CmdBlob:
+		if c.Mark > 0 {
+			r.blobs[c.Mark] = BlobSHA1(c.Data)
+		}
+	case CmdCommit:
+		r.commit = &c
+		if c.From != "" {
+			r.cur = r.commitTree(c.From)
+		} else {
+			r.cur = r.tips[c.Ref]
+		}
+	case CmdCommitEnd:
+		if r.commit.Mark > 0 {
+			r.commits[markRef(r.commit.Mark)] = r.cur
+		}
+		if r.commit.OriginalOID != "" {
+			r.commits[r.commit.OriginalOID] = r.cur
+		}
+		r.tips[r.commit.Ref] = r.cur
+		r.commit = nil
+	case CmdReset:
+		if c.CommitIsh == "" {
+			delete(r.tips, c.RefName)
+		} else {
+			r.tips[c.RefName] = r.commitTree(c.CommitIsh)
+		}
+	case CmdAlias:
+		if id, ok := r.blobs[parseMarkRef(c.CommitIsh)]; ok {
+			r.blobs[c.Mark] = id
+		} else if t, ok := r.commits[c.CommitIsh]; ok {
+			r.commits[markRef(c.Mark)] = t
+		}
+	case FileModify:
+		r.cur = r.cur.set(c.Path, treeEntry{Mode: c.Mode, ID: r.blobID(c.DataRef)})
+	case FileModifyInline:
+		r.cur = r.cur.set(c.Path, treeEntry{Mode: c.Mode, ID: BlobSHA1(c.Data)})
+	case FileDelete:
+		r.cur = r.cur.remove(c.Path)
+	case FileCopy:
+		if e, ok := r.cur.get(c.Src); ok {
+			r.cur = r.cur.set(c.Dst, e)
+		}
+	case FileRename:
+		if e, ok := r.cur.get(c.Src); ok {
+			r.cur = r.cur.remove(c.Src).set(c.Dst, e)
+		}
+	case FileDeleteAll:
+		r.cur = nil
+	}
+}
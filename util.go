@@ -48,13 +48,18 @@ func parse_data(line string) (data string, err error) {
 		return "", errors.Errorf("data: could not parse: %q", data)
 	}
 	if strings.HasPrefix(head, "data <<") {
-		// Delimited format
+		// Delimited format; the LF before the delimiter is
+		// part of the data.
 		delim := trimLinePrefix(head, "data <<")
 		suffix := "\n" + delim + "\n"
-		if !strings.HasSuffix(rest, suffix) {
+		switch {
+		case rest == delim+"\n":
+			data = ""
+		case strings.HasSuffix(rest, suffix):
+			data = strings.TrimSuffix(rest, delim+"\n")
+		default:
 			return "", errors.Errorf("data: did not find suffix: %q", suffix)
 		}
-		data = strings.TrimSuffix(rest, suffix)
 	} else {
 		// Exact byte count format
 		size, err := strconv.Atoi(trimLinePrefix(head, "data "))
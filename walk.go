@@ -0,0 +1,186 @@
+// Copyright (C) 2026  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libfastimport
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// StopWalk may be returned by a Visitor method to stop Walk early,
+// without Walk returning an error.
+var StopWalk = errors.New("stop walking")
+
+// A Visitor has a method for each type of command, which Walk calls.
+// Embed NopVisitor to only have to implement the methods for the
+// commands that are of interest.
+//
+// If a method returns an error, Walk stops, and returns it; unless it
+// is StopWalk, in which case Walk returns nil.
+type Visitor interface {
+	Blob(CmdBlob) error
+	Commit(CmdCommit) error
+	CommitEnd() error
+	Tag(CmdTag) error
+	Reset(CmdReset) error
+	Alias(CmdAlias) error
+	Checkpoint(CmdCheckpoint) error
+	Progress(CmdProgress) error
+	Feature(CmdFeature) error
+	Option(CmdOption) error
+	Done(CmdDone) error
+
+	Comment(CmdComment) error
+	GetMark(CmdGetMark) error
+	CatBlob(CmdCatBlob) error
+	Ls(CmdLs) error
+
+	FileModify(FileModify) error
+	FileModifyInline(FileModifyInline) error
+	FileDelete(FileDelete) error
+	FileCopy(FileCopy) error
+	FileRename(FileRename) error
+	FileDeleteAll(FileDeleteAll) error
+	NoteModify(NoteModify) error
+	NoteModifyInline(NoteModifyInline) error
+}
+
+// NopVisitor is a Visitor that does nothing.
+type NopVisitor struct{}
+
+func (NopVisitor) Blob(CmdBlob) error                      { return nil }
+func (NopVisitor) Commit(CmdCommit) error                  { return nil }
+func (NopVisitor) CommitEnd() error                        { return nil }
+func (NopVisitor) Tag(CmdTag) error                        { return nil }
+func (NopVisitor) Reset(CmdReset) error                    { return nil }
+func (NopVisitor) Alias(CmdAlias) error                    { return nil }
+func (NopVisitor) Checkpoint(CmdCheckpoint) error          { return nil }
+func (NopVisitor) Progress(CmdProgress) error              { return nil }
+func (NopVisitor) Feature(CmdFeature) error                { return nil }
+func (NopVisitor) Option(CmdOption) error                  { return nil }
+func (NopVisitor) Done(CmdDone) error                      { return nil }
+func (NopVisitor) Comment(CmdComment) error                { return nil }
+func (NopVisitor) GetMark(CmdGetMark) error                { return nil }
+func (NopVisitor) CatBlob(CmdCatBlob) error                { return nil }
+func (NopVisitor) Ls(CmdLs) error                          { return nil }
+func (NopVisitor) FileModify(FileModify) error             { return nil }
+func (NopVisitor) FileModifyInline(FileModifyInline) error { return nil }
+func (NopVisitor) FileDelete(FileDelete) error             { return nil }
+func (NopVisitor) FileCopy(FileCopy) error                 { return nil }
+func (NopVisitor) FileRename(FileRename) error             { return nil }
+func (NopVisitor) FileDeleteAll(FileDeleteAll) error       { return nil }
+func (NopVisitor) NoteModify(NoteModify) error             { return nil }
+func (NopVisitor) NoteModifyInline(NoteModifyInline) error { return nil }
+
+// Visit calls the method of v for the type of cmd.
+func Visit(v Visitor, cmd Cmd) error {
+	switch cmd := cmd.(type) {
+	case CmdBlob:
+		return v.Blob(cmd)
+	case CmdCommit:
+		return v.Commit(cmd)
+	case CmdCommitEnd:
+		return v.CommitEnd()
+	case CmdTag:
+		return v.Tag(cmd)
+	case CmdReset:
+		return v.Reset(cmd)
+	case CmdAlias:
+		return v.Alias(cmd)
+	case CmdCheckpoint:
+		return v.Checkpoint(cmd)
+	case CmdProgress:
+		return v.Progress(cmd)
+	case CmdFeature:
+		return v.Feature(cmd)
+	case CmdOption:
+		return v.Option(cmd)
+	case CmdDone:
+		return v.Done(cmd)
+	case CmdComment:
+		return v.Comment(cmd)
+	case CmdGetMark:
+		return v.GetMark(cmd)
+	case CmdCatBlob:
+		return v.CatBlob(cmd)
+	case CmdLs:
+		return v.Ls(cmd)
+	case FileModify:
+		return v.FileModify(cmd)
+	case FileModifyInline:
+		return v.FileModifyInline(cmd)
+	case FileDelete:
+		return v.FileDelete(cmd)
+	case FileCopy:
+		return v.FileCopy(cmd)
+	case FileRename:
+		return v.FileRename(cmd)
+	case FileDeleteAll:
+		return v.FileDeleteAll(cmd)
+	case NoteModify:
+		return v.NoteModify(cmd)
+	case NoteModifyInline:
+		return v.NoteModifyInline(cmd)
+	default:
+		return errors.Errorf("Unexpected command: %[1]T(%#[1]v)", cmd)
+	}
+}
+
+// Walk reads every command from the Frontend, and calls the method of
+// v for each.  It returns nil at the end of the stream, or if v
+// returns StopWalk.
+func Walk(f *Frontend, v Visitor) error {
+	for {
+		cmd, err := f.ReadCmd()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := Visit(v, cmd); err != nil {
+			if err == StopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// All returns an iterator over the commands from the Frontend, in the
+// style of iter.Seq2: it calls yield for each command, until yield
+// returns false or the stream ends.  The end of the stream is not
+// yielded; any other error is yielded (with a nil Cmd) as the last
+// pair.
+//
+//	f.All()(func(cmd Cmd, err error) bool {
+//		...
+//		return true
+//	})
+func (f *Frontend) All() func(yield func(Cmd, error) bool) {
+	return func(yield func(Cmd, error) bool) {
+		for {
+			cmd, err := f.ReadCmd()
+			if err == io.EOF {
+				return
+			}
+			if !yield(cmd, err) || err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Tests for Walk and All
+
+package libfastimport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const walkInput = `blob
+mark :1
+data 5
+test
+commit refs/heads/main
+mark :2
+committer Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 5
+test
+M 100644 :1 a.txt
+D b.txt
+
+tag v1
+from :2
+tagger Robert Cowham <rcowham@perforce.com> 1644399073 +0000
+data 4
+tag
+reset refs/heads/other
+from :2
+
+`
+
+type pathVisitor struct {
+	NopVisitor
+	paths   []Path
+	commits int
+	stopAt  Path
+}
+
+func (v *pathVisitor) Commit(cmd CmdCommit) error {
+	v.commits++
+	return nil
+}
+
+func (v *pathVisitor) CommitEnd() error {
+	v.commits--
+	return nil
+}
+
+func (v *pathVisitor) FileModify(cmd FileModify) error {
+	v.paths = append(v.paths, cmd.Path)
+	if cmd.Path == v.stopAt {
+		return StopWalk
+	}
+	return nil
+}
+
+func (v *pathVisitor) FileDelete(cmd FileDelete) error {
+	v.paths = append(v.paths, cmd.Path)
+	return nil
+}
+
+func TestWalk(t *testing.T) {
+	v := &pathVisitor{}
+	assert.Nil(t, Walk(NewFrontend(strings.NewReader(walkInput), nil, nil), v))
+	assert.Equal(t, []Path{"a.txt", "b.txt"}, v.paths)
+	assert.Equal(t, 0, v.commits)
+
+	// Stopping early.
+	v = &pathVisitor{stopAt: "a.txt"}
+	assert.Nil(t, Walk(NewFrontend(strings.NewReader(walkInput), nil, nil), v))
+	assert.Equal(t, []Path{"a.txt"}, v.paths)
+	assert.Equal(t, 1, v.commits)
+
+	// Errors from the Frontend.
+	err := Walk(NewFrontend(strings.NewReader("bogus\n"), nil, nil), &pathVisitor{})
+	assert.Equal(t, UnsupportedCommand("bogus\n"), err)
+}
+
+func TestAll(t *testing.T) {
+	var names []string
+	NewFrontend(strings.NewReader(walkInput), nil, nil).All()(func(cmd Cmd, err error) bool {
+		assert.Nil(t, err)
+		names = append(names, cmdName(cmd))
+		return true
+	})
+	assert.Equal(t, []string{"blob", "commit", "M", "D", "commit-end", "tag", "reset"}, names)
+
+	// Stopping early.
+	names = nil
+	NewFrontend(strings.NewReader(walkInput), nil, nil).All()(func(cmd Cmd, err error) bool {
+		names = append(names, cmdName(cmd))
+		return len(names) < 2
+	})
+	assert.Equal(t, []string{"blob", "commit"}, names)
+
+	// Errors are yielded last.
+	var errs []error
+	NewFrontend(strings.NewReader("blob\nmark :1\ndata 1\nx\nbogus\n"), nil, nil).All()(func(cmd Cmd, err error) bool {
+		errs = append(errs, err)
+		return true
+	})
+	assert.Equal(t, []error{nil, UnsupportedCommand("bogus\n")}, errs)
+}